@@ -0,0 +1,188 @@
+package wallet
+
+import (
+	"github.com/bitgoin/address"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+)
+
+// AccountSummary is an account of a wallet with its balance
+type AccountSummary struct {
+	Index   uint32
+	Balance uint64
+	// Used is true if the account has a transaction history
+	Used bool
+}
+
+// Index returns the index of the account in its derivation path. It is
+// zero for a watch-only account without the key origin.
+func (c CoinAccount) Index() uint32 {
+	return c.index
+}
+
+// HasHistory returns true if the account has any transaction or coin which
+// Discover or a broadcast transaction has recorded
+func (c CoinAccount) HasHistory() (bool, error) {
+	txs, err := c.store.GetTransactions()
+	if err != nil {
+		return false, err
+	}
+	if len(txs) > 0 {
+		return true, nil
+	}
+
+	utxos, err := c.store.GetAllUTXO()
+	if err != nil {
+		return false, err
+	}
+	for _, txs := range utxos {
+		if len(txs) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Accounts returns the accounts of the purpose and the coin which the data
+// file records a transaction history for. The accounts are scanned in the
+// order of their indexes until an account without a history, as BIP44
+// account discovery does, and the first account is always returned.
+func (w Wallet) Accounts(purpose Purpose, ct CoinType, test Test) ([]*AccountSummary, error) {
+	return w.scanAccounts(purpose, ct, test, nil)
+}
+
+// DiscoverAccounts syncs the accounts of the purpose and the coin from the
+// network by the agent, in the order of their indexes until an account
+// without a transaction history, as BIP44 account discovery does. It
+// returns the accounts which are used, and always the first one, so that
+// restoring a seed of another BIP44 wallet finds all of its coins. BIP44
+// accounts are derived unhardened as the earlier versions of this wallet
+// did, so the seeds of the other wallets are restored by the other
+// purposes, whose accounts are derived hardened.
+func (w Wallet) DiscoverAccounts(purpose Purpose, ct CoinType, test Test, a agent.CoinAgent) ([]*AccountSummary, error) {
+	return w.scanAccounts(purpose, ct, test, a)
+}
+
+// accountLookahead is the number of the accounts whose first addresses
+// are rescanned together by DiscoverAccounts, since each rescan goes
+// through the whole chain
+const accountLookahead = 5
+
+// scanAccounts summarizes the accounts until an unused one. The accounts
+// are discovered first if an agent is given. They are opened one at a time
+// since an account locks the data file until it is closed.
+func (w Wallet) scanAccounts(purpose Purpose, ct CoinType, test Test, a agent.CoinAgent) ([]*AccountSummary, error) {
+	summaries := make([]*AccountSummary, 0)
+	rescanned := make(map[uint32]uint32)
+	for i := uint32(0); i < address.HardenedKeyStart; i++ {
+		if a != nil && i%accountLookahead == 0 {
+			if err := w.rescanAccounts(purpose, ct, test, i, a, rescanned); err != nil {
+				return nil, err
+			}
+		}
+		summary, err := w.summarizeAccount(purpose, ct, test, i, a, rescanned[i])
+		if err != nil {
+			return nil, err
+		}
+		if !summary.Used && i > 0 {
+			break
+		}
+		summaries = append(summaries, summary)
+		if !summary.Used {
+			break
+		}
+	}
+	return summaries, nil
+}
+
+// rescanAccounts rescans the addresses within the gap limit of the next
+// accountLookahead accounts which have never been synced, in one rescan,
+// and records the numbers of the rescanned addresses of each chain. Discover
+// rescans the later addresses as the gap moves forward.
+func (w Wallet) rescanAccounts(purpose Purpose, ct CoinType, test Test, from uint32, a agent.CoinAgent, rescanned map[uint32]uint32) error {
+	addrs := make([]string, 0)
+	counts := make(map[uint32]uint32)
+	for i := from; i < from+accountLookahead; i++ {
+		c, err := w.Account(purpose, ct, test, i)
+		if err != nil {
+			return err
+		}
+		accountAddrs, err := c.restoredAddresses()
+		c.Close()
+		if err != nil {
+			return err
+		}
+		if len(accountAddrs) > 0 {
+			addrs = append(addrs, accountAddrs...)
+			counts[i] = uint32(len(accountAddrs) / 2)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	if err := a.RescanAddresses(addrs); err != nil {
+		return err
+	}
+	for i, n := range counts {
+		rescanned[i] = n
+	}
+	return nil
+}
+
+// restoredAddresses returns the addresses within the gap limit of both
+// chains of an account which has never been synced
+func (c CoinAccount) restoredAddresses() ([]string, error) {
+	state, err := c.LastSync()
+	if err != nil {
+		return nil, err
+	}
+	if !state.Time.IsZero() {
+		return nil, nil
+	}
+
+	gapLimit, err := c.GapLimit()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, 2*gapLimit)
+	for _, change := range []bool{false, true} {
+		for i := uint32(0); i < gapLimit; i++ {
+			addr, err := c.Address(i, change)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// summarizeAccount opens an account of the wallet, discovers it if an agent
+// is given, and returns its summary. The first addresses of each chain up
+// to rescanned are already rescanned by the agent.
+func (w Wallet) summarizeAccount(purpose Purpose, ct CoinType, test Test, index uint32, a agent.CoinAgent, rescanned uint32) (*AccountSummary, error) {
+	c, err := w.Account(purpose, ct, test, index)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if a != nil {
+		c.SetAgent(a)
+		c.rescanned = rescanned
+		if err := c.Discover(); err != nil {
+			return nil, err
+		}
+	}
+
+	used, err := c.HasHistory()
+	if err != nil {
+		return nil, err
+	}
+	balance, err := c.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+	return &AccountSummary{Index: index, Balance: balance, Used: used}, nil
+}
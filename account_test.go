@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// accountAddress returns the first external address of an account
+func accountAddress(t *testing.T, w *Wallet, index uint32) string {
+	account, err := w.Account(BIP84, BTC, true, index)
+	assert.NoError(t, err)
+	defer account.Close()
+	assert.Equal(t, index, account.Index())
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	return addr
+}
+
+func TestDiscoverAccounts(t *testing.T) {
+	w := newTestWallet(t)
+
+	// nothing is recorded before the discovery
+	accounts, err := w.Accounts(BIP84, BTC, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*AccountSummary{{Index: 0}}, accounts)
+
+	// the first account has spent all its coins, the second one has a coin
+	// and the fourth one is left out after the unused third one
+	addr0 := accountAddress(t, w, 0)
+	addr1 := accountAddress(t, w, 1)
+	addr3 := accountAddress(t, w, 3)
+	a := &fakeAgent{
+		unspent: map[string]tx.UTXOs{
+			addr1: {testUTXO(1, 20000, 3, "")},
+			addr3: {testUTXO(3, 30000, 3, "")},
+		},
+		history: []*agent.TxEntry{
+			{TxId: "5b35f3d330dbad503f2b26313b6ac0dceb7907186303ba7c7d3ab845c598e0e6", Address: addr0,
+				Category: "receive", Amount: 10000, Confirmations: 10, BlockHeight: 2500000,
+				BlockTime: 1700000000, Time: 1699999000},
+		},
+	}
+	accounts, err = w.DiscoverAccounts(BIP84, BTC, true, a)
+	assert.NoError(t, err)
+	assert.Equal(t, []*AccountSummary{
+		{Index: 0, Used: true},
+		{Index: 1, Balance: 20000, Used: true},
+	}, accounts)
+
+	// the discovered accounts are listed without the network
+	accounts, err = w.Accounts(BIP84, BTC, true)
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 2)
+	assert.Equal(t, uint64(20000), accounts[1].Balance)
+}
+
+// accountAddresses returns the external addresses of an account at the
+// indexes
+func accountAddresses(t *testing.T, w *Wallet, index uint32, indexes ...uint32) []string {
+	account, err := w.Account(BIP84, BTC, true, index)
+	assert.NoError(t, err)
+	defer account.Close()
+	addrs := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		addr, err := account.Address(i, false)
+		assert.NoError(t, err)
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func TestDiscoverRestoredAccounts(t *testing.T) {
+	w := newTestWallet(t)
+
+	// the seed is restored, and the agent only finds the coins of the
+	// first three accounts after rescanning their addresses. The coins
+	// of the first and the third accounts reach beyond the first gap.
+	addrs0 := accountAddresses(t, w, 0, 0, 4, 8)
+	addrs2 := accountAddresses(t, w, 2, 3, 7)
+	a := &fakeAgent{
+		unspent: map[string]tx.UTXOs{},
+		restored: map[string]tx.UTXOs{
+			addrs0[0]:               {testUTXO(0, 10000, 3, "")},
+			addrs0[1]:               {testUTXO(1, 10000, 3, "")},
+			addrs0[2]:               {testUTXO(2, 10000, 3, "")},
+			accountAddress(t, w, 1): {testUTXO(3, 20000, 3, "")},
+			addrs2[0]:               {testUTXO(4, 15000, 3, "")},
+			addrs2[1]:               {testUTXO(5, 15000, 3, "")},
+		},
+	}
+	accounts, err := w.DiscoverAccounts(BIP84, BTC, true, a)
+	assert.NoError(t, err)
+	assert.Equal(t, []*AccountSummary{
+		{Index: 0, Balance: 30000, Used: true},
+		{Index: 1, Balance: 20000, Used: true},
+		{Index: 2, Balance: 30000, Used: true},
+	}, accounts)
+	assert.Empty(t, a.restored)
+
+	// the first gaps of the accounts are rescanned at once, and then
+	// each gap which the used addresses move forward
+	assert.Len(t, a.rescans, 6)
+	assert.Len(t, a.rescans[0], accountLookahead*2*AddressGap)
+	assert.Equal(t, accountAddresses(t, w, 0, 5, 6, 7, 8, 9), a.rescans[1])
+	assert.Equal(t, accountAddresses(t, w, 0, 10, 11, 12, 13), a.rescans[2])
+
+	// the synced accounts are not rescanned again, only the one after
+	// the unused account which is looked ahead
+	a.rescans = nil
+	accounts, err = w.DiscoverAccounts(BIP84, BTC, true, a)
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 3)
+	assert.Len(t, a.rescans, 1)
+	assert.Len(t, a.rescans[0], 2*AddressGap)
+}
+
+// bip84Address derives the first external address of a BIP84 testnet
+// account as the other wallets do, by m / 84' / 0' / account' / 0 / 0
+func bip84Address(t *testing.T, account uint32) string {
+	seed, err := hex.DecodeString(seedHex)
+	assert.NoError(t, err)
+	key, err := hdkeychain.NewMaster(seed, &chaincfg.TestNet3Params)
+	assert.NoError(t, err)
+	for _, i := range []uint32{84 + hdkeychain.HardenedKeyStart, hdkeychain.HardenedKeyStart,
+		account + hdkeychain.HardenedKeyStart, 0, 0} {
+		key, err = key.Derive(i)
+		assert.NoError(t, err)
+	}
+	pubKey, err := key.ECPubKey()
+	assert.NoError(t, err)
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), &chaincfg.TestNet3Params)
+	assert.NoError(t, err)
+	return addr.EncodeAddress()
+}
+
+func TestDiscoverAccountsOfAnotherWallet(t *testing.T) {
+	w := newTestWallet(t)
+
+	// the accounts of another wallet of the seed are found by the hardened
+	// purposes, but not by the unhardened BIP44 accounts
+	restored := map[string]tx.UTXOs{}
+	for i := uint32(0); i < 3; i++ {
+		addr := bip84Address(t, i)
+		assert.Equal(t, accountAddress(t, w, i), addr)
+		restored[addr] = tx.UTXOs{testUTXO(byte(i), 10000, 3, "")}
+	}
+	a := &fakeAgent{unspent: map[string]tx.UTXOs{}, restored: restored}
+
+	accounts, err := w.DiscoverAccounts(BIP44, BTC, true, a)
+	assert.NoError(t, err)
+	assert.Equal(t, []*AccountSummary{{Index: 0}}, accounts)
+
+	accounts, err = w.DiscoverAccounts(BIP84, BTC, true, a)
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 3)
+	for _, account := range accounts {
+		assert.Equal(t, uint64(10000), account.Balance)
+	}
+}
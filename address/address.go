@@ -8,8 +8,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"strings"
 
 	"github.com/bitmark-inc/bitmarkd/util"
+	"github.com/btcsuite/btcd/btcutil/bech32"
 )
 
 // to hold the type of the address
@@ -32,17 +34,102 @@ const (
 	//LtcTestnetScript  Version = 196
 	LtcTestnetScript2 Version = 58
 
+	// bech32 addresses carry a human readable part instead of a version
+	// byte, so these values are picked outside of the base58 prefixes
+	BtcLivenetWitness Version = 0xf0
+	BtcTestnetWitness Version = 0xf1
+	LtcLivenetWitness Version = 0xf2
+	LtcTestnetWitness Version = 0xf3
+
 	vNull Version = 0xff
 
 	expectedAddressLenght = 25
 )
 
+// from: https://github.com/satoshilabs/slips/blob/master/slip-0173.md
+var witnessHRP = map[string]Version{
+	"bc":   BtcLivenetWitness,
+	"tb":   BtcTestnetWitness,
+	"ltc":  LtcLivenetWitness,
+	"tltc": LtcTestnetWitness,
+}
+
+// IsWitnessAddress reports whether the address is bech32 encoded
+// with a known human readable part
+func IsWitnessAddress(address string) bool {
+	i := strings.LastIndexByte(address, '1')
+	if i < 1 {
+		return false
+	}
+	_, ok := witnessHRP[strings.ToLower(address[:i])]
+	return ok
+}
+
+// decode a bech32 or bech32m address and return its version, witness
+// version and witness program
+func ValidateWitnessAddress(address string) (Version, byte, []byte, error) {
+	hrp, data, bech32Version, err := bech32.DecodeGeneric(address)
+	if err != nil {
+		return vNull, 0, nil, err
+	}
+
+	version, ok := witnessHRP[hrp]
+	if !ok {
+		return vNull, 0, nil, fmt.Errorf("address human readable part: %s is invalid", hrp)
+	}
+
+	if len(data) < 1 {
+		return vNull, 0, nil, fmt.Errorf("address has no witness version")
+	}
+	// BIP350: version 0 uses bech32 and the later versions use bech32m
+	witnessVersion := data[0]
+	switch witnessVersion {
+	case 0:
+		if bech32Version != bech32.Version0 {
+			return vNull, 0, nil, fmt.Errorf("witness version 0 must be encoded with bech32")
+		}
+	case 1:
+		if bech32Version != bech32.VersionM {
+			return vNull, 0, nil, fmt.Errorf("witness version 1 must be encoded with bech32m")
+		}
+	default:
+		return vNull, 0, nil, fmt.Errorf("witness version: %d is not supported", witnessVersion)
+	}
+
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return vNull, 0, nil, err
+	}
+
+	switch {
+	case witnessVersion == 0 && (len(program) == 20 || len(program) == 32):
+	case witnessVersion == 1 && len(program) == 32:
+	default:
+		return vNull, 0, nil, fmt.Errorf("witness program length: %d is invalid", len(program))
+	}
+
+	return version, witnessVersion, program, nil
+}
+
 // check the address and return its version
 func ValidateAddress(address string) (Version, AddressBytes, error) {
 
-	addr := util.FromBase58(address)
 	addressBytes := AddressBytes{}
 
+	if IsWitnessAddress(address) {
+		version, witnessVersion, program, err := ValidateWitnessAddress(address)
+		if err != nil {
+			return vNull, addressBytes, err
+		}
+		if witnessVersion != 0 || len(program) != len(addressBytes) {
+			return vNull, addressBytes, fmt.Errorf("address is not a witness public key hash")
+		}
+		copy(addressBytes[:], program)
+		return version, addressBytes, nil
+	}
+
+	addr := util.FromBase58(address)
+
 	if expectedAddressLenght != len(addr) {
 		return vNull, addressBytes, fmt.Errorf("address bytes length: %d expected: %d", len(addr), expectedAddressLenght)
 	}
@@ -0,0 +1,57 @@
+package address
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLegacyAddress(t *testing.T) {
+	version, _, err := ValidateAddress("mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb")
+	assert.NoError(t, err)
+	assert.Equal(t, BtcTestnet, version)
+}
+
+func TestValidateWitnessAddress(t *testing.T) {
+	version, addr, err := ValidateAddress("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4")
+	assert.NoError(t, err)
+	assert.Equal(t, BtcLivenetWitness, version)
+	assert.Equal(t, "751e76e8199196d454941c45d1b3a323f1433bd6", hex.EncodeToString(addr[:]))
+
+	version, witnessVersion, program, err := ValidateWitnessAddress("tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7")
+	assert.NoError(t, err)
+	assert.Equal(t, BtcTestnetWitness, version)
+	assert.Equal(t, byte(0), witnessVersion)
+	assert.Len(t, program, 32)
+
+	// a witness script hash is not able to fit in the address bytes
+	_, _, err = ValidateAddress("tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7")
+	assert.Error(t, err)
+}
+
+func TestValidateInvalidWitnessAddress(t *testing.T) {
+	// invalid checksum
+	_, _, _, err := ValidateWitnessAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5")
+	assert.Error(t, err)
+
+	// unknown human readable part
+	assert.False(t, IsWitnessAddress("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"))
+	assert.False(t, IsWitnessAddress("1DURpDjr49tUbbMhQsG1jeAA6dq5Z5fF3p"))
+}
+
+func TestValidateTaprootAddress(t *testing.T) {
+	version, witnessVersion, program, err := ValidateWitnessAddress("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0")
+	assert.NoError(t, err)
+	assert.Equal(t, BtcLivenetWitness, version)
+	assert.Equal(t, byte(1), witnessVersion)
+	assert.Equal(t, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", hex.EncodeToString(program))
+
+	// a taproot output key is not able to fit in the address bytes
+	_, _, err = ValidateAddress("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0")
+	assert.Error(t, err)
+
+	// witness version 1 encoded with the bech32 checksum
+	_, _, _, err = ValidateWitnessAddress("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package wallet
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChainIndex is the state of the addresses of a chain of an account, the
+// external one for receiving and the internal one for the changes
+type ChainIndex struct {
+	// Issued is the number of the addresses handed out
+	Issued uint32
+	// Used is the number of the addresses up to the last one which has a
+	// transaction
+	Used uint32
+	// Scanned is the number of the addresses which Discover has watched,
+	// where the next one resumes
+	Scanned uint32
+}
+
+// Known returns the number of the addresses of the chain which the account
+// watches. The first address is always watched since Discover always scans
+// it.
+func (i ChainIndex) Known() uint32 {
+	known := i.Issued
+	if i.Used > known {
+		known = i.Used
+	}
+	if known == 0 {
+		known = 1
+	}
+	return known
+}
+
+// Unused returns the number of the addresses handed out after the last used
+// one
+func (i ChainIndex) Unused() uint32 {
+	if i.Issued <= i.Used {
+		return 0
+	}
+	return i.Issued - i.Used
+}
+
+// IssuedAddress is an address which the account has handed out
+type IssuedAddress struct {
+	Address string
+	Change  bool
+	Index   uint32
+	Time    time.Time
+	// Used is true once Discover finds a transaction of the address or of
+	// an address after it
+	Used bool
+}
+
+// ChainIndex returns the indexes of the external or the change chain
+func (c CoinAccount) ChainIndex(change bool) (ChainIndex, error) {
+	return c.store.GetChainIndex(change)
+}
+
+// NewExternalAddr hands out the next unused external address. An address
+// is never handed out twice, even by the copies of the account used at the
+// same time.
+func (c CoinAccount) NewExternalAddr() (string, error) {
+	addr, _, err := c.issueAddress(false)
+	return addr, err
+}
+
+// NewChangeAddr hands out the next unused change address
+func (c CoinAccount) NewChangeAddr() (string, error) {
+	addr, _, err := c.issueAddress(true)
+	return addr, err
+}
+
+// txAddress is an address handed out for a transaction which is being
+// built, the change or the destination of a sweep
+type txAddress struct {
+	address string
+	change  bool
+	index   uint32
+	script  []byte
+}
+
+// newChangeAddress hands out the next unused change address for a
+// transaction. It is given back by releaseAddress unless the transaction
+// pays a change to it.
+func (c CoinAccount) newChangeAddress() (*txAddress, error) {
+	return c.newTxAddress(true)
+}
+
+// newTxAddress hands out the next unused address of a chain for
+// a transaction
+func (c CoinAccount) newTxAddress(change bool) (*txAddress, error) {
+	addr, i, err := c.issueAddress(change)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := btcutil.DecodeAddress(addr, c.net)
+	if err != nil {
+		return nil, err
+	}
+	script, err := txscript.PayToAddrScript(decoded)
+	if err != nil {
+		return nil, err
+	}
+	return &txAddress{address: addr, change: change, index: i, script: script}, nil
+}
+
+// releaseAddress gives back the address unless the transaction, which is
+// nil if it is not made, pays to it. The transactions which fail or have
+// no change then leave no unused address behind, which would count towards
+// the gap limit.
+func (c CoinAccount) releaseAddress(a *txAddress, t *wire.MsgTx) {
+	if t != nil {
+		for _, txOut := range t.TxOut {
+			if bytes.Equal(txOut.PkScript, a.script) {
+				return
+			}
+		}
+	}
+	if err := c.store.ReleaseIndex(a.change, a.index, a.address); err != nil {
+		log.WithError(err).WithField("address", a.address).Warn("unable to release address")
+	}
+}
+
+// issueAddress counts the next address of a chain as issued and keeps it in
+// the store. It warns once more unused addresses are issued than the gap
+// limit, since restoring the seed would not discover the coins sent
+// to the addresses after the gap.
+func (c CoinAccount) issueAddress(change bool) (string, uint32, error) {
+	i, err := c.store.IssueIndex(change)
+	if err != nil {
+		return "", 0, err
+	}
+	addr, err := c.Address(i, change)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := c.store.SetIssuedAddress(&IssuedAddress{
+		Address: addr,
+		Change:  change,
+		Index:   i,
+		Time:    time.Now(),
+	}); err != nil {
+		return "", 0, err
+	}
+
+	index, err := c.store.GetChainIndex(change)
+	if err != nil {
+		return "", 0, err
+	}
+	gapLimit, err := c.GapLimit()
+	if err != nil {
+		return "", 0, err
+	}
+	if index.Unused() > gapLimit {
+		log.WithField("change", change).WithField("unused", index.Unused()).
+			Warn("unused addresses exceed the gap limit")
+	}
+	return addr, i, nil
+}
+
+// IssuedAddresses returns the addresses which the account has handed out,
+// the external ones first, in the order of their indexes
+func (c CoinAccount) IssuedAddresses() ([]*IssuedAddress, error) {
+	addrs, err := c.store.GetIssuedAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[bool]ChainIndex)
+	for _, change := range []bool{false, true} {
+		index, err := c.store.GetChainIndex(change)
+		if err != nil {
+			return nil, err
+		}
+		indexes[change] = index
+	}
+	for _, a := range addrs {
+		a.Used = a.Index < indexes[a.Change].Used
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].Change != addrs[j].Change {
+			return !addrs[i].Change
+		}
+		return addrs[i].Index < addrs[j].Index
+	})
+	return addrs, nil
+}
+
+// AddressUsed returns true if an address is handed out and Discover has
+// found a transaction of it or of an address after it
+func (c CoinAccount) AddressUsed(addr string) (bool, error) {
+	a, err := c.store.GetIssuedAddress(addr)
+	if err != nil || a == nil {
+		return false, err
+	}
+	index, err := c.store.GetChainIndex(a.Change)
+	if err != nil {
+		return false, err
+	}
+	return a.Index < index.Used, nil
+}
@@ -0,0 +1,203 @@
+package wallet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+func TestIssueAddresses(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+
+	// the addresses of the copies of the account used at the same time are
+	// all different
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	issued := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := account.NewExternalAddr()
+			assert.NoError(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			issued[addr] = true
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, issued, 8)
+	for i := uint32(0); i < 8; i++ {
+		addr, err := account.Address(i, false)
+		assert.NoError(t, err)
+		assert.True(t, issued[addr], addr)
+	}
+
+	changeAddr, err := account.NewChangeAddr()
+	assert.NoError(t, err)
+	expected, err := account.Address(0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, changeAddr)
+
+	index, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ChainIndex{Issued: 8}, index)
+	assert.Equal(t, uint32(8), index.Unused())
+
+	// a coin of an address issued after the address gap is still discovered
+	lastAddr, err := account.Address(7, false)
+	assert.NoError(t, err)
+	a := &fakeAgent{unspent: map[string]tx.UTXOs{lastAddr: {testUTXO(1, 20000, 3, "")}}}
+	account.SetAgent(a)
+	assert.NoError(t, account.Discover())
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20000), balance)
+
+	used, err := account.AddressUsed(lastAddr)
+	assert.NoError(t, err)
+	assert.True(t, used)
+	used, err = account.AddressUsed(changeAddr)
+	assert.NoError(t, err)
+	assert.False(t, used)
+	account.Close()
+
+	// the issued addresses are kept after the account is reopened
+	account, err = w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+	addrs, err := account.IssuedAddresses()
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 9)
+	assert.Equal(t, lastAddr, addrs[7].Address)
+	assert.True(t, addrs[7].Used)
+	assert.Equal(t, changeAddr, addrs[8].Address)
+	assert.True(t, addrs[8].Change)
+	assert.False(t, addrs[8].Used)
+
+	addr, err := account.NewExternalAddr()
+	assert.NoError(t, err)
+	expected, err = account.Address(8, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, addr)
+}
+
+func TestIssueAfterUsedAddresses(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	// a restored account hands out the addresses after the used ones
+	usedAddr, err := account.Address(2, false)
+	assert.NoError(t, err)
+	account.SetAgent(&fakeAgent{unspent: map[string]tx.UTXOs{usedAddr: {testUTXO(1, 20000, 3, "")}}})
+	assert.NoError(t, account.Discover())
+
+	addr, err := account.NewExternalAddr()
+	assert.NoError(t, err)
+	expected, err := account.Address(3, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, addr)
+}
+
+func TestLegacyLastIndex(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	store := account.store.(*BoltAccountStore)
+	assert.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(store.account)).Put([]byte("lastIndex"), util.ToVarint64(3))
+	}))
+
+	// the external address after the last index was handed out before
+	index, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ChainIndex{Issued: 5, Used: 4}, index)
+	index, err = account.ChainIndex(true)
+	assert.NoError(t, err)
+	assert.Equal(t, ChainIndex{Issued: 4, Used: 4}, index)
+
+	addr, err := account.NewExternalAddr()
+	assert.NoError(t, err)
+	expected, err := account.Address(5, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, addr)
+	addr, err = account.NewChangeAddr()
+	assert.NoError(t, err)
+	expected, err = account.Address(4, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, addr)
+}
+
+func TestReleaseChangeAddress(t *testing.T) {
+	account, a, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	assertIssued := func(issued uint32) {
+		index, err := account.ChainIndex(true)
+		assert.NoError(t, err)
+		assert.Equal(t, issued, index.Issued)
+		addrs, err := account.IssuedAddresses()
+		assert.NoError(t, err)
+		changes := 0
+		for _, addr := range addrs {
+			if addr.Change {
+				changes++
+			}
+		}
+		assert.Equal(t, int(issued), changes)
+	}
+
+	// neither a failed send nor one without a change keeps its change
+	// address
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 200000}}
+	_, _, err := account.Send(sends, nil, 1000)
+	assert.Equal(t, ErrNotEnoughCoin, err)
+	assertIssued(0)
+
+	a.sendErr = fmt.Errorf("rejected")
+	sends[0].Amount = 50000
+	_, _, err = account.Send(sends, nil, 1000)
+	assert.Error(t, err)
+	assertIssued(0)
+	a.sendErr = nil
+
+	sends[0].SubtractFee = true
+	sends[0].Amount = 100000
+	_, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, deserializeTx(t, rawTx).TxOut, 1)
+	assertIssued(0)
+
+	_, err = account.CreatePSBT([]*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 200000}}, nil, 1000)
+	assert.Equal(t, ErrNotEnoughCoin, err)
+	assertIssued(0)
+
+	// the change address of a sent change is kept
+	changeAddr, err := account.Address(0, true)
+	assert.NoError(t, err)
+	assert.NoError(t, account.store.SetUTXO(changeAddr, tx.UTXOs{testUTXO(2, 80000, 1, "")}))
+	_, rawTx, err = account.Send([]*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 30000}}, nil, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, deserializeTx(t, rawTx).TxOut, 2)
+	assertIssued(1)
+
+	// an index is not released once another one is issued after it
+	second, err := account.NewChangeAddr()
+	assert.NoError(t, err)
+	_, err = account.NewChangeAddr()
+	assert.NoError(t, err)
+	assert.NoError(t, account.store.ReleaseIndex(true, 1, second))
+	assertIssued(3)
+}
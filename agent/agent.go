@@ -6,7 +6,8 @@ import (
 )
 
 var (
-	ErrNoTxForAddr = fmt.Errorf("no transaction for the address")
+	ErrNoTxForAddr   = fmt.Errorf("no transaction for the address")
+	ErrNoFeeEstimate = fmt.Errorf("no fee estimate for the confirmation target")
 )
 
 type ErrQueryFailure struct {
@@ -17,10 +18,64 @@ func (e ErrQueryFailure) Error() string {
 	return fmt.Sprintf("fail to query from server: %s", e.message)
 }
 
+// MempoolEntry is the size and the fee of a transaction in the mempool
+type MempoolEntry struct {
+	// VSize is the virtual size in vbytes
+	VSize uint64
+	// Fee is in satoshis
+	Fee uint64
+	// AncestorVSize and AncestorFee are the size and the fee of the
+	// transaction together with all of its unconfirmed ancestors
+	AncestorVSize uint64
+	AncestorFee   uint64
+}
+
+// TxEntry is a payment of a transaction of the watched addresses
+type TxEntry struct {
+	TxId    string
+	Address string
+	// Category is "send" for the payments of the transactions which spend
+	// the coins of the watched addresses, and "receive" for the payments to
+	// the watched addresses
+	Category string
+	// Amount is in satoshis and negative for a send
+	Amount int64
+	// Fee is in satoshis and only set for a send
+	Fee uint64
+	// Confirmations is negative if the transaction conflicts with a
+	// confirmed one
+	Confirmations int64
+	BlockHeight   uint64
+	// BlockTime and Time, when the transaction is first seen, are in UNIX
+	// times
+	BlockTime int64
+	Time      int64
+}
+
 type CoinAgent interface {
 	ListAllUnspent() (map[string]tx.UTXOs, error)
 	WatchAddress(addr string) error
+	// RescanAddresses watches the addresses and finds their past
+	// transactions, which WatchAddress does not for the addresses used
+	// before they are watched
+	RescanAddresses(addrs []string) error
 	Send(string) (string, error)
+	// GetRawTransaction returns the hex encoded transaction of a txid
+	GetRawTransaction(txId string) (string, error)
+	// GetMempoolEntry returns the size and the fee of a transaction which
+	// is in the mempool
+	GetMempoolEntry(txId string) (*MempoolEntry, error)
+	// EstimateFee returns the fee per kB in satoshis for a transaction to
+	// be confirmed within the number of blocks
+	EstimateFee(confTarget uint32) (uint64, error)
+	// ScanUnspent returns the UTXOs of addresses which are not watched,
+	// with their pkScripts
+	ScanUnspent(addrs []string) (tx.UTXOs, error)
+	// ListTransactions returns the payments of all the transactions of the
+	// watched addresses
+	ListTransactions() ([]*TxEntry, error)
+	// GetBlockCount returns the height of the best block
+	GetBlockCount() (uint64, error)
 }
 
 func reverseByte(b []byte) []byte {
@@ -5,20 +5,28 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/bitmark-inc/bitmark-wallet/address"
 	"github.com/bitmark-inc/bitmark-wallet/tx"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
 	ErrImportAddress = fmt.Errorf("fail to import address")
+	ErrLegacyWallet  = fmt.Errorf("the legacy wallet of the daemon is not able to watch bech32m addresses, use a descriptor wallet")
 )
 
-var lastWatchedAddressesRefresh time.Time
-var watchedAddressList []ReceivedAddress
+// scanTimeout bounds a scan of the whole UTXO set which takes much longer
+// than the other requests
+const scanTimeout = 10 * time.Minute
+
+// watchedAddressesTTL is how long the watched addresses are cached
+const watchedAddressesTTL = 15 * time.Second
 
 type RPCParam struct {
 	Method string        `json:"method"`
@@ -35,6 +43,79 @@ type RPCUTXO struct {
 	Address string  `json:"address"`
 	Index   uint32  `json:"vout"`
 	Value   float64 `json:"amount"`
+
+	Confirmations uint64 `json:"confirmations"`
+}
+
+// RPCWalletInfo is the result of getwalletinfo. The daemons before the
+// descriptor wallets do not report descriptors.
+type RPCWalletInfo struct {
+	Descriptors bool `json:"descriptors"`
+}
+
+type DescriptorInfo struct {
+	Descriptor string `json:"descriptor"`
+}
+
+type ImportDescriptorResult struct {
+	Success bool      `json:"success"`
+	Error   *RPCError `json:"error"`
+}
+
+type RPCTransaction struct {
+	TxId string `json:"txid"`
+	Hex  string `json:"hex"`
+}
+
+// RPCMempoolEntry is the result of getmempoolentry. The daemons before
+// the segregated witness report only the size and the fee, which are
+// replaced by the vsize and the base fee in the later versions. The fees
+// of the ancestors are in satoshis in the older versions and in coins
+// under fees in the later ones.
+type RPCMempoolEntry struct {
+	VSize        uint64   `json:"vsize"`
+	Size         uint64   `json:"size"`
+	Fee          *float64 `json:"fee"`
+	AncestorSize uint64   `json:"ancestorsize"`
+	AncestorFees *uint64  `json:"ancestorfees"`
+	Fees         *struct {
+		Base     float64  `json:"base"`
+		Ancestor *float64 `json:"ancestor"`
+	} `json:"fees"`
+}
+
+// RPCFeeEstimate is the result of estimatesmartfee. The fee rate is in
+// coins per kB and is absent when there is not enough data to estimate.
+type RPCFeeEstimate struct {
+	FeeRate *float64 `json:"feerate"`
+	Errors  []string `json:"errors"`
+	Blocks  uint32   `json:"blocks"`
+}
+
+// RPCScanResult is the result of scantxoutset
+type RPCScanResult struct {
+	Success  bool   `json:"success"`
+	Height   uint64 `json:"height"`
+	Unspents []struct {
+		TxId         string  `json:"txid"`
+		Index        uint32  `json:"vout"`
+		ScriptPubKey string  `json:"scriptPubKey"`
+		Amount       float64 `json:"amount"`
+		Height       uint64  `json:"height"`
+	} `json:"unspents"`
+}
+
+// RPCTxEntry is an entry of listtransactions
+type RPCTxEntry struct {
+	TxId          string  `json:"txid"`
+	Address       string  `json:"address"`
+	Category      string  `json:"category"`
+	Amount        float64 `json:"amount"`
+	Fee           float64 `json:"fee"`
+	Confirmations int64   `json:"confirmations"`
+	BlockHeight   uint64  `json:"blockheight"`
+	BlockTime     int64   `json:"blocktime"`
+	Time          int64   `json:"time"`
 }
 
 type ReceivedAddress struct {
@@ -48,11 +129,29 @@ type RPCResponse struct {
 	Error  *RPCError       `json:"error"`
 }
 
+// watchedAddresses caches the addresses which the daemon watches. It is
+// shared by the copies of an agent.
+type watchedAddresses struct {
+	sync.Mutex
+	list        []ReceivedAddress
+	lastRefresh time.Time
+}
+
+// walletType caches whether the wallet of the daemon is a descriptor
+// wallet. It is shared by the copies of an agent.
+type walletType struct {
+	sync.Mutex
+	known       bool
+	descriptors bool
+}
+
 type DaemonAgent struct {
-	apiUrl   string
-	username string
-	password string
-	client   *http.Client
+	apiUrl     string
+	username   string
+	password   string
+	client     *http.Client
+	watched    *watchedAddresses
+	walletType *walletType
 }
 
 func (da DaemonAgent) jsonRPC(p RPCParam) (*RPCResponse, error) {
@@ -85,9 +184,13 @@ func (da DaemonAgent) jsonRPC(p RPCParam) (*RPCResponse, error) {
 	return v, nil
 }
 
-func (da DaemonAgent) getAllWatchedAddress() error {
-	if watchedAddressList != nil && time.Since(lastWatchedAddressesRefresh) < 15*time.Second {
-		return nil
+// getAllWatchedAddress returns the addresses which the daemon watches. They
+// are refreshed if the cache is older than watchedAddressesTTL.
+func (da DaemonAgent) getAllWatchedAddress() ([]ReceivedAddress, error) {
+	da.watched.Lock()
+	defer da.watched.Unlock()
+	if da.watched.list != nil && time.Since(da.watched.lastRefresh) < watchedAddressesTTL {
+		return da.watched.list, nil
 	}
 
 	p := RPCParam{
@@ -97,34 +200,144 @@ func (da DaemonAgent) getAllWatchedAddress() error {
 
 	v, err := da.jsonRPC(p)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = json.Unmarshal(v.Result, &watchedAddressList)
+	var list []ReceivedAddress
+	err = json.Unmarshal(v.Result, &list)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	lastWatchedAddressesRefresh = time.Now()
-	log.WithField("timestamp", lastWatchedAddressesRefresh).Debug("refresh watched addresses")
-	return err
+	da.watched.list = list
+	da.watched.lastRefresh = time.Now()
+	log.WithField("timestamp", da.watched.lastRefresh).Debug("refresh watched addresses")
+	return list, nil
+}
+
+// isDescriptorWallet returns true if the wallet of the daemon is
+// a descriptor wallet. A descriptor wallet only imports descriptors and
+// a legacy wallet only imports addresses, so all the addresses are
+// imported in the way of the wallet type, which is asked once.
+func (da DaemonAgent) isDescriptorWallet() (bool, error) {
+	da.walletType.Lock()
+	defer da.walletType.Unlock()
+	if da.walletType.known {
+		return da.walletType.descriptors, nil
+	}
+
+	v, err := da.jsonRPC(RPCParam{
+		Method: "getwalletinfo",
+		Params: []interface{}{},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var info RPCWalletInfo
+	if err := json.Unmarshal(v.Result, &info); err != nil {
+		return false, err
+	}
+	da.walletType.known = true
+	da.walletType.descriptors = info.Descriptors
+	log.WithField("descriptors", info.Descriptors).Debug("daemon wallet type")
+	return info.Descriptors, nil
 }
 
 func (da DaemonAgent) importAddress(addr string) error {
+	descriptors, err := da.isDescriptorWallet()
+	if err != nil {
+		return err
+	}
+	if descriptors {
+		return da.importDescriptors([]string{fmt.Sprintf("addr(%s)", addr)}, "now")
+	}
+
+	// importaddress refuses bech32m addresses
+	if bech32m, err := isBech32m(addr); err != nil {
+		return err
+	} else if bech32m {
+		return ErrLegacyWallet
+	}
+
 	p := RPCParam{
 		Method: "importaddress",
 		Params: []interface{}{addr, "bitmark-wallet watched", false},
 	}
-	_, err := da.jsonRPC(p)
+	_, err = da.jsonRPC(p)
 	return err
 }
 
-func (da DaemonAgent) isAddressUsed(address string) (bool, error) {
-	if len(watchedAddressList) == 0 {
+// isBech32m returns true for the addresses of a witness version above zero
+func isBech32m(addr string) (bool, error) {
+	if !address.IsWitnessAddress(addr) {
 		return false, nil
 	}
+	_, witnessVersion, _, err := address.ValidateWitnessAddress(addr)
+	if err != nil {
+		return false, err
+	}
+	return witnessVersion > 0, nil
+}
 
-	for _, r := range watchedAddressList {
+// importDescriptors watches the descriptors from the timestamp, which is
+// "now" or a UNIX time to rescan the blocks after it
+func (da DaemonAgent) importDescriptors(descs []string, timestamp interface{}) error {
+	requests := make([]map[string]interface{}, 0, len(descs))
+	for _, desc := range descs {
+		v, err := da.jsonRPC(RPCParam{
+			Method: "getdescriptorinfo",
+			Params: []interface{}{desc},
+		})
+		if err != nil {
+			return err
+		}
+
+		var info DescriptorInfo
+		if err := json.Unmarshal(v.Result, &info); err != nil {
+			return err
+		}
+		requests = append(requests, map[string]interface{}{
+			"desc":      info.Descriptor,
+			"timestamp": timestamp,
+			"label":     "bitmark-wallet watched",
+		})
+	}
+
+	v, err := da.jsonRPC(RPCParam{
+		Method: "importdescriptors",
+		Params: []interface{}{requests},
+	})
+	if err != nil {
+		return err
+	}
+	return importResultError(v.Result)
+}
+
+// importResultError returns the first failure of the results of
+// importdescriptors or importmulti
+func importResultError(result json.RawMessage) error {
+	var results []ImportDescriptorResult
+	if err := json.Unmarshal(result, &results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if !r.Success {
+			if r.Error != nil {
+				return fmt.Errorf("JSONRPC Error: %s (code: %d)", r.Error.Message, r.Error.Code)
+			}
+			return ErrImportAddress
+		}
+	}
+	return nil
+}
+
+func (da DaemonAgent) isAddressUsed(watched []ReceivedAddress, address string) (bool, error) {
+	if len(watched) == 0 {
+		return false, nil
+	}
+
+	for _, r := range watched {
 		if r.Address == address && len(r.TxIds) > 0 {
 			return true, nil
 		}
@@ -152,14 +365,217 @@ func (da DaemonAgent) Send(rawTx string) (string, error) {
 	return txId, nil
 }
 
+func (da DaemonAgent) GetRawTransaction(txId string) (string, error) {
+	p := RPCParam{
+		Method: "gettransaction",
+		Params: []interface{}{txId, true},
+	}
+
+	v, err := da.jsonRPC(p)
+	if err != nil {
+		return "", err
+	}
+
+	var t RPCTransaction
+	err = json.Unmarshal(v.Result, &t)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Hex, nil
+}
+
+func (da DaemonAgent) GetMempoolEntry(txId string) (*MempoolEntry, error) {
+	p := RPCParam{
+		Method: "getmempoolentry",
+		Params: []interface{}{txId},
+	}
+
+	v, err := da.jsonRPC(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var e RPCMempoolEntry
+	err = json.Unmarshal(v.Result, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &MempoolEntry{VSize: e.VSize}
+	if entry.VSize == 0 {
+		entry.VSize = e.Size
+	}
+	switch {
+	case e.Fees != nil:
+		entry.Fee = uint64(math.Round(e.Fees.Base * tx.Unit))
+	case e.Fee != nil:
+		entry.Fee = uint64(math.Round(*e.Fee * tx.Unit))
+	default:
+		return nil, fmt.Errorf("no fee in the mempool entry of %s", txId)
+	}
+
+	// a daemon which does not report the ancestors leaves the transaction
+	// as its own package
+	entry.AncestorVSize = e.AncestorSize
+	switch {
+	case e.Fees != nil && e.Fees.Ancestor != nil:
+		entry.AncestorFee = uint64(math.Round(*e.Fees.Ancestor * tx.Unit))
+	case e.AncestorFees != nil:
+		entry.AncestorFee = *e.AncestorFees
+	default:
+		entry.AncestorVSize = 0
+	}
+	if entry.AncestorVSize == 0 {
+		entry.AncestorVSize = entry.VSize
+		entry.AncestorFee = entry.Fee
+	}
+	return entry, nil
+}
+
+func (da DaemonAgent) EstimateFee(confTarget uint32) (uint64, error) {
+	p := RPCParam{
+		Method: "estimatesmartfee",
+		Params: []interface{}{confTarget},
+	}
+
+	v, err := da.jsonRPC(p)
+	if err != nil {
+		return 0, err
+	}
+
+	var e RPCFeeEstimate
+	err = json.Unmarshal(v.Result, &e)
+	if err != nil {
+		return 0, err
+	}
+
+	if e.FeeRate == nil || *e.FeeRate <= 0 {
+		log.WithField("errors", e.Errors).Debug("no fee estimate")
+		return 0, ErrNoFeeEstimate
+	}
+	return uint64(math.Round(*e.FeeRate * tx.Unit)), nil
+}
+
+// ScanUnspent finds the UTXOs of the addresses by scanning the UTXO set of
+// the daemon, so that the addresses are neither imported nor rescanned
+func (da DaemonAgent) ScanUnspent(addrs []string) (tx.UTXOs, error) {
+	descs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		descs = append(descs, fmt.Sprintf("addr(%s)", addr))
+	}
+	p := RPCParam{
+		Method: "scantxoutset",
+		Params: []interface{}{"start", descs},
+	}
+
+	scanner := da
+	scanner.client = &http.Client{
+		Timeout:   scanTimeout,
+		Transport: da.client.Transport,
+	}
+	v, err := scanner.jsonRPC(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var r RPCScanResult
+	err = json.Unmarshal(v.Result, &r)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Success {
+		return nil, ErrQueryFailure{"scantxoutset is aborted"}
+	}
+
+	utxos := make(tx.UTXOs, 0, len(r.Unspents))
+	for _, u := range r.Unspents {
+		hash, err := hex.DecodeString(u.TxId)
+		if err != nil {
+			return nil, err
+		}
+		script, err := hex.DecodeString(u.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var confirmations uint64
+		if u.Height > 0 && r.Height >= u.Height {
+			confirmations = r.Height - u.Height + 1
+		}
+		utxos = append(utxos, &tx.UTXO{
+			TxHash:        reverseByte(hash),
+			TxIndex:       u.Index,
+			Value:         uint64(math.Round(u.Amount * tx.Unit)),
+			Script:        script,
+			Confirmations: confirmations,
+		})
+	}
+	return utxos, nil
+}
+
+// RescanAddresses imports the addresses and rescans the whole chain for
+// their transactions. It takes long, but finds the history of addresses
+// which are used before they are watched, as those of a restored seed.
+func (da DaemonAgent) RescanAddresses(addrs []string) error {
+	// the rescanned addresses are imported with their history
+	defer func() {
+		da.watched.Lock()
+		da.watched.list = nil
+		da.watched.Unlock()
+	}()
+
+	descriptors, err := da.isDescriptorWallet()
+	if err != nil {
+		return fmt.Errorf("fail to rescan address: %s", err.Error())
+	}
+	if descriptors {
+		descs := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			descs = append(descs, fmt.Sprintf("addr(%s)", addr))
+		}
+		if err := da.importDescriptors(descs, 0); err != nil {
+			return fmt.Errorf("fail to rescan address: %s", err.Error())
+		}
+		return nil
+	}
+
+	requests := make([]map[string]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		if bech32m, err := isBech32m(addr); err != nil {
+			return err
+		} else if bech32m {
+			return ErrLegacyWallet
+		}
+		requests = append(requests, map[string]interface{}{
+			"scriptPubKey": map[string]string{"address": addr},
+			"timestamp":    0,
+			"watchonly":    true,
+			"label":        "bitmark-wallet watched",
+		})
+	}
+
+	v, err := da.jsonRPC(RPCParam{
+		Method: "importmulti",
+		Params: []interface{}{requests, map[string]bool{"rescan": true}},
+	})
+	if err != nil {
+		return fmt.Errorf("fail to rescan address: %s", err.Error())
+	}
+	if err := importResultError(v.Result); err != nil {
+		return fmt.Errorf("fail to rescan address: %s", err.Error())
+	}
+	return nil
+}
+
 func (da DaemonAgent) WatchAddress(addr string) error {
-	err := da.getAllWatchedAddress()
+	watched, err := da.getAllWatchedAddress()
 	if err != nil {
 		return fmt.Errorf("fail to update watched address: %s", err.Error())
 	}
 
 	addresses := map[string]bool{}
-	for _, addr := range watchedAddressList {
+	for _, addr := range watched {
 		addresses[addr.Address] = true
 	}
 
@@ -168,14 +584,14 @@ func (da DaemonAgent) WatchAddress(addr string) error {
 		if err != nil {
 			return fmt.Errorf("fail to import address: %s", err.Error())
 		}
-		err = da.getAllWatchedAddress()
+		watched, err = da.getAllWatchedAddress()
 		if err != nil {
 			return fmt.Errorf("fail to update watched address after import: %s", err.Error())
 		}
 	}
 
 	// no unspent tx, check if it is an empty address
-	if used, err := da.isAddressUsed(addr); err != nil {
+	if used, err := da.isAddressUsed(watched, addr); err != nil {
 		return err
 	} else if !used {
 		return ErrNoTxForAddr
@@ -216,9 +632,10 @@ func (da DaemonAgent) ListAllUnspent() (map[string]tx.UTXOs, error) {
 		}
 
 		utxos[u.Address] = append(utxos[u.Address], &tx.UTXO{
-			TxHash:  reverseByte(hash),
-			TxIndex: u.Index,
-			Value:   uint64(u.Value * tx.Unit),
+			TxHash:        reverseByte(hash),
+			TxIndex:       u.Index,
+			Value:         uint64(u.Value * tx.Unit),
+			Confirmations: u.Confirmations,
 		})
 
 	}
@@ -226,6 +643,66 @@ func (da DaemonAgent) ListAllUnspent() (map[string]tx.UTXOs, error) {
 	return utxos, nil
 }
 
+// listTransactionsCount bounds the entries which listtransactions returns
+const listTransactionsCount = 1000000
+
+func (da DaemonAgent) ListTransactions() ([]*TxEntry, error) {
+	p := RPCParam{
+		Method: "listtransactions",
+		Params: []interface{}{"*", listTransactionsCount, 0, true},
+	}
+	v, err := da.jsonRPC(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var rentries []RPCTxEntry
+	err = json.Unmarshal(v.Result, &rentries)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*TxEntry, 0, len(rentries))
+	for _, e := range rentries {
+		category := e.Category
+		// the coins of coinbase transactions are received as well
+		if category == "generate" || category == "immature" || category == "orphan" {
+			category = "receive"
+		}
+		entries = append(entries, &TxEntry{
+			TxId:          e.TxId,
+			Address:       e.Address,
+			Category:      category,
+			Amount:        int64(math.Round(e.Amount * tx.Unit)),
+			Fee:           uint64(math.Round(math.Abs(e.Fee) * tx.Unit)),
+			Confirmations: e.Confirmations,
+			BlockHeight:   e.BlockHeight,
+			BlockTime:     e.BlockTime,
+			Time:          e.Time,
+		})
+	}
+	return entries, nil
+}
+
+func (da DaemonAgent) GetBlockCount() (uint64, error) {
+	p := RPCParam{
+		Method: "getblockcount",
+		Params: []interface{}{},
+	}
+
+	v, err := da.jsonRPC(p)
+	if err != nil {
+		return 0, err
+	}
+
+	var height uint64
+	err = json.Unmarshal(v.Result, &height)
+	if err != nil {
+		return 0, err
+	}
+	return height, nil
+}
+
 func NewDaemonAgent(apiUrl, username, password string) *DaemonAgent {
 	var t = &http.Transport{
 		Dial: (&net.Dialer{
@@ -243,5 +720,8 @@ func NewDaemonAgent(apiUrl, username, password string) *DaemonAgent {
 		username: username,
 		password: password,
 		client:   c,
+		watched:  &watchedAddresses{},
+
+		walletType: &walletType{},
 	}
 }
@@ -1,6 +1,12 @@
 package agent
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,3 +20,182 @@ func TestDaemonWatchAddress(t *testing.T) {
 	err = d.WatchAddress("1DURpDjr49tUbbMhQsG1jeAA6dq5Z5fF3p")
 	assert.EqualError(t, err, "no transaction for the address")
 }
+
+// newTestDaemon returns an agent of a server which responds to the methods
+// with the results
+func newTestDaemon(t *testing.T, results map[string]string) *DaemonAgent {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p RPCParam
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		result, ok := results[p.Method]
+		if !ok {
+			fmt.Fprint(w, `{"result": null, "error": {"code": -32601, "message": "Method not found"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"result": %s, "error": null}`, result)
+	}))
+	t.Cleanup(s.Close)
+	return NewDaemonAgent(s.URL, "user", "pass")
+}
+
+func TestDaemonEstimateFee(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{
+		"estimatesmartfee": `{"feerate": 0.00012345, "blocks": 2}`,
+	})
+	fee, err := d.EstimateFee(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345), fee)
+
+	d = newTestDaemon(t, map[string]string{
+		"estimatesmartfee": `{"errors": ["Insufficient data or no feerate found"], "blocks": 0}`,
+	})
+	_, err = d.EstimateFee(2)
+	assert.Equal(t, ErrNoFeeEstimate, err)
+}
+
+func TestDaemonGetMempoolEntry(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{
+		"getmempoolentry": `{"vsize": 141, "weight": 561, "ancestorsize": 367, "fees": {"base": 0.00000293, "modified": 0.00000293, "ancestor": 0.00000519}}`,
+	})
+	entry, err := d.GetMempoolEntry("txid")
+	assert.NoError(t, err)
+	assert.Equal(t, &MempoolEntry{VSize: 141, Fee: 293, AncestorVSize: 367, AncestorFee: 519}, entry)
+
+	// the ancestor fees in satoshis of the older daemons
+	d = newTestDaemon(t, map[string]string{
+		"getmempoolentry": `{"vsize": 141, "fee": 0.00000293, "ancestorsize": 367, "ancestorfees": 519}`,
+	})
+	entry, err = d.GetMempoolEntry("txid")
+	assert.NoError(t, err)
+	assert.Equal(t, &MempoolEntry{VSize: 141, Fee: 293, AncestorVSize: 367, AncestorFee: 519}, entry)
+
+	// the daemons before the segregated witness
+	d = newTestDaemon(t, map[string]string{
+		"getmempoolentry": `{"size": 226, "fee": 0.00001130}`,
+	})
+	entry, err = d.GetMempoolEntry("txid")
+	assert.NoError(t, err)
+	assert.Equal(t, &MempoolEntry{VSize: 226, Fee: 1130, AncestorVSize: 226, AncestorFee: 1130}, entry)
+
+	_, err = newTestDaemon(t, map[string]string{}).GetMempoolEntry("txid")
+	assert.Error(t, err)
+}
+
+func TestDaemonScanUnspent(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{
+		"scantxoutset": `{"success": true, "height": 2000, "unspents": [{
+			"txid": "5b35f3d330dbad503f2b26313b6ac0dceb7907186303ba7c7d3ab845c598e0e6",
+			"vout": 1,
+			"scriptPubKey": "76a914a5fd6b7b5e6e33f5e1d9ad6f9a0d70c6d0c1d1b988ac",
+			"desc": "addr(mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb)#abcdefgh",
+			"amount": 0.00050000,
+			"height": 1991
+		}]}`,
+	})
+	utxos, err := d.ScanUnspent([]string{"mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb"})
+	assert.NoError(t, err)
+	assert.Len(t, utxos, 1)
+	assert.Equal(t, "e6e098c545b83a7d7cba0363180779ebdcc06a3b31262b3f50addb30d3f3355b", hex.EncodeToString(utxos[0].TxHash))
+	assert.Equal(t, uint32(1), utxos[0].TxIndex)
+	assert.Equal(t, uint64(50000), utxos[0].Value)
+	assert.Equal(t, uint64(10), utxos[0].Confirmations)
+	assert.Equal(t, "76a914a5fd6b7b5e6e33f5e1d9ad6f9a0d70c6d0c1d1b988ac", hex.EncodeToString(utxos[0].Script))
+}
+
+func TestDaemonListTransactions(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{
+		"listtransactions": `[
+			{"involvesWatchonly": true, "address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "category": "receive", "amount": 0.0005, "vout": 1, "confirmations": 3, "blockheight": 2500000, "blocktime": 1700000000, "txid": "aa", "time": 1699999000},
+			{"involvesWatchonly": true, "address": "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", "category": "send", "amount": -0.0002, "vout": 0, "fee": -0.00000212, "confirmations": 0, "txid": "bb", "time": 1700000100}
+		]`,
+	})
+	entries, err := d.ListTransactions()
+	assert.NoError(t, err)
+	assert.Equal(t, []*TxEntry{
+		{TxId: "aa", Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Category: "receive", Amount: 50000,
+			Confirmations: 3, BlockHeight: 2500000, BlockTime: 1700000000, Time: 1699999000},
+		{TxId: "bb", Address: "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", Category: "send", Amount: -20000, Fee: 212,
+			Time: 1700000100},
+	}, entries)
+}
+
+func TestDaemonGetBlockCount(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{"getblockcount": `2500000`})
+	height, err := d.GetBlockCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500000), height)
+}
+
+func TestDaemonWatchAddressConcurrently(t *testing.T) {
+	d := newTestDaemon(t, map[string]string{
+		"listreceivedbyaddress": `[
+			{"address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "amount": 0.0005, "txids": ["aa"]},
+			{"address": "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", "amount": 0, "txids": []}
+		]`,
+		"getwalletinfo": `{"descriptors": false}`,
+		"importaddress": `null`,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, d.WatchAddress("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt"))
+			assert.Equal(t, ErrNoTxForAddr, d.WatchAddress("mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n"))
+			assert.Equal(t, ErrNoTxForAddr, d.WatchAddress("mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb"))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDaemonImportByWalletType(t *testing.T) {
+	bech32m := "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c"
+	received := `[{"address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "amount": 0.0005, "txids": ["aa"]}]`
+
+	// a descriptor wallet imports all the addresses by descriptors
+	d := newTestDaemon(t, map[string]string{
+		"listreceivedbyaddress": received,
+		"getwalletinfo":         `{"descriptors": true}`,
+		"getdescriptorinfo":     `{"descriptor": "addr(mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n)#5d9cnzq6"}`,
+		"importdescriptors":     `[{"success": true}]`,
+	})
+	assert.Equal(t, ErrNoTxForAddr, d.WatchAddress("mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n"))
+	assert.Equal(t, ErrNoTxForAddr, d.WatchAddress(bech32m))
+
+	// a legacy wallet imports the addresses by importaddress and is not
+	// able to watch bech32m addresses
+	d = newTestDaemon(t, map[string]string{
+		"listreceivedbyaddress": received,
+		"getwalletinfo":         `{"descriptors": false}`,
+		"importaddress":         `null`,
+	})
+	assert.Equal(t, ErrNoTxForAddr, d.WatchAddress("mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n"))
+	assert.EqualError(t, d.WatchAddress(bech32m), "fail to import address: "+ErrLegacyWallet.Error())
+}
+
+func TestDaemonRescanAddresses(t *testing.T) {
+	addrs := []string{
+		"mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt",
+		"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c",
+	}
+	d := newTestDaemon(t, map[string]string{
+		"getwalletinfo":     `{"descriptors": true}`,
+		"getdescriptorinfo": `{"descriptor": "addr(tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c)#8fenmcw2"}`,
+		"importdescriptors": `[{"success": true}, {"success": true}]`,
+	})
+	assert.NoError(t, d.RescanAddresses(addrs))
+
+	d = newTestDaemon(t, map[string]string{
+		"getwalletinfo": `{"descriptors": false}`,
+		"importmulti":   `[{"success": true}]`,
+	})
+	assert.NoError(t, d.RescanAddresses(addrs[:1]))
+	assert.Equal(t, ErrLegacyWallet, d.RescanAddresses(addrs))
+
+	d = newTestDaemon(t, map[string]string{
+		"getwalletinfo": `{"descriptors": false}`,
+		"importmulti":   `[{"success": false, "error": {"code": -5, "message": "Invalid address"}}]`,
+	})
+	assert.EqualError(t, d.RescanAddresses(addrs[:1]), "fail to rescan address: JSONRPC Error: Invalid address (code: -5)")
+}
@@ -0,0 +1,104 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+
+	"github.com/bitmark-inc/bitmarkd/pay"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrInvalidPayId    = fmt.Errorf("invalid pay id")
+	ErrNoPayment       = fmt.Errorf("payment request has no payment of the coin")
+	ErrPaymentCurrency = fmt.Errorf("payment currency is not the coin of the account")
+	ErrPaymentAmount   = fmt.Errorf("payment amount must be positive")
+	ErrPaymentAddress  = fmt.Errorf("payment address is not valid for the network of the account")
+)
+
+// BitmarkPayment is a payment which bitmarkd requests. It decodes the JSON
+// of the Payment of bitmarkd, whose currency package is not built with the
+// btcd of the wallet.
+type BitmarkPayment struct {
+	Currency CoinType `json:"currency"`
+	Address  string   `json:"address"`
+	Amount   uint64   `json:"amount,string"`
+}
+
+// PaymentRequest is the payment of a coin which bitmarkd requests for an
+// issue or a transfer. The pay id is carried by an OP_RETURN output of the
+// transaction, by which bitmarkd finds the payment.
+type PaymentRequest struct {
+	PayId    pay.PayId         `json:"payId"`
+	Payments []*BitmarkPayment `json:"payments"`
+}
+
+// ParsePaymentReply returns the payment request of a coin from the reply of
+// a bitmarkd RPC, such as a transfer, which carries the pay id and the
+// payments of each currency
+func ParsePaymentReply(reply []byte, ct CoinType) (*PaymentRequest, error) {
+	var r struct {
+		PayId    pay.PayId                      `json:"payId"`
+		Payments map[CoinType][]*BitmarkPayment `json:"payments"`
+	}
+	if err := json.Unmarshal(reply, &r); err != nil {
+		return nil, err
+	}
+	return &PaymentRequest{PayId: r.PayId, Payments: r.Payments[ct]}, nil
+}
+
+// validatePayment checks a payment request is for the coin and the network
+// of the account
+func (c CoinAccount) validatePayment(req *PaymentRequest) error {
+	if req.PayId == (pay.PayId{}) {
+		return ErrInvalidPayId
+	}
+	if len(req.Payments) == 0 {
+		return ErrNoPayment
+	}
+	for _, p := range req.Payments {
+		if p.Currency != c.CoinType {
+			return ErrPaymentCurrency
+		}
+		if p.Amount == 0 {
+			return ErrPaymentAmount
+		}
+		addr, err := btcutil.DecodeAddress(p.Address, c.net)
+		if err != nil || !addr.IsForNet(c.net) {
+			return ErrPaymentAddress
+		}
+	}
+	return nil
+}
+
+// paymentSends returns the outputs of the payments. The payments to the
+// same address are merged into one output since bitmarkd only counts one
+// output of each address.
+func paymentSends(payments []*BitmarkPayment) []*tx.Send {
+	sends := make([]*tx.Send, 0, len(payments))
+	outputs := make(map[string]*tx.Send)
+	for _, p := range payments {
+		if s, ok := outputs[p.Address]; ok {
+			s.Amount += p.Amount
+			continue
+		}
+		s := &tx.Send{Addr: p.Address, Amount: p.Amount}
+		outputs[p.Address] = s
+		sends = append(sends, s)
+	}
+	return sends
+}
+
+// PayBitmark pays a payment request of bitmarkd by a transaction which has
+// an output for each payment address and the pay id in an OP_RETURN
+// output, as Send does. It returns the txid, by which bitmarkd verifies the
+// payment, and the raw transaction.
+func (c CoinAccount) PayBitmark(req *PaymentRequest, fee uint64) (string, string, error) {
+	if err := c.validatePayment(req); err != nil {
+		return "", "", err
+	}
+	return c.Send(paymentSends(req.Payments), req.PayId[:], fee)
+}
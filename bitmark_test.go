@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// the reply of a transfer RPC of bitmarkd
+const transferReply = `{
+	"txId": "5b35f3d330dbad503f2b26313b6ac0dceb7907186303ba7c7d3ab845c598e0e6",
+	"payId": "2a9d0e4a2f2d8b3f33ab4d8d4a3ee8c1ebc4c5dbd91e4fd6bbc0d5dfc7c1b7f0fd5c3bcf5a1f0b3b2c6a6ba1d1b1e0f3",
+	"payments": {
+		"BTC": [
+			{"currency": "BTC", "address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "amount": "20000"},
+			{"currency": "BTC", "address": "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", "amount": "10000"},
+			{"currency": "BTC", "address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "amount": "5000"}
+		],
+		"LTC": [
+			{"currency": "LTC", "address": "mjPkDNakVA4w4hJZ6WF7p8yKUV2merhyCM", "amount": "200000"}
+		]
+	}
+}`
+
+func TestPayBitmark(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	req, err := ParsePaymentReply([]byte(transferReply), BTC)
+	assert.NoError(t, err)
+	assert.Len(t, req.Payments, 3)
+	assert.Equal(t, uint64(10000), req.Payments[1].Amount)
+
+	txId, rawTx, err := account.PayBitmark(req, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, a.sent, 1)
+	paid := deserializeTx(t, rawTx)
+	assert.Equal(t, txId, paid.TxHash().String())
+
+	// the payments to the same address are merged, and the pay id is in a
+	// 48 byte push after OP_RETURN as bitmarkd finds it
+	amounts := make(map[string]int64)
+	var payId []byte
+	for _, txOut := range paid.TxOut {
+		if bytes.HasPrefix(txOut.PkScript, []byte{0x6a, 0x30}) {
+			payId = txOut.PkScript[2:]
+			assert.Len(t, txOut.PkScript, 50)
+			continue
+		}
+		for _, addr := range []string{"mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n"} {
+			script, err := tx.DefaultP2PKScript(addr)
+			assert.NoError(t, err)
+			if bytes.Equal(script, txOut.PkScript) {
+				amounts[addr] += txOut.Value
+			}
+		}
+	}
+	assert.Equal(t, req.PayId[:], payId)
+	assert.Equal(t, map[string]int64{
+		"mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt": 25000,
+		"mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n": 10000,
+	}, amounts)
+	verifyTx(t, paid, utxos)
+}
+
+func TestValidatePayment(t *testing.T) {
+	account, a, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	req, err := ParsePaymentReply([]byte(transferReply), BTC)
+	assert.NoError(t, err)
+	payments := req.Payments
+
+	req.Payments = nil
+	_, _, err = account.PayBitmark(req, 1000)
+	assert.Equal(t, ErrNoPayment, err)
+
+	req.Payments = []*BitmarkPayment{{Currency: LTC, Address: payments[0].Address, Amount: 10000}}
+	_, _, err = account.PayBitmark(req, 1000)
+	assert.Equal(t, ErrPaymentCurrency, err)
+
+	req.Payments = []*BitmarkPayment{{Currency: BTC, Address: payments[0].Address}}
+	_, _, err = account.PayBitmark(req, 1000)
+	assert.Equal(t, ErrPaymentAmount, err)
+
+	// a mainnet address
+	req.Payments = []*BitmarkPayment{{Currency: BTC, Address: "1HZwkjkeaoZfTSaJxDw6aKkxp45agDiEzN", Amount: 10000}}
+	_, _, err = account.PayBitmark(req, 1000)
+	assert.Equal(t, ErrPaymentAddress, err)
+
+	req, err = ParsePaymentReply([]byte(`{"payments": {"BTC": [{"currency": "BTC", "address": "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", "amount": "1000"}]}}`), BTC)
+	assert.NoError(t, err)
+	_, _, err = account.PayBitmark(req, 1000)
+	assert.Equal(t, ErrInvalidPayId, err)
+
+	_, err = ParsePaymentReply([]byte(`{"payId": "00"}`), BTC)
+	assert.Error(t, err)
+	assert.Empty(t, a.sent)
+}
@@ -0,0 +1,232 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrUnknownUTXO = fmt.Errorf("utxo is not a coin of the account")
+	ErrLockedUTXO  = fmt.Errorf("utxo is locked")
+	ErrNoUTXO      = fmt.Errorf("no utxo is given to spend")
+	ErrSpentUTXO   = fmt.Errorf("utxo is spent by a pending transaction")
+
+	ErrInvalidOutPoint = fmt.Errorf("invalid outpoint")
+)
+
+// ParseOutPoint parses an outpoint in the form of txid:vout
+func ParseOutPoint(s string) (wire.OutPoint, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[0]) != 2*chainhash.HashSize {
+		return wire.OutPoint{}, ErrInvalidOutPoint
+	}
+	hash, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return wire.OutPoint{}, ErrInvalidOutPoint
+	}
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return wire.OutPoint{}, ErrInvalidOutPoint
+	}
+	return *wire.NewOutPoint(hash, uint32(index)), nil
+}
+
+// utxoOutPoint returns the outpoint of a UTXO
+func utxoOutPoint(u *tx.UTXO) wire.OutPoint {
+	var hash chainhash.Hash
+	copy(hash[:], u.TxHash)
+	return wire.OutPoint{Hash: hash, Index: u.TxIndex}
+}
+
+// LockUnspent marks the outpoints as never spendable, for example tainted
+// deposits or collateral. The coins are left out of the selection of the
+// account until they are unlocked, and the locks are kept in the store.
+func (c CoinAccount) LockUnspent(outpoints ...wire.OutPoint) error {
+	for _, op := range outpoints {
+		if err := c.store.LockOutpoint(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlockUnspent makes the locked outpoints spendable again
+func (c CoinAccount) UnlockUnspent(outpoints ...wire.OutPoint) error {
+	for _, op := range outpoints {
+		if err := c.store.UnlockOutpoint(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListLocked returns the locked outpoints of the account
+func (c CoinAccount) ListLocked() ([]wire.OutPoint, error) {
+	return c.store.GetLockedOutpoints()
+}
+
+// lockedOutPoints returns the set of the locked outpoints
+func (c CoinAccount) lockedOutPoints() (map[wire.OutPoint]bool, error) {
+	outpoints, err := c.store.GetLockedOutpoints()
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[wire.OutPoint]bool, len(outpoints))
+	for _, op := range outpoints {
+		locked[op] = true
+	}
+	return locked, nil
+}
+
+// Unspent is a coin of the account
+type Unspent struct {
+	Address       string
+	OutPoint      wire.OutPoint
+	Value         uint64
+	Confirmations uint64
+	Locked        bool
+	// the label of the output, or the one of the address if it has none
+	Label string
+}
+
+// ListUnspent returns all the coins of the account including the locked
+// ones and the pending changes, ordered by their addresses and outpoints
+func (c CoinAccount) ListUnspent() ([]*Unspent, error) {
+	utxos, err := c.unspentCoins()
+	if err != nil {
+		return nil, err
+	}
+	locked, err := c.lockedOutPoints()
+	if err != nil {
+		return nil, err
+	}
+
+	coins := make([]*Unspent, 0)
+	for addr, txs := range utxos {
+		addrLabel, err := c.Label(LabelAddr, addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range txs {
+			op := utxoOutPoint(u)
+			label, err := c.Label(LabelOutput, op.String())
+			if err != nil {
+				return nil, err
+			}
+			if label == "" {
+				label = addrLabel
+			}
+			coins = append(coins, &Unspent{
+				Address:       addr,
+				OutPoint:      op,
+				Value:         u.Value,
+				Confirmations: u.Confirmations,
+				Locked:        locked[op],
+				Label:         label,
+			})
+		}
+	}
+
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Address != coins[j].Address {
+			return coins[i].Address < coins[j].Address
+		}
+		if c := bytes.Compare(coins[i].OutPoint.Hash[:], coins[j].OutPoint.Hash[:]); c != 0 {
+			return c < 0
+		}
+		return coins[i].OutPoint.Index < coins[j].OutPoint.Index
+	})
+	return coins, nil
+}
+
+// SendFrom pays the sends as Send does, but funds them by exactly the coins
+// of the outpoints instead of selecting among all the coins. All of them
+// are spent and the excess returns as the change. The locked coins are
+// never spent.
+func (c CoinAccount) SendFrom(outpoints []wire.OutPoint, sends []*tx.Send, customData []byte, fee uint64) (string, string, error) {
+	coins, err := c.utxosOf(outpoints)
+	if err != nil {
+		return "", "", err
+	}
+	c.inputs = coins
+	return c.Send(sends, customData, fee)
+}
+
+// SendAllFrom spends exactly the coins of the outpoints to an address
+// without a change as SendAll does. The locked coins are never spent.
+func (c CoinAccount) SendAllFrom(outpoints []wire.OutPoint, addr string, customData []byte, fee uint64) (string, string, error) {
+	coins, err := c.utxosOf(outpoints)
+	if err != nil {
+		return "", "", err
+	}
+	c.inputs = coins
+	return c.SendAll(addr, customData, fee)
+}
+
+// utxosOf returns the spendable UTXOs of the outpoints
+func (c CoinAccount) utxosOf(outpoints []wire.OutPoint) (tx.UTXOs, error) {
+	if len(outpoints) == 0 {
+		return nil, ErrNoUTXO
+	}
+
+	locked, err := c.lockedOutPoints()
+	if err != nil {
+		return nil, err
+	}
+	spent, err := c.pendingSpent()
+	if err != nil {
+		return nil, err
+	}
+	spendable, err := c.spendableUTXOs()
+	if err != nil {
+		return nil, err
+	}
+	coins := make(map[wire.OutPoint]*tx.UTXO, len(spendable))
+	for _, u := range spendable {
+		coins[utxoOutPoint(u)] = u
+	}
+
+	utxos := make(tx.UTXOs, 0, len(outpoints))
+	selected := make(map[wire.OutPoint]bool, len(outpoints))
+	for _, op := range outpoints {
+		if locked[op] {
+			return nil, ErrLockedUTXO
+		}
+		if spent[op] {
+			return nil, ErrSpentUTXO
+		}
+		u, ok := coins[op]
+		if !ok {
+			return nil, ErrUnknownUTXO
+		}
+		// an outpoint given twice is spent once
+		if !selected[op] {
+			selected[op] = true
+			utxos = append(utxos, u)
+		}
+	}
+	return utxos, nil
+}
+
+// spendAll selects all the UTXOs if their effective values cover the
+// target, and returns them with their total value
+func spendAll(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, uint64, error) {
+	var total uint64
+	var effective int64
+	for _, u := range utxos {
+		total += u.Value
+		effective += int64(u.Value) - int64(target.InputFee)
+	}
+	if effective < int64(target.Amount) {
+		return nil, total, ErrNotEnoughCoin
+	}
+	return utxos, total, nil
+}
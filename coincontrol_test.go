@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestLockUnspent(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+
+	large := utxoOutPoint(utxos[0])
+	assert.NoError(t, account.LockUnspent(large))
+	locked, err := account.ListLocked()
+	assert.NoError(t, err)
+	assert.Equal(t, []wire.OutPoint{large}, locked)
+
+	// the locked coin is never selected
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	_, _, err = account.Send(sends, nil, 1000)
+	assert.Equal(t, ErrNotEnoughCoin, err)
+	_, _, err = account.SendFrom([]wire.OutPoint{large}, sends, nil, 1000)
+	assert.Equal(t, ErrLockedUTXO, err)
+
+	assert.NoError(t, account.UnlockUnspent(large))
+	locked, err = account.ListLocked()
+	assert.NoError(t, err)
+	assert.Empty(t, locked)
+
+	_, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Len(t, sent.TxIn, 1)
+	assert.Equal(t, large, sent.TxIn[0].PreviousOutPoint)
+	verifyTx(t, sent, utxos[:1])
+}
+
+func TestSendFrom(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000, 40000)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	// the chosen coins are all spent even though one of them is enough
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 20000}}
+	chosen := []wire.OutPoint{utxoOutPoint(utxos[1]), utxoOutPoint(utxos[2]), utxoOutPoint(utxos[2])}
+	_, rawTx, err := account.SendFrom(chosen, sends, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Len(t, sent.TxIn, 2)
+	assert.Equal(t, chosen[0], sent.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, chosen[1], sent.TxIn[1].PreviousOutPoint)
+	assert.Len(t, sent.TxOut, 2)
+	verifyTx(t, sent, utxos[1:])
+
+	// no other coin, such as the pending change, is added for the amount
+	sends[0].Amount = 120000
+	_, _, err = account.SendFrom([]wire.OutPoint{utxoOutPoint(utxos[0])}, sends, nil, 1000)
+	assert.Equal(t, ErrNotEnoughCoin, err)
+
+	_, _, err = account.SendFrom(chosen[:1], sends, nil, 1000)
+	assert.Equal(t, ErrSpentUTXO, err)
+
+	_, _, err = account.SendFrom([]wire.OutPoint{{Index: 7}}, sends, nil, 1000)
+	assert.Equal(t, ErrUnknownUTXO, err)
+	_, _, err = account.SendFrom(nil, sends, nil, 1000)
+	assert.Equal(t, ErrNoUTXO, err)
+}
+
+func TestBoltAccountStoreLockedOutpoints(t *testing.T) {
+	s, err := NewBoltAccountStore(testDataFile(t), "test_account")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	op := wire.OutPoint{Hash: [32]byte{1, 2, 3}, Index: 300}
+	assert.NoError(t, s.LockOutpoint(op))
+	assert.NoError(t, s.LockOutpoint(op))
+	locked, err := s.GetLockedOutpoints()
+	assert.NoError(t, err)
+	assert.Equal(t, []wire.OutPoint{op}, locked)
+
+	assert.NoError(t, s.UnlockOutpoint(op))
+	assert.NoError(t, s.UnlockOutpoint(op))
+	locked, err = s.GetLockedOutpoints()
+	assert.NoError(t, err)
+	assert.Empty(t, locked)
+}
+
+func TestSendAllFrom(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000, 40000)
+	defer account.Close()
+
+	// only the chosen coins are sent, and the other coin is kept
+	chosen := []wire.OutPoint{utxoOutPoint(utxos[0]), utxoOutPoint(utxos[2])}
+	_, rawTx, err := account.SendAllFrom(chosen, "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Len(t, sent.TxIn, 2)
+	assert.Equal(t, chosen[0], sent.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, chosen[1], sent.TxIn[1].PreviousOutPoint)
+	assert.Len(t, sent.TxOut, 1)
+	assert.True(t, sent.TxOut[0].Value < 140000)
+	assert.True(t, sent.TxOut[0].Value > 139000)
+	verifyTx(t, sent, tx.UTXOs{utxos[0], utxos[2]})
+
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(30000), balance)
+
+	_, _, err = account.SendAllFrom(chosen[:1], "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", nil, 1000)
+	assert.Equal(t, ErrSpentUTXO, err)
+	assert.NoError(t, account.LockUnspent(utxoOutPoint(utxos[1])))
+	_, _, err = account.SendAllFrom([]wire.OutPoint{utxoOutPoint(utxos[1])}, "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", nil, 1000)
+	assert.Equal(t, ErrLockedUTXO, err)
+}
@@ -2,11 +2,30 @@ package wallet
 
 import (
 	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
 )
 
 type CoinType string
 type Test bool
 
+// Purpose is the first level of a BIP43 derivation path. It decides
+// which kind of addresses an account hands out.
+type Purpose uint32
+
+const (
+	// BIP44 accounts use legacy P2PKH addresses
+	BIP44 Purpose = 44
+	// BIP48 accounts are multisig accounts of cosigners, see MultisigAccount
+	BIP48 Purpose = 48
+	// BIP49 accounts use SegWit P2WPKH nested in P2SH addresses
+	BIP49 Purpose = 49
+	// BIP84 accounts use native SegWit P2WPKH addresses
+	BIP84 Purpose = 84
+	// BIP86 accounts use Taproot P2TR addresses spent by the key path
+	BIP86 Purpose = 86
+)
+
 const (
 	BTC CoinType = "BTC"
 	LTC CoinType = "LTC"
@@ -67,3 +86,53 @@ var CoinParams = map[CoinType]map[Test]*address.Params{
 		false: LitecoinMain,
 	},
 }
+
+var (
+	// LitecoinMainNetParams is the chain params of litecoin main net
+	// which are used to encode and decode addresses.
+	LitecoinMainNetParams = chaincfg.Params{
+		Name:             "litecoin",
+		Net:              wire.BitcoinNet(0xdbb6c0fb),
+		PubKeyHashAddrID: 48,
+		ScriptHashAddrID: 50,
+		PrivateKeyID:     176,
+		Bech32HRPSegwit:  "ltc",
+		HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+		HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+		HDCoinType:       2,
+	}
+	// LitecoinTestNetParams is the chain params of litecoin test net
+	// which are used to encode and decode addresses.
+	LitecoinTestNetParams = chaincfg.Params{
+		Name:             "litecoin-testnet4",
+		Net:              wire.BitcoinNet(0xf1c8d2fd),
+		PubKeyHashAddrID: 111,
+		ScriptHashAddrID: 58,
+		PrivateKeyID:     239,
+		Bech32HRPSegwit:  "tltc",
+		HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+		HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+		HDCoinType:       1,
+	}
+)
+
+var ChainParams = map[CoinType]map[Test]*chaincfg.Params{
+	BTC: {
+		true:  &chaincfg.TestNet3Params,
+		false: &chaincfg.MainNetParams,
+	},
+	LTC: {
+		true:  &LitecoinTestNetParams,
+		false: &LitecoinMainNetParams,
+	},
+}
+
+func init() {
+	// register litecoin networks so that btcutil is able to decode
+	// their addresses
+	for _, params := range ChainParams[LTC] {
+		if err := chaincfg.Register(params); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// bnbMaxTries bounds the depth-first search of BranchAndBound
+const bnbMaxTries = 100000
+
+// SelectionTarget is the amount which a CoinSelector selects coins for.
+// All the values are in satoshis.
+type SelectionTarget struct {
+	// Amount is the total of the outputs plus the fee of the transaction
+	// without any input
+	Amount uint64
+	// InputFee is the fee of adding an input
+	InputFee uint64
+	// ChangeCost is the fee of adding a change output. An excess below it
+	// is left to the fee instead of creating a change.
+	ChangeCost uint64
+}
+
+// CoinSelector selects the coins to spend
+type CoinSelector interface {
+	// SelectCoins returns coins whose effective values, the values minus
+	// the input fee, cover the target amount. It returns ErrNotEnoughCoin
+	// if the coins are insufficient. The coins passed in are always worth
+	// more than the input fee.
+	SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error)
+}
+
+// CoinSelectors are the built-in coin selection strategies by name
+var CoinSelectors = map[string]CoinSelector{
+	"bnb":      BranchAndBound{},
+	"largest":  LargestFirst{},
+	"smallest": SmallestFirst{},
+	"oldest":   OldestFirst{},
+	"privacy":  Privacy{},
+}
+
+// DefaultCoinSelector is used by the accounts without a coin selector
+var DefaultCoinSelector CoinSelector = BranchAndBound{}
+
+// effectiveValue returns the value of a coin minus the fee to spend it
+func effectiveValue(u *tx.UTXO, target SelectionTarget) uint64 {
+	return u.Value - target.InputFee
+}
+
+// compareOutPoint orders coins by their outpoints so that the selections
+// are deterministic
+func compareOutPoint(a, b *tx.UTXO) bool {
+	if c := bytes.Compare(a.TxHash, b.TxHash); c != 0 {
+		return c < 0
+	}
+	return a.TxIndex < b.TxIndex
+}
+
+// accumulate selects coins in order until they cover the target
+func accumulate(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	selected := make(tx.UTXOs, 0)
+	var total uint64
+	for _, u := range utxos {
+		selected = append(selected, u)
+		total += effectiveValue(u, target)
+		if total >= target.Amount {
+			return selected, nil
+		}
+	}
+	return nil, ErrNotEnoughCoin
+}
+
+// LargestFirst spends the largest coins first, which keeps the number of
+// inputs and the fee low
+type LargestFirst struct{}
+
+func (LargestFirst) SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	sorted := append(tx.UTXOs{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value > sorted[j].Value
+		}
+		return compareOutPoint(sorted[i], sorted[j])
+	})
+	return accumulate(sorted, target)
+}
+
+// SmallestFirst spends the smallest coins first, which consolidates the
+// coins at the cost of a higher fee
+type SmallestFirst struct{}
+
+func (SmallestFirst) SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	sorted := append(tx.UTXOs{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value < sorted[j].Value
+		}
+		return compareOutPoint(sorted[i], sorted[j])
+	})
+	return accumulate(sorted, target)
+}
+
+// OldestFirst spends the coins with the most confirmations first
+type OldestFirst struct{}
+
+func (OldestFirst) SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	sorted := append(tx.UTXOs{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Confirmations != sorted[j].Confirmations {
+			return sorted[i].Confirmations > sorted[j].Confirmations
+		}
+		return compareOutPoint(sorted[i], sorted[j])
+	})
+	return accumulate(sorted, target)
+}
+
+// Privacy avoids linking addresses in a transaction. The coins of an
+// address are always spent together, and a single address which covers
+// the target with the least excess is preferred. Otherwise the addresses
+// with the largest totals are combined so that as few addresses as
+// possible are linked.
+type Privacy struct{}
+
+func (Privacy) SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	type group struct {
+		script string
+		utxos  tx.UTXOs
+		total  uint64
+	}
+
+	groups := make([]*group, 0)
+	index := make(map[string]*group)
+	for _, u := range utxos {
+		script := hex.EncodeToString(u.Script)
+		g, ok := index[script]
+		if !ok {
+			g = &group{script: script}
+			index[script] = g
+			groups = append(groups, g)
+		}
+		g.utxos = append(g.utxos, u)
+		g.total += effectiveValue(u, target)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].total != groups[j].total {
+			return groups[i].total > groups[j].total
+		}
+		return groups[i].script < groups[j].script
+	})
+
+	// groups are sorted in descending order, so the last one which covers
+	// the target has the least excess
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].total >= target.Amount {
+			return groups[i].utxos, nil
+		}
+	}
+
+	selected := make(tx.UTXOs, 0)
+	var total uint64
+	for _, g := range groups {
+		selected = append(selected, g.utxos...)
+		total += g.total
+		if total >= target.Amount {
+			return selected, nil
+		}
+	}
+	return nil, ErrNotEnoughCoin
+}
+
+// BranchAndBound searches for a set of coins which pays the target without
+// a change output, that is, the excess is less than the change cost. The
+// set with the least excess wins. If there is no such set, the coins are
+// selected by the fallback, or by LargestFirst if it is not set.
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+func (b BranchAndBound) SelectCoins(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, error) {
+	sorted := append(tx.UTXOs{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value > sorted[j].Value
+		}
+		return compareOutPoint(sorted[i], sorted[j])
+	})
+
+	values := make([]uint64, len(sorted))
+	var available uint64
+	for i, u := range sorted {
+		values[i] = effectiveValue(u, target)
+		available += values[i]
+	}
+
+	if available >= target.Amount {
+		if selected := bnbSearch(values, target); selected != nil {
+			coins := make(tx.UTXOs, 0, len(selected))
+			for _, i := range selected {
+				coins = append(coins, sorted[i])
+			}
+			return coins, nil
+		}
+	}
+
+	fallback := b.Fallback
+	if fallback == nil {
+		fallback = LargestFirst{}
+	}
+	return fallback.SelectCoins(utxos, target)
+}
+
+// bnbSearch returns the indexes of the values, which are in descending
+// order, whose sum is in the range of the target amount and the target
+// amount plus the change cost
+func bnbSearch(values []uint64, target SelectionTarget) []int {
+	upper := target.Amount + target.ChangeCost
+
+	var best []int
+	var bestExcess uint64
+	tries := 0
+	selected := make([]int, 0, len(values))
+
+	// remaining is the sum of the values from i onwards
+	var search func(i int, total, remaining uint64)
+	search = func(i int, total, remaining uint64) {
+		tries++
+		if tries > bnbMaxTries || (best != nil && bestExcess == 0) {
+			return
+		}
+		if total > upper {
+			return
+		}
+		if total >= target.Amount {
+			if excess := total - target.Amount; best == nil || excess < bestExcess {
+				best = append([]int{}, selected...)
+				bestExcess = excess
+			}
+			return
+		}
+		if i == len(values) || total+remaining < target.Amount {
+			return
+		}
+
+		// include the value
+		selected = append(selected, i)
+		search(i+1, total+values[i], remaining-values[i])
+		selected = selected[:len(selected)-1]
+
+		// exclude the value together with the equal values which follow,
+		// since including any of them is the same as including this one
+		j := i + 1
+		remaining -= values[i]
+		for j < len(values) && values[j] == values[i] {
+			remaining -= values[j]
+			j++
+		}
+		search(j, total, remaining)
+	}
+
+	var total uint64
+	for _, v := range values {
+		total += v
+	}
+	search(0, 0, total)
+	return best
+}
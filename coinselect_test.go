@@ -0,0 +1,155 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func testUTXO(id byte, value uint64, confirmations uint64, script string) *tx.UTXO {
+	hash := make([]byte, 32)
+	hash[0] = id
+	return &tx.UTXO{
+		TxHash:        hash,
+		Value:         value,
+		Confirmations: confirmations,
+		Script:        []byte(script),
+	}
+}
+
+func selectedIds(utxos tx.UTXOs) []byte {
+	ids := make([]byte, 0, len(utxos))
+	for _, u := range utxos {
+		ids = append(ids, u.TxHash[0])
+	}
+	return ids
+}
+
+var testCoins = tx.UTXOs{
+	testUTXO(1, 10000, 5, "a"),
+	testUTXO(2, 32000, 1, "b"),
+	testUTXO(3, 20000, 10, "a"),
+	testUTXO(4, 50000, 3, "c"),
+	testUTXO(5, 5000, 20, "d"),
+}
+
+func TestLargestFirst(t *testing.T) {
+	coins, err := LargestFirst{}.SelectCoins(testCoins, SelectionTarget{Amount: 60000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4, 2}, selectedIds(coins))
+
+	// the input fee lowers the values of the coins
+	coins, err = LargestFirst{}.SelectCoins(testCoins, SelectionTarget{Amount: 81000, InputFee: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4, 2, 3}, selectedIds(coins))
+
+	_, err = LargestFirst{}.SelectCoins(testCoins, SelectionTarget{Amount: 117001})
+	assert.Equal(t, ErrNotEnoughCoin, err)
+}
+
+func TestSmallestFirst(t *testing.T) {
+	coins, err := SmallestFirst{}.SelectCoins(testCoins, SelectionTarget{Amount: 30000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{5, 1, 3}, selectedIds(coins))
+}
+
+func TestOldestFirst(t *testing.T) {
+	coins, err := OldestFirst{}.SelectCoins(testCoins, SelectionTarget{Amount: 30000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{5, 3, 1}, selectedIds(coins))
+}
+
+func TestPrivacy(t *testing.T) {
+	// the address "a" holds 30000 which has less excess than "b" and "c"
+	coins, err := Privacy{}.SelectCoins(testCoins, SelectionTarget{Amount: 25000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 3}, selectedIds(coins))
+
+	coins, err = Privacy{}.SelectCoins(testCoins, SelectionTarget{Amount: 45000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4}, selectedIds(coins))
+
+	// no single address is enough, so the largest addresses are combined
+	coins, err = Privacy{}.SelectCoins(testCoins, SelectionTarget{Amount: 70000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4, 2}, selectedIds(coins))
+
+	_, err = Privacy{}.SelectCoins(testCoins, SelectionTarget{Amount: 200000})
+	assert.Equal(t, ErrNotEnoughCoin, err)
+}
+
+func TestBranchAndBound(t *testing.T) {
+	// 32000 + 20000 + 10000 matches exactly
+	coins, err := BranchAndBound{}.SelectCoins(testCoins, SelectionTarget{Amount: 62000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 3, 1}, selectedIds(coins))
+
+	// the input fee is taken into account
+	coins, err = BranchAndBound{}.SelectCoins(testCoins, SelectionTarget{Amount: 53000, InputFee: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4, 5}, selectedIds(coins))
+
+	// an excess within the change cost is accepted and the least one wins
+	// over 50000 + 10000 + 5000 which is found first
+	coins, err = BranchAndBound{}.SelectCoins(testCoins, SelectionTarget{Amount: 61000, ChangeCost: 5000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 3, 1}, selectedIds(coins))
+
+	// no match without a change falls back
+	coins, err = BranchAndBound{}.SelectCoins(testCoins, SelectionTarget{Amount: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4}, selectedIds(coins))
+	coins, err = BranchAndBound{Fallback: SmallestFirst{}}.SelectCoins(testCoins, SelectionTarget{Amount: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{5}, selectedIds(coins))
+
+	_, err = BranchAndBound{}.SelectCoins(testCoins, SelectionTarget{Amount: 117001})
+	assert.Equal(t, ErrNotEnoughCoin, err)
+}
+
+func TestBranchAndBoundEqualValues(t *testing.T) {
+	coins := tx.UTXOs{}
+	for i := byte(1); i <= 30; i++ {
+		coins = append(coins, testUTXO(i, 10000, 0, "a"))
+	}
+	coins = append(coins, testUTXO(31, 3000, 0, "b"))
+
+	selected, err := BranchAndBound{}.SelectCoins(coins, SelectionTarget{Amount: 153000})
+	assert.NoError(t, err)
+	assert.Len(t, selected, 16)
+	assert.Equal(t, byte(31), selected[15].TxHash[0])
+}
+
+func TestAccountCoinSelector(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, account.store.SetUTXO(addr, tx.UTXOs{
+		testUTXO(1, 100000, 1, ""),
+		testUTXO(2, 60000, 1, ""),
+		testUTXO(3, 40000, 1, ""),
+	}))
+	changeAddr, err := account.Address(0, true)
+	assert.NoError(t, err)
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 99000}}
+
+	// the default branch and bound pays without a change
+	redeemTx, utxos, err := account.prepareSpendTx(nil, sends, changeAddr, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 3}, selectedIds(utxos))
+	assert.Len(t, redeemTx.TxOut, 1)
+	verifyTx(t, redeemTx, utxos)
+
+	account.SetCoinSelector(LargestFirst{})
+	redeemTx, utxos, err = account.prepareSpendTx(nil, sends, changeAddr, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, selectedIds(utxos))
+	assert.Len(t, redeemTx.TxOut, 2)
+	verifyTx(t, redeemTx, utxos)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+func newAccountsCmd(ct wallet.CoinType) *cobra.Command {
+	var discover bool
+	accountsCmd := &cobra.Command{
+		Use:   "accounts",
+		Short: "list the accounts of the seed with their balances",
+		Long: `list the accounts of the seed with their balances, from the first one until an
+account without a transaction history. --discover syncs the accounts from the
+network first as BIP44 account discovery does, which finds the coins of the
+later accounts after a seed of another wallet is restored. The addresses of
+an account which has never been synced are rescanned by the daemon first, which
+takes a while. The legacy address type derives the unhardened path of the
+earlier versions of this wallet, restore the seed of another wallet with the
+other address types. Select an account by --account for the other commands.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if w == nil {
+				returnIfErr(fmt.Errorf("accounts need the seed of the wallet"))
+			}
+			if multisigRequired > 0 {
+				returnIfErr(fmt.Errorf("accounts do not support multisig accounts"))
+			}
+
+			// the accounts are opened in turn, and each of them locks
+			// the wallet db until it is closed
+			coinAccount.Close()
+
+			purpose := purposes[addressType]
+			var accounts []*wallet.AccountSummary
+			var err error
+			if discover {
+				fmt.Println("Sync data from network. It takes a period of time...")
+				accounts, err = w.DiscoverAccounts(purpose, ct, wallet.Test(test), coinAgent)
+			} else {
+				accounts, err = w.Accounts(purpose, ct, wallet.Test(test))
+			}
+			returnIfErr(err)
+			for _, a := range accounts {
+				fmt.Printf("Account %d: %d\n", a.Index, a.Balance)
+			}
+		},
+	}
+	accountsCmd.Flags().BoolVar(&discover, "discover", false, "sync the accounts from the network")
+	return accountsCmd
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+func newAddressesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "addresses",
+		Short: "list the addresses which the wallet has handed out",
+		Long: `list the addresses which the wallet has handed out, the receiving ones first,
+with their labels. An address is used once sync finds a transaction of it or
+of an address after it.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			addrs, err := coinAccount.IssuedAddresses()
+			returnIfErr(err)
+			for _, a := range addrs {
+				chain := "receive"
+				if a.Change {
+					chain = "change"
+				}
+				state := "unused"
+				if a.Used {
+					state = "used"
+				}
+				label, err := coinAccount.Label(wallet.LabelAddr, a.Address)
+				returnIfErr(err)
+				fmt.Printf("%s %s %-7s %d %s %s\n",
+					a.Time.UTC().Format(time.RFC3339), a.Address, chain, a.Index, state, label)
+			}
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+// readPaymentRequest reads the payment request from the reply of bitmarkd in
+// a file, or from the arguments of a pay id and the payments
+func readPaymentRequest(replyFile string, args []string) (*wallet.PaymentRequest, error) {
+	if replyFile != "" {
+		var reply []byte
+		var err error
+		if replyFile == "-" {
+			reply, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			reply, err = ioutil.ReadFile(replyFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return wallet.ParsePaymentReply(reply, coinAccount.CoinType)
+	}
+
+	req := &wallet.PaymentRequest{}
+	if err := req.PayId.UnmarshalText([]byte(args[0])); err != nil {
+		return nil, wallet.ErrInvalidPayId
+	}
+	sends, err := parseSends(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sends {
+		req.Payments = append(req.Payments, &wallet.BitmarkPayment{
+			Currency: coinAccount.CoinType,
+			Address:  s.Addr,
+			Amount:   s.Amount,
+		})
+	}
+	return req, nil
+}
+
+func newPayCmd() *cobra.Command {
+	var fee feeFlags
+	var replyFile string
+	payCmd := &cobra.Command{
+		Use:   "pay [payId] [address,amount] [address,amount] ...",
+		Short: "pay for a bitmark issue or transfer",
+		Long: `pay the payment request of bitmarkd for an issue or a transfer by a single
+transaction, which pays each address and carries the pay id in an OP_RETURN
+output. The pay id and the payments are those which bitmark-cli prints, or
+--reply reads them from the JSON reply of bitmarkd in a file, or - for stdin.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if replyFile == "" && len(args) < 2 {
+				cmd.Help()
+				return
+			}
+
+			req, err := readPaymentRequest(replyFile, args)
+			returnIfErr(err)
+
+			err = coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.PayBitmark(req, fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"payId": "%s", "txId": "%s", "rawTx": "%s"}`, req.PayId, txId, rawTx)
+		},
+	}
+	payCmd.Flags().StringVar(&replyFile, "reply", "", "read the payment request from the reply of bitmarkd in the file, - for stdin")
+	fee.addFlags(payCmd.Flags())
+	return payCmd
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+// parseOutPoints parses outpoints in the form of txid:vout
+func parseOutPoints(args []string) ([]wire.OutPoint, error) {
+	outpoints := make([]wire.OutPoint, 0, len(args))
+	for _, arg := range args {
+		op, err := wallet.ParseOutPoint(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outpoint: %s", arg)
+		}
+		outpoints = append(outpoints, op)
+	}
+	return outpoints, nil
+}
+
+func newLockUnspentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lockunspent [txid:vout] [txid:vout] ...",
+		Short: "lock coins of the wallet",
+		Long: `lock coins of the wallet so that they are never spent, for example tainted
+deposits or collateral, until they are unlocked`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+			outpoints, err := parseOutPoints(args)
+			returnIfErr(err)
+			returnIfErr(coinAccount.LockUnspent(outpoints...))
+		},
+	}
+}
+
+func newUnlockUnspentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlockunspent [txid:vout] [txid:vout] ...",
+		Short: "unlock locked coins of the wallet",
+		Long:  `unlock locked coins of the wallet so that they are spent again`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+			outpoints, err := parseOutPoints(args)
+			returnIfErr(err)
+			returnIfErr(coinAccount.UnlockUnspent(outpoints...))
+		},
+	}
+}
+
+func newListLockedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "listlocked",
+		Short: "list the locked coins of the wallet",
+		Long:  `list the locked coins of the wallet`,
+		Run: func(cmd *cobra.Command, args []string) {
+			outpoints, err := coinAccount.ListLocked()
+			returnIfErr(err)
+			for _, op := range outpoints {
+				fmt.Println(op.String())
+			}
+		},
+	}
+}
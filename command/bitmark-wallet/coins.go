@@ -8,8 +8,8 @@ import (
 	"path"
 	"reflect"
 	"strconv"
-	"strings"
 
+	"github.com/btcsuite/btcd/wire"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -21,8 +21,28 @@ import (
 
 var w *wallet.Wallet
 var coinAccount *wallet.CoinAccount
+var coinAgent agent.CoinAgent
 
 var test bool
+var addressType string
+var accountIndex uint32
+var accountKey string
+var multisigRequired int
+var cosignerKeys []string
+var lockTime string
+
+var purposes = map[string]wallet.Purpose{
+	"legacy":        wallet.BIP44,
+	"nested-segwit": wallet.BIP49,
+	"segwit":        wallet.BIP84,
+	"taproot":       wallet.BIP86,
+}
+
+var multisigScriptTypes = map[string]wallet.MultisigScriptType{
+	"legacy":        wallet.MultisigP2SH,
+	"nested-segwit": wallet.MultisigP2SHP2WSH,
+	"segwit":        wallet.MultisigP2WSH,
+}
 
 type AgentData struct {
 	Type string
@@ -47,6 +67,82 @@ func (a *AgentData) ParseFlag(flag *pflag.FlagSet) {
 	}
 }
 
+// feeFlags decide the fee of a transaction
+type feeFlags struct {
+	perKB      uint64
+	rate       float64
+	confTarget uint32
+}
+
+func (f *feeFlags) addFlags(flags *pflag.FlagSet) {
+	flags.Uint64VarP(&f.perKB, "fee", "f", 0, "set fee for per kB transaction.")
+	flags.Float64Var(&f.rate, "fee-rate", 0, "set fee rate in sat/vB")
+	flags.Uint32Var(&f.confTarget, "conf-target", wallet.DefaultConfTarget, "estimate the fee to confirm within the number of blocks")
+}
+
+// fee returns the fee per kB given by the flags. It is zero if none is
+// given, and the account estimates the fee for the confirmation target.
+func (f *feeFlags) fee() uint64 {
+	if f.perKB != 0 && f.rate != 0 {
+		returnIfErr(fmt.Errorf("--fee and --fee-rate are exclusive"))
+	}
+	coinAccount.SetConfTarget(f.confTarget)
+	if f.rate != 0 {
+		return wallet.SatPerVByte(f.rate)
+	}
+	return f.perKB
+}
+
+// setCoinSelector sets the coin selection strategy of the account by name
+func setCoinSelector(name string) {
+	selector, ok := wallet.CoinSelectors[name]
+	if !ok {
+		returnIfErr(fmt.Errorf("unsupported coin selection: %s", name))
+	}
+	coinAccount.SetCoinSelector(selector)
+}
+
+// openWallet decrypts the seed in the wallet db and returns the wallet
+func openWallet(dataFile string) *wallet.Wallet {
+	encryptedSeed, err := getWalletConfig(dataFile, []byte("SEED"))
+	returnIfErr(err)
+
+	password, err := readPassword("Input wallet password: ", 0)
+	returnIfErr(err)
+
+	passHash := dblSHA256([]byte(password))
+	seed, err := decryptSeed(encryptedSeed, passHash[:])
+	returnIfErr(err)
+
+	seedHash, err := getWalletConfig(dataFile, []byte("HASH"))
+	returnIfErr(err)
+
+	if bytes.Compare(seedHash, dblSHA256(seed)) != 0 {
+		returnIfErr(fmt.Errorf("incorrect password"))
+	}
+
+	return wallet.New(seed, dataFile)
+}
+
+// openAccount decrypts the seed in the wallet db and returns the account
+func openAccount(dataFile string, purpose wallet.Purpose, ct wallet.CoinType) *wallet.CoinAccount {
+	w = openWallet(dataFile)
+
+	coinAccount, err := w.Account(purpose, ct, wallet.Test(test), accountIndex)
+	returnIfErr(err)
+	return coinAccount
+}
+
+// openMultisigAccount decrypts the seed in the wallet db and returns the
+// multisig account of the seed and the cosigners
+func openMultisigAccount(dataFile string, scriptType wallet.MultisigScriptType, ct wallet.CoinType) *wallet.CoinAccount {
+	w = openWallet(dataFile)
+
+	coinAccount, err := w.MultisigAccount(ct, wallet.Test(test), accountIndex, scriptType, multisigRequired, cosignerKeys)
+	returnIfErr(err)
+	return coinAccount
+}
+
 func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command {
 	var agentData AgentData
 	cobra.OnInitialize(func() {
@@ -117,37 +213,49 @@ func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command
 				returnIfErr(fmt.Errorf("invalid wallet path"))
 			}
 
-			encryptedSeed, err := getWalletConfig(dataFile, []byte("SEED"))
-			returnIfErr(err)
-
-			password, err := readPassword("Input wallet password: ", 0)
-			returnIfErr(err)
-
-			passHash := dblSHA256([]byte(password))
-			seed, err := decryptSeed(encryptedSeed, passHash[:])
-			returnIfErr(err)
-
-			seedHash, err := getWalletConfig(dataFile, []byte("HASH"))
-			returnIfErr(err)
-
-			if bytes.Compare(seedHash, dblSHA256(seed)) != 0 {
-				returnIfErr(fmt.Errorf("incorrect password"))
+			purpose, ok := purposes[addressType]
+			if !ok {
+				returnIfErr(fmt.Errorf("unsupported address type: %s", addressType))
 			}
 
-			w = wallet.New(seed, dataFile)
+			if accountKey != "" && accountIndex != 0 {
+				returnIfErr(fmt.Errorf("--account and --xpub are exclusive"))
+			}
 
-			coinAccount, err = w.CoinAccount(ct, wallet.Test(test), 0)
-			returnIfErr(err)
+			var err error
+			if multisigRequired > 0 {
+				scriptType, ok := multisigScriptTypes[addressType]
+				if !ok {
+					returnIfErr(fmt.Errorf("unsupported address type of multisig: %s", addressType))
+				}
+				if accountKey != "" {
+					coinAccount, err = wallet.NewWatchOnlyMultisigAccount(accountKey, ct, wallet.Test(test), scriptType,
+						multisigRequired, cosignerKeys, dataFile)
+					returnIfErr(err)
+				} else {
+					coinAccount = openMultisigAccount(dataFile, scriptType, ct)
+				}
+			} else if accountKey != "" {
+				coinAccount, err = wallet.NewWatchOnlyAccount(accountKey, purpose, ct, wallet.Test(test), dataFile)
+				returnIfErr(err)
+			} else {
+				coinAccount = openAccount(dataFile, purpose, ct)
+			}
 
-			var a agent.CoinAgent
 			switch agentData.Type {
 			case "daemon":
 				fallthrough
 			default:
 				url := fmt.Sprintf("http://%s/", agentData.Node)
-				a = agent.NewDaemonAgent(url, agentData.User, agentData.Pass)
+				coinAgent = agent.NewDaemonAgent(url, agentData.User, agentData.Pass)
+			}
+			coinAccount.SetAgent(coinAgent)
+
+			if lockTime != "" {
+				t, err := parseLockTime(lockTime)
+				returnIfErr(err)
+				coinAccount.SetLockTime(t)
 			}
-			coinAccount.SetAgent(a)
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Help()
@@ -159,59 +267,104 @@ func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command
 	cmd.PersistentFlags().StringP("agent-pass", "P", "", "password of an agent")
 
 	cmd.PersistentFlags().BoolVarP(&test, "testnet", "t", false, "use the wallet in testnet")
+	cmd.PersistentFlags().StringVar(&addressType, "address-type", "legacy", "address type of the account: legacy, nested-segwit, segwit, taproot")
+	cmd.PersistentFlags().Uint32Var(&accountIndex, "account", 0, "index of the account of the seed")
+	cmd.PersistentFlags().StringVar(&accountKey, "xpub", "", "use a watch-only account of the extended public key instead of the seed")
+	cmd.PersistentFlags().IntVar(&multisigRequired, "multisig", 0, "use a multisig account which requires the number of signatures")
+	cmd.PersistentFlags().StringArrayVar(&cosignerKeys, "cosigner", nil, "extended public key of a cosigner with its key origin, repeated for each cosigner")
+	cmd.PersistentFlags().StringVar(&lockTime, "locktime", "", "set nLockTime of the transactions to a block height or a time in RFC3339")
 	cmd.AddCommand(&cobra.Command{
 		Use:   "balance",
 		Short: "get balance of the wallet",
 		Long:  `get balance of the wallet`,
 
 		Run: func(cmd *cobra.Command, args []string) {
-			bal, err := coinAccount.GetBalance()
-			returnIfErr(err)
-			fmt.Println("Balance: ", bal)
+			printBalance()
 		},
 	})
 
+	cmd.AddCommand(newSyncCmd())
+	cmd.AddCommand(newLastSyncCmd())
+	cmd.AddCommand(newGapLimitCmd())
+
 	cmd.AddCommand(&cobra.Command{
-		Use:   "sync",
-		Short: "sync the wallet from the network",
-		Long:  `sync the wallet from the network`,
+		Use:   "xpub",
+		Short: "show the extended public key of the account",
+		Long:  `show the extended public key of the account with its key origin for a watch-only wallet`,
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Sync data from network. It takes a period of time...")
-			err := coinAccount.Discover()
-			returnIfErr(err)
-			bal, err := coinAccount.GetBalance()
+			accountKey, err := coinAccount.AccountKey()
 			returnIfErr(err)
-			fmt.Println("Balance: ", bal)
+			fmt.Println("Account key: ", accountKey)
 		},
 	})
 
-	cmd.AddCommand(&cobra.Command{
+	var addrLabel string
+	newAddressCmd := &cobra.Command{
 		Use:   "newaddress",
-		Short: "generate an used address of the wallet",
-		Long:  `generate an used address of the wallet`,
+		Short: "generate an unused address of the wallet",
+		Long: `generate an unused address of the wallet. An address is never handed out
+twice. Restoring the seed only discovers the coins of the unused addresses up
+to the gap limit, so a warning is shown once more addresses are handed out
+without being used.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			addr, err := coinAccount.NewExternalAddr()
 			returnIfErr(err)
+			if addrLabel != "" {
+				returnIfErr(coinAccount.SetLabel(wallet.LabelAddr, addr, addrLabel))
+			}
 			fmt.Println("Address: ", addr)
+			warnUnusedAddresses()
 		},
-	})
-
-	var fee uint64
-	var hexData string
+	}
+	newAddressCmd.Flags().StringVar(&addrLabel, "label", "", "label the address, for example why it is handed out")
+	cmd.AddCommand(newAddressCmd)
+	cmd.AddCommand(newReceiveCmd())
+
+	var fee feeFlags
+	var hexData, coinSelection string
+	var subtractFee bool
+	var subtractFeeFrom []string
+	var fromUTXOs []string
+	var txLabel string
 	sendCmd := &cobra.Command{
-		Use:   "send [address] [amount]",
+		Use:   "send [address|uri] [amount|max]",
 		Short: "send coins to an address",
-		Long:  `send coins to an address`,
+		Long: `send coins to an address. The amount "max" sends all the coins of the
+wallet, or all the coins given by --utxo, without a change, and the fee is
+deducted from it. A BIP21 URI of the coin gives the address, and the amount
+unless it is given. Its message, or its label, labels the transaction unless
+--label is given.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if len(args) < 2 {
+			if len(args) < 1 || len(args) < 2 && !isPaymentURI(args[0]) {
 				cmd.Help()
 				return
 			}
 			address := args[0]
 
-			amount, err := strconv.ParseUint(args[1], 10, 64)
-			if err != nil {
-				returnIfErr(fmt.Errorf("invalid amount to send"))
+			var amount uint64
+			var err error
+			if isPaymentURI(address) {
+				p, err := parseSendURI(address)
+				returnIfErr(err)
+				address, amount = p.Address, p.Amount
+				if txLabel == "" {
+					txLabel = p.Message
+				}
+				if txLabel == "" {
+					txLabel = p.Label
+				}
+				if len(args) < 2 {
+					if amount == 0 {
+						returnIfErr(fmt.Errorf("payment URI has no amount to send"))
+					}
+					args = append(args, strconv.FormatUint(amount, 10))
+				}
+			}
+			if args[1] != "max" {
+				amount, err = strconv.ParseUint(args[1], 10, 64)
+				if err != nil {
+					returnIfErr(fmt.Errorf("invalid amount to send"))
+				}
 			}
 
 			var customData []byte
@@ -220,16 +373,41 @@ func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command
 				returnIfErr(err)
 			}
 
+			setCoinSelector(coinSelection)
+
 			err = coinAccount.Discover()
 			returnIfErr(err)
 
-			txId, rawTx, err := coinAccount.Send([]*tx.Send{{address, amount}}, customData, fee)
+			var txId, rawTx string
+			sends := []*tx.Send{{Addr: address, Amount: amount, SubtractFee: subtractFee}}
+			var outpoints []wire.OutPoint
+			if len(fromUTXOs) > 0 {
+				outpoints, err = parseOutPoints(fromUTXOs)
+				returnIfErr(err)
+			}
+			switch {
+			case args[1] == "max" && len(outpoints) > 0:
+				txId, rawTx, err = coinAccount.SendAllFrom(outpoints, address, customData, fee.fee())
+			case args[1] == "max":
+				txId, rawTx, err = coinAccount.SendAll(address, customData, fee.fee())
+			case len(outpoints) > 0:
+				txId, rawTx, err = coinAccount.SendFrom(outpoints, sends, customData, fee.fee())
+			default:
+				txId, rawTx, err = coinAccount.Send(sends, customData, fee.fee())
+			}
 			returnIfErr(err)
+			if txLabel != "" {
+				returnIfErr(coinAccount.SetLabel(wallet.LabelTx, txId, txLabel))
+			}
 			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
 		},
 	}
+	sendCmd.Flags().StringVar(&txLabel, "label", "", "label the transaction")
 	sendCmd.Flags().StringVarP(&hexData, "hex-data", "H", "", "set hex bytes in the OP_RETURN")
-	sendCmd.Flags().Uint64VarP(&fee, "fee", "f", 0, "set fee for per kB transaction.")
+	fee.addFlags(sendCmd.Flags())
+	sendCmd.Flags().BoolVar(&subtractFee, "subtract-fee", false, "deduct the fee from the amount")
+	sendCmd.Flags().StringArrayVar(&fromUTXOs, "utxo", nil, "spend exactly the coin of txid:vout, repeated for each coin")
+	sendCmd.Flags().StringVar(&coinSelection, "coin-selection", "bnb", "coin selection strategy: bnb, largest, smallest, oldest, privacy")
 	cmd.AddCommand(sendCmd)
 
 	sendManyCmd := &cobra.Command{
@@ -237,27 +415,14 @@ func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command
 		Short: "send coins to an address",
 		Long:  `send coins to an address`,
 		Run: func(cmd *cobra.Command, args []string) {
-			var err error
 			if len(args) < 1 {
 				cmd.Help()
 				return
 			}
 
-			sends := []*tx.Send{}
-			for _, s := range args {
-				sendStrings := strings.Split(s, ",")
-				if 2 != len(sendStrings) {
-					returnIfErr(fmt.Errorf("argument must be 'address,satoshis'"))
-				}
-				addr := sendStrings[0]
-				amount, err := strconv.ParseUint(sendStrings[1], 10, 64)
-				if err != nil {
-					returnIfErr(fmt.Errorf("invalid amount to send"))
-				}
-
-				send := &tx.Send{Addr: addr, Amount: amount}
-				sends = append(sends, send)
-			}
+			sends, err := parseSends(args)
+			returnIfErr(err)
+			returnIfErr(setSubtractFee(sends, subtractFeeFrom))
 
 			var customData []byte
 			if hexData != "" {
@@ -265,16 +430,47 @@ func NewCoinCmd(coinType, short, long string, ct wallet.CoinType) *cobra.Command
 				returnIfErr(err)
 			}
 
+			setCoinSelector(coinSelection)
+
 			err = coinAccount.Discover()
 			returnIfErr(err)
 
-			txId, rawTx, err := coinAccount.Send(sends, customData, fee)
+			txId, rawTx, err := coinAccount.Send(sends, customData, fee.fee())
 			returnIfErr(err)
 			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
 		},
 	}
 	sendManyCmd.Flags().StringVarP(&hexData, "hex-data", "H", "", "set hex bytes in the OP_RETURN")
-	sendManyCmd.Flags().Uint64VarP(&fee, "fee", "f", 0, "set fee for per kB transaction.")
+	fee.addFlags(sendManyCmd.Flags())
+	sendManyCmd.Flags().StringArrayVar(&subtractFeeFrom, "subtract-fee-from", nil, "deduct the fee from the amount to the address, split evenly if repeated")
+	sendManyCmd.Flags().StringVar(&coinSelection, "coin-selection", "bnb", "coin selection strategy: bnb, largest, smallest, oldest, privacy")
 	cmd.AddCommand(sendManyCmd)
+
+	cmd.AddCommand(newBumpFeeCmd())
+	cmd.AddCommand(newCancelCmd())
+	cmd.AddCommand(newCPFPCmd())
+	cmd.AddCommand(newSweepCmd())
+	cmd.AddCommand(newPayCmd())
+	cmd.AddCommand(newTimelockCmd())
+	cmd.AddCommand(newTimelocksCmd())
+	cmd.AddCommand(newRedeemCmd())
+	cmd.AddCommand(newLockUnspentCmd())
+	cmd.AddCommand(newUnlockUnspentCmd())
+	cmd.AddCommand(newListLockedCmd())
+	cmd.AddCommand(newUnspentCmd())
+	cmd.AddCommand(newAccountsCmd(ct))
+	cmd.AddCommand(newAddressesCmd())
+	cmd.AddCommand(newSignMessageCmd())
+	cmd.AddCommand(newVerifyMessageCmd())
+	cmd.AddCommand(newHistoryCmd())
+	cmd.AddCommand(newPendingCmd())
+	cmd.AddCommand(newLabelCmd())
+	cmd.AddCommand(newExportLabelsCmd())
+	cmd.AddCommand(newImportLabelsCmd())
+
+	cmd.AddCommand(newCreateTxCmd(coinType))
+	cmd.AddCommand(newSignCmd(coinType))
+	cmd.AddCommand(newCombineCmd(coinType))
+	cmd.AddCommand(newBroadcastCmd(coinType))
 	return cmd
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newCPFPCmd() *cobra.Command {
+	var fee feeFlags
+	var coinSelection string
+	cpfpCmd := &cobra.Command{
+		Use:   "cpfp [txid]",
+		Short: "accelerate an unconfirmed transaction by spending its outputs",
+		Long: `accelerate an unconfirmed transaction, either received or sent, by a child
+transaction which spends its outputs to the wallet. The fee per kB is the target
+of the transaction and the child together.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			setCoinSelector(coinSelection)
+
+			err := coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.CPFP(args[0], fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(cpfpCmd.Flags())
+	cpfpCmd.Flags().StringVar(&coinSelection, "coin-selection", "bnb", "coin selection strategy: bnb, largest, smallest, oldest, privacy")
+	return cpfpCmd
+}
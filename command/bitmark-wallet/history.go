@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+func newHistoryCmd() *cobra.Command {
+	var limit, page int
+	var direction, address string
+	var pending bool
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "list the transactions of the wallet",
+		Long: `list the transactions of the wallet, the newest first, with their labels. The
+amount of an incoming transaction is what the wallet receives, and the one of an
+outgoing transaction is what it pays the others without the fee. Run sync first
+to update the history from the network.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if limit < 1 || page < 1 {
+				returnIfErr(fmt.Errorf("--limit and --page must be positive"))
+			}
+			filter := wallet.HistoryFilter{
+				Address: address,
+				Pending: pending,
+				Offset:  (page - 1) * limit,
+				Limit:   limit,
+			}
+			switch direction {
+			case "":
+			case "in":
+				filter.Direction = wallet.TxIncoming
+			case "out":
+				filter.Direction = wallet.TxOutgoing
+			default:
+				returnIfErr(fmt.Errorf("unsupported direction: %s", direction))
+			}
+
+			txs, err := coinAccount.History(filter)
+			returnIfErr(err)
+			for _, t := range txs {
+				label, err := coinAccount.Label(wallet.LabelTx, t.TxId)
+				returnIfErr(err)
+				state := t.State.String()
+				if t.State == wallet.TxConfirmed {
+					state = fmt.Sprintf("%s(%d)", state, t.Confirmations)
+				}
+				fmt.Printf("%s %s %-3s %d fee:%d %s %s\n",
+					t.Time.UTC().Format(time.RFC3339), t.TxId, t.Direction, t.Total(), t.Fee, state, label)
+			}
+		},
+	}
+	historyCmd.Flags().IntVar(&limit, "limit", 20, "number of the transactions of a page")
+	historyCmd.Flags().IntVar(&page, "page", 1, "page of the transactions")
+	historyCmd.Flags().StringVar(&direction, "direction", "", "list only the transactions of a direction: in, out")
+	historyCmd.Flags().StringVar(&address, "address", "", "list only the transactions which pay the address")
+	historyCmd.Flags().BoolVar(&pending, "pending", false, "list only the unconfirmed transactions")
+	return historyCmd
+}
+
+func newPendingCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pending",
+		Short: "list the coins of the pending transactions",
+		Long: `list the coins which the broadcast transactions spend or create until sync
+finds them on the network. The spent coins are not spent again, and the created
+ones, such as the changes, are spendable right away.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			pending, err := coinAccount.PendingOutPoints()
+			returnIfErr(err)
+			for _, p := range pending {
+				state := "created"
+				if p.Spent {
+					state = "spent"
+				}
+				fmt.Printf("%s %-7s %s %d by %s at %s\n",
+					p.OutPoint, state, p.Address, p.Value, p.TxId, p.Time.UTC().Format(time.RFC3339))
+			}
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+// labelType guesses the type of a reference: txid:vout for an output, txid
+// for a transaction and an address for the others
+func labelType(ref string) wallet.LabelType {
+	if _, err := wallet.ParseOutPoint(ref); err == nil {
+		return wallet.LabelOutput
+	}
+	if _, err := chainhash.NewHashFromStr(ref); err == nil && len(ref) == 2*chainhash.HashSize {
+		return wallet.LabelTx
+	}
+	return wallet.LabelAddr
+}
+
+// printBalance prints the balance of the wallet and the coins of each
+// address with its label
+func printBalance() {
+	bal, err := coinAccount.GetBalance()
+	returnIfErr(err)
+	fmt.Println("Balance: ", bal)
+
+	coins, err := coinAccount.ListUnspent()
+	returnIfErr(err)
+	addrs := make([]string, 0)
+	amounts := make(map[string]uint64)
+	for _, u := range coins {
+		if _, ok := amounts[u.Address]; !ok {
+			addrs = append(addrs, u.Address)
+		}
+		amounts[u.Address] += u.Value
+	}
+	for _, addr := range addrs {
+		label, err := coinAccount.Label(wallet.LabelAddr, addr)
+		returnIfErr(err)
+		fmt.Printf("%s %d %s\n", addr, amounts[addr], label)
+	}
+}
+
+func newLabelCmd() *cobra.Command {
+	var refType string
+	labelCmd := &cobra.Command{
+		Use:   "label [address|txid|txid:vout] [label]",
+		Short: "label an address, a transaction or a coin",
+		Long: `label an address, a transaction or a coin of the wallet. The type of the
+reference is guessed from its form unless --type is given. An empty label
+removes it.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				cmd.Help()
+				return
+			}
+			t := wallet.LabelType(refType)
+			if refType == "" {
+				t = labelType(args[0])
+			}
+			returnIfErr(coinAccount.SetLabel(t, args[0], args[1]))
+		},
+	}
+	labelCmd.Flags().StringVar(&refType, "type", "", "type of the reference of BIP329: tx, addr, pubkey, input, output, xpub")
+	return labelCmd
+}
+
+func newUnspentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unspent",
+		Short: "list the coins of the wallet",
+		Long:  `list the coins of the wallet with their labels. The locked coins are marked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			coins, err := coinAccount.ListUnspent()
+			returnIfErr(err)
+			for _, u := range coins {
+				locked := ""
+				if u.Locked {
+					locked = " locked"
+				}
+				fmt.Printf("%s %s %d %d%s %s\n", u.OutPoint, u.Address, u.Value, u.Confirmations, locked, u.Label)
+			}
+		},
+	}
+}
+
+func newExportLabelsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "exportlabels [file]",
+		Short: "export the labels in BIP329",
+		Long:  `export the labels of the wallet in the JSON lines of BIP329 to a file or the standard output`,
+		Run: func(cmd *cobra.Command, args []string) {
+			out := os.Stdout
+			if len(args) > 0 {
+				f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				returnIfErr(err)
+				defer f.Close()
+				out = f
+			}
+			returnIfErr(coinAccount.ExportLabels(out))
+		},
+	}
+}
+
+func newImportLabelsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "importlabels [file]",
+		Short: "import labels in BIP329",
+		Long: `import labels in the JSON lines of BIP329 from another wallet. The outputs
+which are not spendable are locked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+			f, err := os.Open(args[0])
+			returnIfErr(err)
+			defer f.Close()
+
+			n, err := coinAccount.ImportLabels(f)
+			returnIfErr(err)
+			fmt.Printf("Imported %d labels\n", n)
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+func newSignMessageCmd() *cobra.Command {
+	var format string
+	signMessageCmd := &cobra.Command{
+		Use:   "signmessage [address] [message]",
+		Short: "sign a message by the key of an address of the wallet",
+		Long: `sign a message by the key of an address which the wallet has handed out, to
+prove that the wallet controls it. The signature is in the simple format of
+BIP322 for the segwit and taproot address types, and in the legacy Bitcoin
+Signed Message format for the others. --format legacy signs for a segwit
+address in the legacy format with the header of BIP137 for the verifiers which
+do not support BIP322.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				cmd.Help()
+				return
+			}
+
+			messageFormat := wallet.MessageLegacy
+			switch format {
+			case "":
+				if addressType == "segwit" || addressType == "taproot" {
+					messageFormat = wallet.MessageBIP322
+				}
+			case "legacy":
+			case "bip322":
+				messageFormat = wallet.MessageBIP322
+			default:
+				returnIfErr(fmt.Errorf("unsupported message format: %s", format))
+			}
+
+			sig, err := coinAccount.SignMessage(args[0], args[1], messageFormat)
+			returnIfErr(err)
+			fmt.Println("Signature: ", sig)
+		},
+	}
+	signMessageCmd.Flags().StringVar(&format, "format", "", "format of the signature: legacy, bip322")
+	return signMessageCmd
+}
+
+func newVerifyMessageCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verifymessage [address] [message] [signature]",
+		Short: "verify the signature of a message by an address",
+		Long: `verify the signature of a message by an address in the legacy Bitcoin Signed
+Message format or the simple format of BIP322. The address needs not be an
+address of the wallet.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 3 {
+				cmd.Help()
+				return
+			}
+			returnIfErr(coinAccount.VerifyMessage(args[0], args[1], args[2]))
+			fmt.Println("Signature is valid")
+		},
+	}
+}
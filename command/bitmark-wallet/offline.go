@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// TxFile is the file exchanged between the online and the offline machines.
+// The summary is for people to inspect the transaction. The signer always
+// computes it again from the PSBT instead of trusting the file.
+type TxFile struct {
+	Coin    string `json:"coin"`
+	Testnet bool   `json:"testnet"`
+	*wallet.PSBTSummary
+	PSBT  string `json:"psbt"`
+	RawTx string `json:"rawTx,omitempty"`
+}
+
+func readTxFile(filename, coinType string) (*TxFile, *psbt.Packet, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f TxFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, nil, err
+	}
+	if f.Coin != coinType || f.Testnet != test {
+		return nil, nil, fmt.Errorf("the transaction is for %s (testnet: %t)", f.Coin, f.Testnet)
+	}
+	p, err := psbt.NewFromRawBytes(strings.NewReader(f.PSBT), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &f, p, nil
+}
+
+func writeTxFile(filename, coinType string, p *psbt.Packet, s *wallet.PSBTSummary, rawTx string) error {
+	b64, err := p.B64Encode()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(TxFile{
+		Coin:        coinType,
+		Testnet:     test,
+		PSBTSummary: s,
+		PSBT:        b64,
+		RawTx:       rawTx,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0600)
+}
+
+func printSummary(s *wallet.PSBTSummary) {
+	fmt.Println("Inputs:")
+	for _, in := range s.Inputs {
+		fmt.Printf("  %s:%d %s %d\n", in.TxId, in.Vout, in.Address, in.Amount)
+	}
+	fmt.Println("Outputs:")
+	for _, out := range s.Outputs {
+		switch {
+		case out.Change:
+			fmt.Printf("  %s %d (change)\n", out.Address, out.Amount)
+		case out.Data != "":
+			fmt.Printf("  OP_RETURN %s %d\n", out.Data, out.Amount)
+		default:
+			fmt.Printf("  %s %d\n", out.Address, out.Amount)
+		}
+	}
+	fmt.Println("Fee: ", s.Fee)
+}
+
+// parseSends parses the arguments in the form of address,satoshis
+func parseSends(args []string) ([]*tx.Send, error) {
+	sends := []*tx.Send{}
+	for _, s := range args {
+		sendStrings := strings.Split(s, ",")
+		if 2 != len(sendStrings) {
+			return nil, fmt.Errorf("argument must be 'address,satoshis'")
+		}
+		amount, err := strconv.ParseUint(sendStrings[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount to send")
+		}
+		sends = append(sends, &tx.Send{Addr: sendStrings[0], Amount: amount})
+	}
+	return sends, nil
+}
+
+// setSubtractFee marks the sends to the addresses to deduct the fee from
+// their amounts
+func setSubtractFee(sends []*tx.Send, addrs []string) error {
+	for _, addr := range addrs {
+		found := false
+		for _, s := range sends {
+			if s.Addr == addr {
+				s.SubtractFee = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no send to %s to subtract the fee from", addr)
+		}
+	}
+	return nil
+}
+
+func newCreateTxCmd(coinType string) *cobra.Command {
+	var fee feeFlags
+	var hexData, output, coinSelection string
+	var subtractFeeFrom []string
+	createTxCmd := &cobra.Command{
+		Use:   "createtx [address,amount] [address,amount] ...",
+		Short: "create an unsigned transaction file",
+		Long:  `create an unsigned transaction file to be signed by an offline wallet`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			sends, err := parseSends(args)
+			returnIfErr(err)
+			returnIfErr(setSubtractFee(sends, subtractFeeFrom))
+
+			var customData []byte
+			if hexData != "" {
+				customData, err = hex.DecodeString(hexData)
+				returnIfErr(err)
+			}
+
+			setCoinSelector(coinSelection)
+
+			err = coinAccount.Discover()
+			returnIfErr(err)
+
+			p, err := coinAccount.CreatePSBT(sends, customData, fee.fee())
+			returnIfErr(err)
+			s, err := coinAccount.SummarizePSBT(p)
+			returnIfErr(err)
+
+			returnIfErr(writeTxFile(output, coinType, p, s, ""))
+			printSummary(s)
+			fmt.Println("Unsigned transaction: ", output)
+		},
+	}
+	createTxCmd.Flags().StringVarP(&hexData, "hex-data", "H", "", "set hex bytes in the OP_RETURN")
+	fee.addFlags(createTxCmd.Flags())
+	createTxCmd.Flags().StringVar(&coinSelection, "coin-selection", "bnb", "coin selection strategy: bnb, largest, smallest, oldest, privacy")
+	createTxCmd.Flags().StringArrayVar(&subtractFeeFrom, "subtract-fee-from", nil, "deduct the fee from the amount to the address, split evenly if repeated")
+	createTxCmd.Flags().StringVarP(&output, "output", "o", "unsigned-tx.json", "file of the unsigned transaction")
+	return createTxCmd
+}
+
+func newSignCmd(coinType string) *cobra.Command {
+	var confirmOutputs []string
+	var confirmData string
+	var confirmFee uint64
+	signCmd := &cobra.Command{
+		Use:   "sign [unsigned file] [signed file]",
+		Short: "sign a transaction file",
+		Long: `sign a transaction file without the network. The payments and the fee
+have to be confirmed, and the transaction is signed only if it has exactly
+the confirmed outputs besides the change, and the confirmed fee. A transaction
+which asks for signatures not covering all its inputs and outputs, or lacks the
+previous transaction of a non-taproot input, is refused.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				cmd.Help()
+				return
+			}
+
+			_, p, err := readTxFile(args[0], coinType)
+			returnIfErr(err)
+
+			s, err := coinAccount.SummarizePSBT(p)
+			returnIfErr(err)
+			printSummary(s)
+
+			if !cmd.Flags().Changed("confirm-fee") || len(confirmOutputs) == 0 {
+				returnIfErr(fmt.Errorf("confirm the payments and the fee by --confirm-output and --confirm-fee"))
+			}
+			sends, err := parseSends(confirmOutputs)
+			returnIfErr(err)
+			var customData []byte
+			if confirmData != "" {
+				customData, err = hex.DecodeString(confirmData)
+				returnIfErr(err)
+			}
+			returnIfErr(s.Confirm(sends, customData, confirmFee))
+
+			n, err := coinAccount.SignPSBT(p)
+			returnIfErr(err)
+			if n == 0 {
+				returnIfErr(fmt.Errorf("no input of the wallet to sign"))
+			}
+
+			var rawTx string
+			signedTx, err := wallet.FinalizePSBT(p)
+			switch err {
+			case nil:
+				var buf bytes.Buffer
+				returnIfErr(signedTx.Serialize(&buf))
+				rawTx = hex.EncodeToString(buf.Bytes())
+			case wallet.ErrPSBTIncomplete:
+				fmt.Println("The transaction needs more signatures")
+			default:
+				returnIfErr(err)
+			}
+
+			returnIfErr(writeTxFile(args[1], coinType, p, s, rawTx))
+			fmt.Println("Signed transaction: ", args[1])
+		},
+	}
+	signCmd.Flags().StringArrayVar(&confirmOutputs, "confirm-output", nil, "confirm a payment in the form of address,satoshis")
+	signCmd.Flags().StringVar(&confirmData, "confirm-data", "", "confirm the hex bytes in the OP_RETURN")
+	signCmd.Flags().Uint64Var(&confirmFee, "confirm-fee", 0, "confirm the fee in satoshis")
+	return signCmd
+}
+
+func newCombineCmd(coinType string) *cobra.Command {
+	var output string
+	combineCmd := &cobra.Command{
+		Use:   "combine [signed file] [signed file] ...",
+		Short: "combine the signatures of transaction files",
+		Long: `combine the transaction files signed by the cosigners of a multisig account
+in parallel. The transaction is finalized once it has the signatures of the
+required cosigners.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				cmd.Help()
+				return
+			}
+
+			packets := make([]*psbt.Packet, 0, len(args))
+			for _, filename := range args {
+				_, p, err := readTxFile(filename, coinType)
+				returnIfErr(err)
+				packets = append(packets, p)
+			}
+			p, err := wallet.CombinePSBT(packets...)
+			returnIfErr(err)
+
+			s, err := coinAccount.SummarizePSBT(p)
+			returnIfErr(err)
+			printSummary(s)
+
+			var rawTx string
+			signedTx, err := wallet.FinalizePSBT(p)
+			switch err {
+			case nil:
+				var buf bytes.Buffer
+				returnIfErr(signedTx.Serialize(&buf))
+				rawTx = hex.EncodeToString(buf.Bytes())
+			case wallet.ErrPSBTIncomplete:
+				fmt.Println("The transaction needs more signatures")
+			default:
+				returnIfErr(err)
+			}
+
+			returnIfErr(writeTxFile(output, coinType, p, s, rawTx))
+			fmt.Println("Combined transaction: ", output)
+		},
+	}
+	combineCmd.Flags().StringVarP(&output, "output", "o", "combined-tx.json", "file of the combined transaction")
+	return combineCmd
+}
+
+func newBroadcastCmd(coinType string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "broadcast [signed file]",
+		Short: "broadcast a signed transaction file",
+		Long:  `broadcast a signed transaction file to the network`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			_, p, err := readTxFile(args[0], coinType)
+			returnIfErr(err)
+
+			signedTx, err := wallet.FinalizePSBT(p)
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.Broadcast(signedTx)
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newBumpFeeCmd() *cobra.Command {
+	var fee feeFlags
+	var coinSelection string
+	bumpFeeCmd := &cobra.Command{
+		Use:   "bumpfee [txid]",
+		Short: "replace an unconfirmed transaction with a higher fee",
+		Long: `replace an unconfirmed transaction of the wallet with one paying the same
+outputs and a higher fee. The fee is taken from the change, and more coins are
+spent if the change is not enough.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			setCoinSelector(coinSelection)
+
+			err := coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.BumpFee(args[0], fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(bumpFeeCmd.Flags())
+	bumpFeeCmd.Flags().StringVar(&coinSelection, "coin-selection", "bnb", "coin selection strategy: bnb, largest, smallest, oldest, privacy")
+	return bumpFeeCmd
+}
+
+func newCancelCmd() *cobra.Command {
+	var fee feeFlags
+	cancelCmd := &cobra.Command{
+		Use:   "cancel [txid]",
+		Short: "cancel an unconfirmed transaction",
+		Long: `cancel an unconfirmed transaction of the wallet by replacing it with one
+sending its coins back to the wallet with a higher fee`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			err := coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.CancelTx(args[0], fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(cancelCmd.Flags())
+	return cancelCmd
+}
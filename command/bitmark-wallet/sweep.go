@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newSweepCmd() *cobra.Command {
+	var fee feeFlags
+	sweepCmd := &cobra.Command{
+		Use:   "sweep [wif] [wif] ...",
+		Short: "sweep the coins of private keys into the wallet",
+		Long: `sweep all the coins of private keys in WIF, such as a paper wallet, into a
+new address of the wallet. The keys are read from the terminal, or from the
+environment variable WALLET_WIF_KEYS, if they are not given as arguments.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			keys := args
+			if len(keys) == 0 {
+				var err error
+				keys, err = readWIFKeys()
+				returnIfErr(err)
+			}
+
+			err := coinAccount.Discover()
+			returnIfErr(err)
+
+			fmt.Println("Scan the coins of the keys. It takes a period of time...")
+			txId, rawTx, err := coinAccount.SweepWIF(keys, fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(sweepCmd.Flags())
+	return sweepCmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+func newSyncCmd() *cobra.Command {
+	var rescan bool
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "sync the wallet from the network",
+		Long: `sync the wallet from the network. It resumes from the addresses which the last
+sync has scanned, and --rescan scans all the addresses from the first one
+again, for example after the agent node is replaced.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Sync data from network. It takes a period of time...")
+			scanned := 0
+			coinAccount.SetDiscoverProgress(func(p wallet.DiscoverProgress) {
+				scanned += 1
+				if p.Used {
+					fmt.Printf("Found transactions of %s\n", p.Address)
+				}
+			})
+			var err error
+			if rescan {
+				err = coinAccount.Rescan()
+			} else {
+				err = coinAccount.Discover()
+			}
+			returnIfErr(err)
+
+			sync, err := coinAccount.LastSync()
+			returnIfErr(err)
+			fmt.Printf("Scanned %d addresses at block %d\n", scanned, sync.Height)
+			printBalance()
+		},
+	}
+	syncCmd.Flags().BoolVar(&rescan, "rescan", false, "scan all the addresses from the first one")
+	return syncCmd
+}
+
+func newLastSyncCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lastsync",
+		Short: "show when the wallet is last synced",
+		Long:  `show when the wallet is last synced and the block height then`,
+		Run: func(cmd *cobra.Command, args []string) {
+			sync, err := coinAccount.LastSync()
+			returnIfErr(err)
+			if sync.Time.IsZero() {
+				fmt.Println("Never synced")
+				return
+			}
+			fmt.Printf("Last sync: %s at block %d\n", sync.Time.UTC().Format(time.RFC3339), sync.Height)
+		},
+	}
+}
+
+func newGapLimitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "gaplimit [gap]",
+		Short: "show or set the gap limit of the account",
+		Long: `show or set the gap limit of the account, the number of the unused addresses
+after the last used one which sync scans. A wallet which hands out many
+addresses before they are paid needs a larger one so that restoring its seed
+still finds all of its coins.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 {
+				gap, err := strconv.ParseUint(args[0], 10, 32)
+				returnIfErr(err)
+				returnIfErr(coinAccount.SetGapLimit(uint32(gap)))
+			}
+			gap, err := coinAccount.GapLimit()
+			returnIfErr(err)
+			fmt.Println("Gap limit: ", gap)
+		},
+	}
+}
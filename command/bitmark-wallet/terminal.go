@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -29,6 +30,30 @@ func readMnemonic() (string, error) {
 	return console.ReadLine()
 }
 
+func readWIFKeys() ([]string, error) {
+	keys := os.Getenv("WALLET_WIF_KEYS")
+	if keys != "" {
+		return strings.Fields(keys), nil
+	}
+
+	oldState, err := terminal.MakeRaw(0)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpIO, err := os.OpenFile("/dev/tty", os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	console := terminal.NewTerminal(tmpIO, "")
+	defer terminal.Restore(0, oldState)
+	keys, err = console.ReadPassword("Enter the WIF private keys separated by spaces: ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(keys), nil
+}
+
 func readPassword(prompt string, passLen int) (string, error) {
 	password := os.Getenv("WALLET_PASSWORD")
 	if password != "" {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// parseLockTime parses a block height or a time in RFC3339 into an nLockTime
+func parseLockTime(s string) (uint32, error) {
+	if height, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(height), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("lock time must be a block height or a time in RFC3339")
+	}
+	return uint32(t.Unix()), nil
+}
+
+// parseTimelock returns the timelock until a block height or a time, or
+// for a number of blocks or a period like 720h
+func parseTimelock(until, period string) (wallet.Timelock, error) {
+	switch {
+	case until != "" && period != "":
+		return wallet.Timelock{}, fmt.Errorf("--until and --for are exclusive")
+	case until != "":
+		if height, err := strconv.ParseUint(until, 10, 32); err == nil {
+			return wallet.LockUntilHeight(uint32(height))
+		}
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return wallet.Timelock{}, fmt.Errorf("--until must be a block height or a time in RFC3339")
+		}
+		return wallet.LockUntilTime(t)
+	case period != "":
+		if blocks, err := strconv.ParseUint(period, 10, 32); err == nil {
+			return wallet.LockForBlocks(uint32(blocks))
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil {
+			return wallet.Timelock{}, fmt.Errorf("--for must be a number of blocks or a period like 720h")
+		}
+		return wallet.LockForDuration(d)
+	default:
+		return wallet.Timelock{}, fmt.Errorf("set the timelock by --until or --for")
+	}
+}
+
+func newTimelockCmd() *cobra.Command {
+	var fee feeFlags
+	var until, period string
+	timelockCmd := &cobra.Command{
+		Use:   "timelock [amount]",
+		Short: "lock coins until a time",
+		Long: `generate a timelock address of the wallet whose coins are spent only after
+the timelock expires, and send the amount to it if it is given. --until locks
+the coins until a block height or a time, and --for locks them for a number of
+blocks or a period after they are confirmed.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			lock, err := parseTimelock(until, period)
+			returnIfErr(err)
+
+			addr, err := coinAccount.NewTimelockAddr(lock)
+			returnIfErr(err)
+			fmt.Printf("Timelock address:  %s (%s)\n", addr, lock)
+			if len(args) < 1 {
+				return
+			}
+
+			amount, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				returnIfErr(fmt.Errorf("invalid amount to send"))
+			}
+
+			err = coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.Send([]*tx.Send{{Addr: addr, Amount: amount}}, nil, fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(timelockCmd.Flags())
+	timelockCmd.Flags().StringVar(&until, "until", "", "lock until a block height or a time in RFC3339")
+	timelockCmd.Flags().StringVar(&period, "for", "", "lock for a number of blocks or a period like 720h after the confirmation")
+	return timelockCmd
+}
+
+func newTimelocksCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "timelocks",
+		Short: "list the timelock addresses",
+		Long:  `list the timelock addresses of the wallet with their timelocks and coins`,
+		Run: func(cmd *cobra.Command, args []string) {
+			addrs, err := coinAccount.TimelockAddresses()
+			returnIfErr(err)
+			for _, a := range addrs {
+				utxos, err := coinAccount.TimelockUTXOs(a.Address)
+				returnIfErr(err)
+				var amount uint64
+				for _, u := range utxos {
+					amount += u.Value
+				}
+				fmt.Printf("%s %d (%s)\n", a.Address, amount, a.Lock)
+			}
+		},
+	}
+}
+
+func newRedeemCmd() *cobra.Command {
+	var fee feeFlags
+	redeemCmd := &cobra.Command{
+		Use:   "redeem [timelock address]",
+		Short: "spend the coins of an expired timelock",
+		Long:  `spend all the coins of a timelock address whose timelock is expired to a new address of the wallet`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Help()
+				return
+			}
+
+			err := coinAccount.Discover()
+			returnIfErr(err)
+
+			txId, rawTx, err := coinAccount.RedeemTimelock(args[0], fee.fee())
+			returnIfErr(err)
+			fmt.Printf(`{"txId": "%s", "rawTx": "%s"}`, txId, rawTx)
+		},
+	}
+	fee.addFlags(redeemCmd.Flags())
+	return redeemCmd
+}
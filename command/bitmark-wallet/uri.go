@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+
+	"github.com/bitmark-inc/bitmark-wallet"
+)
+
+// parseSendURI parses the BIP21 URI which send takes instead of an address,
+// and checks that it is for the coin of the command
+func parseSendURI(uri string) (*wallet.PaymentURI, error) {
+	p, err := wallet.ParsePaymentURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if p.CoinType != coinAccount.CoinType {
+		return nil, fmt.Errorf("payment URI is for %s, not %s", p.CoinType, coinAccount.CoinType)
+	}
+	return p, nil
+}
+
+// isPaymentURI tells a payment URI from an address, which has no colon
+func isPaymentURI(s string) bool {
+	return strings.Contains(s, ":")
+}
+
+// warnUnusedAddresses warns once more addresses are handed out without
+// being used than the gap limit
+func warnUnusedAddresses() {
+	index, err := coinAccount.ChainIndex(false)
+	returnIfErr(err)
+	gap, err := coinAccount.GapLimit()
+	returnIfErr(err)
+	if index.Unused() > gap {
+		fmt.Printf("Warning: %d addresses are unused, more than the gap limit %d\n",
+			index.Unused(), gap)
+	}
+}
+
+func newReceiveCmd() *cobra.Command {
+	var label, message string
+	receiveCmd := &cobra.Command{
+		Use:   "receive [amount]",
+		Short: "request a payment to a new address of the wallet",
+		Long: `request a payment to a new address of the wallet. It prints the BIP21 URI of
+the address with the amount in satoshis and the label, if any, and the QR code
+of the URI. The address is labelled by the label.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var amount uint64
+			if len(args) > 0 {
+				var err error
+				amount, err = strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					returnIfErr(fmt.Errorf("invalid amount to receive"))
+				}
+			}
+
+			p, err := coinAccount.ReceiveURI(amount, label, message)
+			returnIfErr(err)
+			qr, err := qrcode.New(p.String(), qrcode.Medium)
+			returnIfErr(err)
+
+			fmt.Println("Address: ", p.Address)
+			fmt.Println("URI: ", p.String())
+			fmt.Print(qr.ToSmallString(false))
+			warnUnusedAddresses()
+		},
+	}
+	receiveCmd.Flags().StringVar(&label, "label", "", "label of the payment URI and the address")
+	receiveCmd.Flags().StringVar(&message, "message", "", "message of the payment URI")
+	return receiveCmd
+}
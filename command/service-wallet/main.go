@@ -11,10 +11,11 @@ import (
 )
 
 func main() {
-	var seed, walletdb, apiToken string
+	var seed, xpub, walletdb, apiToken string
 	var rpcconnect, rpcuser, rpcpassword string
 
 	flag.StringVar(&seed, "seed", "", "hd wallet seed")
+	flag.StringVar(&xpub, "xpub", "", "extended public key of the account for a watch-only wallet")
 	flag.StringVar(&walletdb, "walletdb", "wallet.dat", "hd wallet db")
 	flag.StringVar(&apiToken, "api-token", "", "server api-token")
 	flag.StringVar(&rpcconnect, "rpcconnect", "http://127.0.0.1:8332", "bitcoind RPC connect")
@@ -24,22 +25,35 @@ func main() {
 
 	// log.SetLevel(log.DebugLevel)
 
-	b, err := hex.DecodeString(seed)
-	if err != nil {
-		log.WithError(err).WithField("seed", seed).Panic("invalid seed")
-	}
-	w := wallet.New(b, walletdb)
+	var coinAccount *wallet.CoinAccount
+	if xpub != "" {
+		// force to be testnet
+		a, err := wallet.NewWatchOnlyAccount(xpub, wallet.BIP44, wallet.BTC, wallet.Test(true), walletdb)
+		if err != nil {
+			log.WithError(err).WithField("xpub", xpub).Panic("invalid xpub")
+		}
+		coinAccount = a
+	} else {
+		b, err := hex.DecodeString(seed)
+		if err != nil {
+			log.WithError(err).WithField("seed", seed).Panic("invalid seed")
+		}
+		w := wallet.New(b, walletdb)
 
-	// force to be testnet
-	coinAccount, err := w.CoinAccount(wallet.BTC, wallet.Test(true), 0)
-	if err != nil {
-		panic(err)
+		// force to be testnet
+		a, err := w.CoinAccount(wallet.BTC, wallet.Test(true), 0)
+		if err != nil {
+			panic(err)
+		}
+		coinAccount = a
 	}
 
 	coinAccount.SetAgent(
 		agent.NewDaemonAgent(rpcconnect, rpcuser, rpcpassword),
 	)
 
+	// a new address is handed out only after the current one is used
+	var addr string
 	for {
 		time.Sleep(2 * time.Second)
 		if err := coinAccount.Discover(); err != nil {
@@ -48,10 +62,22 @@ func main() {
 
 		coinAccount.GetBalance()
 
-		addr, err := coinAccount.NewExternalAddr()
+		if addr != "" {
+			used, err := coinAccount.AddressUsed(addr)
+			if err != nil {
+				log.WithError(err).Error("address used")
+				continue
+			}
+			if !used {
+				continue
+			}
+		}
+		newAddr, err := coinAccount.NewExternalAddr()
 		if err != nil {
 			log.WithError(err).Error("new external address")
+			continue
 		}
+		addr = newAddr
 		log.WithField("address", addr).Info("new external address")
 	}
 }
@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrNoOutputToSpend = fmt.Errorf("transaction has no output to the account")
+	ErrFeeRateReached  = fmt.Errorf("transaction already pays the fee rate")
+)
+
+// CPFP accelerates a transaction in the mempool, either received or sent by
+// the account, by a child transaction which spends its outputs to the
+// account. The fee per kB is the target of the child with the parent and
+// its unconfirmed ancestors as a package, so the child pays what they lack
+// on top of its own size.
+// More coins are spent if the outputs of the parent are not enough for the
+// fee.
+func (c CoinAccount) CPFP(parentTxId string, feePerKB uint64) (string, string, error) {
+	if c.IsWatchOnly() {
+		return "", "", ErrWatchOnly
+	}
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	feePerKB = c.feeRate(feePerKB)
+
+	entry, err := c.agent.GetMempoolEntry(parentTxId)
+	if err != nil {
+		return "", "", err
+	}
+	if entry.AncestorFee*1000 >= entry.AncestorVSize*feePerKB {
+		return "", "", ErrFeeRateReached
+	}
+
+	parentHash, err := chainhash.NewHashFromStr(parentTxId)
+	if err != nil {
+		return "", "", err
+	}
+	parent, err := c.getTransaction(parentHash[:])
+	if err != nil {
+		return "", "", err
+	}
+
+	paths, err := c.scriptKeyPaths()
+	if err != nil {
+		return "", "", err
+	}
+	locked, err := c.lockedOutPoints()
+	if err != nil {
+		return "", "", err
+	}
+	spent, err := c.pendingSpent()
+	if err != nil {
+		return "", "", err
+	}
+	// the locked outputs and the ones which pending transactions spend are
+	// left out as spendableUTXOs does
+	utxos := make(tx.UTXOs, 0)
+	owned := false
+	for i, txOut := range parent.TxOut {
+		op := *wire.NewOutPoint(parentHash, uint32(i))
+		u, err := c.ownedUTXO(paths, op, txOut)
+		if err != nil {
+			return "", "", err
+		}
+		if u == nil {
+			continue
+		}
+		owned = true
+		if locked[op] || spent[op] {
+			continue
+		}
+		utxos = append(utxos, u)
+	}
+	if len(utxos) == 0 {
+		if !owned {
+			return "", "", ErrNoOutputToSpend
+		}
+		for op := range locked {
+			if op.Hash == *parentHash {
+				return "", "", ErrLockedUTXO
+			}
+		}
+		return "", "", ErrNothingToSpend
+	}
+	if err := c.reserveAll(utxos); err != nil {
+		return "", "", err
+	}
+
+	spendable, err := c.spendableUTXOs()
+	if err != nil {
+		return "", "", err
+	}
+	candidates := make(tx.UTXOs, 0, len(spendable))
+	for _, u := range spendable {
+		h, err := chainhash.NewHash(u.TxHash)
+		if err != nil {
+			return "", "", err
+		}
+		if *h != *parentHash {
+			candidates = append(candidates, u)
+		}
+	}
+
+	change, err := c.newChangeAddress()
+	if err != nil {
+		return "", "", err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(change, paid) }()
+
+	child := wire.NewMsgTx(wire.TxVersion)
+	utxos, err = c.fundTx(child, utxos, candidates, change.script, feePerKB, func(size int) uint64 {
+		return feeForSize(int(entry.AncestorVSize)+size, feePerKB) - entry.AncestorFee
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := c.signTx(utxos, child); err != nil {
+		return "", "", err
+	}
+	txId, rawTx, err := c.Broadcast(child)
+	if err != nil {
+		return "", "", err
+	}
+	paid = child
+	return txId, rawTx, nil
+}
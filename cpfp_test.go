@@ -0,0 +1,182 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// addParentTx adds a transaction in the mempool of the fake agent which
+// pays the values to the scripts. The entry without the ancestors is its
+// own package.
+func addParentTx(t *testing.T, a *fakeAgent, entry *agent.MempoolEntry, scripts [][]byte, values ...int64) *wire.MsgTx {
+	if entry.AncestorVSize == 0 {
+		entry.AncestorVSize = entry.VSize
+		entry.AncestorFee = entry.Fee
+	}
+	parent := wire.NewMsgTx(wire.TxVersion)
+	parent.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	for i, v := range values {
+		parent.AddTxOut(wire.NewTxOut(v, scripts[i]))
+	}
+	txId := parent.TxHash().String()
+	a.txs[txId] = serializeTx(t, parent)
+	if a.mempool == nil {
+		a.mempool = make(map[string]*agent.MempoolEntry)
+	}
+	a.mempool[txId] = entry
+	return parent
+}
+
+func TestCPFP(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+	foreign, err := tx.DefaultP2PKScript("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt")
+	assert.NoError(t, err)
+
+	entry := &agent.MempoolEntry{VSize: 226, Fee: 226}
+	parent := addParentTx(t, a, entry, [][]byte{foreign, script}, 80000, 20000)
+	parentHash := parent.TxHash()
+
+	txId, rawTx, err := account.CPFP(parentHash.String(), 10000)
+	assert.NoError(t, err)
+	child := deserializeTx(t, rawTx)
+	assert.Equal(t, child.TxHash().String(), txId)
+
+	assert.Len(t, child.TxIn, 1)
+	assert.Equal(t, *wire.NewOutPoint(&parentHash, 1), child.TxIn[0].PreviousOutPoint)
+	assert.Len(t, child.TxOut, 1)
+
+	utxos := tx.UTXOs{{TxHash: parentHash[:], TxIndex: 1, Value: 20000, Script: script}}
+	childSize := uint64(txVirtualSize(child))
+	childFee := uint64(txFee(child, utxos))
+	assert.Equal(t, (entry.VSize+childSize)*10-entry.Fee, childFee)
+	verifyTx(t, child, utxos)
+}
+
+func TestCPFPUnconfirmedAncestors(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+
+	// the parent pays the fee rate but its own parent does not
+	entry := &agent.MempoolEntry{VSize: 200, Fee: 2000, AncestorVSize: 500, AncestorFee: 2300}
+	parent := addParentTx(t, a, entry, [][]byte{script}, 50000)
+	parentHash := parent.TxHash()
+
+	_, rawTx, err := account.CPFP(parentHash.String(), 10000)
+	assert.NoError(t, err)
+	child := deserializeTx(t, rawTx)
+
+	utxos := tx.UTXOs{{TxHash: parentHash[:], TxIndex: 0, Value: 50000, Script: script}}
+	childSize := uint64(txVirtualSize(child))
+	childFee := uint64(txFee(child, utxos))
+	assert.Equal(t, (entry.AncestorVSize+childSize)*10-entry.AncestorFee, childFee)
+	verifyTx(t, child, utxos)
+
+	// the whole package pays the fee rate
+	entry = &agent.MempoolEntry{VSize: 200, Fee: 2000, AncestorVSize: 500, AncestorFee: 5000}
+	parent = addParentTx(t, a, entry, [][]byte{script}, 50000)
+	_, _, err = account.CPFP(parent.TxHash().String(), 10000)
+	assert.Equal(t, ErrFeeRateReached, err)
+}
+
+func TestCPFPAddInputs(t *testing.T) {
+	account, a, coins := newTestAccount(t, 50000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+
+	// the output of the parent does not cover the fee of the package
+	entry := &agent.MempoolEntry{VSize: 1000, Fee: 1000}
+	parent := addParentTx(t, a, entry, [][]byte{script}, 3000)
+	parentHash := parent.TxHash()
+
+	_, rawTx, err := account.CPFP(parentHash.String(), 5000)
+	assert.NoError(t, err)
+	child := deserializeTx(t, rawTx)
+
+	assert.Len(t, child.TxIn, 2)
+	assert.Equal(t, *wire.NewOutPoint(&parentHash, 0), child.TxIn[0].PreviousOutPoint)
+	utxos := tx.UTXOs{{TxHash: parentHash[:], TxIndex: 0, Value: 3000, Script: script}, coins[0]}
+	childSize := uint64(txVirtualSize(child))
+	assert.True(t, uint64(txFee(child, utxos))+entry.Fee >= (entry.VSize+childSize)*5)
+	verifyTx(t, child, utxos)
+}
+
+func TestCPFPErrors(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+	foreign, err := tx.DefaultP2PKScript("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt")
+	assert.NoError(t, err)
+
+	parent := addParentTx(t, a, &agent.MempoolEntry{VSize: 200, Fee: 2000}, [][]byte{script}, 20000)
+	_, _, err = account.CPFP(parent.TxHash().String(), 10000)
+	assert.Equal(t, ErrFeeRateReached, err)
+
+	parent = addParentTx(t, a, &agent.MempoolEntry{VSize: 200, Fee: 200}, [][]byte{foreign}, 20000)
+	_, _, err = account.CPFP(parent.TxHash().String(), 10000)
+	assert.Equal(t, ErrNoOutputToSpend, err)
+}
+
+func TestCPFPUnspendableOutputs(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+
+	entry := &agent.MempoolEntry{VSize: 300, Fee: 300}
+	parent := addParentTx(t, a, entry, [][]byte{script, script}, 20000, 30000)
+	parentHash := parent.TxHash()
+	// the outputs of the parent are listed by the sync
+	assert.NoError(t, account.store.SetUTXO(addr, tx.UTXOs{
+		{TxHash: parentHash[:], TxIndex: 0, Value: 20000},
+		{TxHash: parentHash[:], TxIndex: 1, Value: 30000},
+	}))
+
+	// the locked output is not spent by the child
+	assert.NoError(t, account.LockUnspent(*wire.NewOutPoint(&parentHash, 1)))
+	_, rawTx, err := account.CPFP(parentHash.String(), 10000)
+	assert.NoError(t, err)
+	child := deserializeTx(t, rawTx)
+	assert.Len(t, child.TxIn, 1)
+	assert.Equal(t, *wire.NewOutPoint(&parentHash, 0), child.TxIn[0].PreviousOutPoint)
+
+	// the other output is spent by the pending child
+	_, _, err = account.CPFP(parentHash.String(), 20000)
+	assert.Equal(t, ErrLockedUTXO, err)
+	assert.NoError(t, account.UnlockUnspent(*wire.NewOutPoint(&parentHash, 1)))
+	_, rawTx, err = account.CPFP(parentHash.String(), 20000)
+	assert.NoError(t, err)
+	child = deserializeTx(t, rawTx)
+	assert.Len(t, child.TxIn, 1)
+	assert.Equal(t, *wire.NewOutPoint(&parentHash, 1), child.TxIn[0].PreviousOutPoint)
+
+	_, _, err = account.CPFP(parentHash.String(), 30000)
+	assert.Equal(t, ErrNothingToSpend, err)
+}
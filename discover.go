@@ -0,0 +1,286 @@
+package wallet
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrInvalidGapLimit = fmt.Errorf("gap limit must be positive")
+)
+
+// DiscoverProgress is reported by Discover for each address it scans
+type DiscoverProgress struct {
+	Change  bool
+	Index   uint32
+	Address string
+	// Used is true if the address has a transaction
+	Used bool
+}
+
+// SyncState is when the account is last synced by Discover and the height
+// of the best block then
+type SyncState struct {
+	Time   time.Time
+	Height uint64
+}
+
+// GapLimit returns the number of the unused addresses after the last used
+// one which Discover scans on each chain. It is AddressGap unless the
+// account sets another one.
+func (c CoinAccount) GapLimit() (uint32, error) {
+	gap, err := c.store.GetGapLimit()
+	if err != nil {
+		return 0, err
+	}
+	if gap == 0 {
+		return AddressGap, nil
+	}
+	return gap, nil
+}
+
+// SetGapLimit sets the gap limit of the account. A wallet which hands out
+// many addresses before they are paid needs a larger one so that restoring
+// its seed still finds all of its coins.
+func (c CoinAccount) SetGapLimit(gap uint32) error {
+	if gap == 0 {
+		return ErrInvalidGapLimit
+	}
+	return c.store.SetGapLimit(gap)
+}
+
+// SetDiscoverProgress sets the function which Discover calls for each
+// address it scans
+func (c *CoinAccount) SetDiscoverProgress(f func(DiscoverProgress)) {
+	c.progress = f
+}
+
+// LastSync returns when the account is last synced and the block height
+// then. It is zero before the first Discover.
+func (c CoinAccount) LastSync() (SyncState, error) {
+	return c.store.GetSyncState()
+}
+
+// Discover syncs the coins and the transactions of the account. Each chain
+// is scanned until the gap limit after its last used address, and at least
+// up to the last address handed out. It resumes from the addresses which
+// the last discovery has scanned, since the agent keeps watching them.
+func (c CoinAccount) Discover() error {
+	return c.discover(false)
+}
+
+// Rescan syncs the account as Discover does, but scans all the addresses
+// from the first one again
+func (c CoinAccount) Rescan() error {
+	return c.discover(true)
+}
+
+// chainScan is the progress of the discovery of a chain
+type chainScan struct {
+	change bool
+	// next is the index of the next address to scan
+	next uint32
+	// used is the number of the addresses up to the last used one
+	used uint32
+	// known is the number of the addresses which the account watches
+	known uint32
+	// indexes are the indexes of the addresses of the chain listed so far
+	indexes map[string]uint32
+	// rescan is true if the agent rescans the addresses before they are
+	// watched, up to the rescanned index
+	rescan    bool
+	rescanned uint32
+}
+
+// end returns the index which the chain is scanned up to
+func (s *chainScan) end(gapLimit uint32) uint32 {
+	if s.used+gapLimit > s.known {
+		return s.used + gapLimit
+	}
+	return s.known
+}
+
+// scanChain watches the addresses of a chain until the gap limit after the
+// last used one, or the last rescanned one, and returns them
+func (c CoinAccount) scanChain(s *chainScan, gapLimit uint32) ([]string, error) {
+	addresses := make([]string, 0)
+	for s.next < s.end(gapLimit) && (!s.rescan || s.next < s.rescanned) {
+		addr, err := c.Address(s.next, s.change)
+		if err != nil {
+			return nil, err
+		}
+		err = c.agent.WatchAddress(addr)
+		switch err {
+		case agent.ErrNoTxForAddr:
+		case nil:
+			if !s.change {
+				log.WithField("address", addr).WithField("index", s.next).Debug("discover external transactions")
+			}
+			s.used = s.next + 1
+		default:
+			return nil, err
+		}
+		if c.progress != nil {
+			c.progress(DiscoverProgress{Change: s.change, Index: s.next, Address: addr, Used: err == nil})
+		}
+
+		s.indexes[addr] = s.next
+		addresses = append(addresses, addr)
+		s.next += 1
+	}
+	log.WithField("external", !s.change).WithField("used", s.used).Debug("discovered used index")
+	return addresses, nil
+}
+
+// markUsed raises the used index of a chain for the addresses which have
+// coins. It returns true if the index is raised.
+func (s *chainScan) markUsed(addrUTXOs map[string]tx.UTXOs) bool {
+	raised := false
+	for addr, i := range s.indexes {
+		if len(addrUTXOs[addr]) > 0 && i >= s.used {
+			s.used = i + 1
+			raised = true
+		}
+	}
+	return raised
+}
+
+// rescanChains rescans the addresses of the chains up to the gap limit
+// after their last used ones, which are not rescanned yet, at once
+func (c CoinAccount) rescanChains(scans []*chainScan, gapLimit uint32) error {
+	addrs := make([]string, 0)
+	for _, s := range scans {
+		if !s.rescan {
+			continue
+		}
+		end := s.end(gapLimit)
+		for ; s.rescanned < end; s.rescanned++ {
+			addr, err := c.Address(s.rescanned, s.change)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+	log.WithField("addresses", len(addrs)).Info("rescan addresses")
+	return c.agent.RescanAddresses(addrs)
+}
+
+func (c CoinAccount) discover(full bool) error {
+	gapLimit, err := c.GapLimit()
+	if err != nil {
+		return err
+	}
+
+	// the agent does not know the history of the addresses which are
+	// used before they are watched, as those of a restored seed. Each
+	// window of the addresses of an account which has never been synced
+	// is rescanned before it is watched.
+	state, err := c.LastSync()
+	if err != nil {
+		return err
+	}
+	rescan := state.Time.IsZero()
+
+	// m / 44' / coin' / account' / external
+	addresses := make([]string, 0)
+	scans := make([]*chainScan, 0, 2)
+	for _, change := range []bool{false, true} {
+		index, err := c.store.GetChainIndex(change)
+		if err != nil {
+			return err
+		}
+		s := &chainScan{change: change, known: index.Known(), indexes: make(map[string]uint32),
+			rescan: rescan, rescanned: c.rescanned}
+		if !full {
+			// the addresses scanned before are still watched, and
+			// their coins are listed without scanning them again
+			s.used = index.Used
+			for ; s.next < index.Scanned; s.next++ {
+				addr, err := c.Address(s.next, change)
+				if err != nil {
+					return err
+				}
+				s.indexes[addr] = s.next
+				addresses = append(addresses, addr)
+			}
+		}
+		scans = append(scans, s)
+	}
+
+	var addrUTXOs map[string]tx.UTXOs
+	for {
+		if err := c.rescanChains(scans, gapLimit); err != nil {
+			return err
+		}
+		for _, s := range scans {
+			scanned, err := c.scanChain(s, gapLimit)
+			if err != nil {
+				return err
+			}
+			addresses = append(addresses, scanned...)
+		}
+
+		addrUTXOs, err = c.agent.ListAllUnspent()
+		if err != nil {
+			return err
+		}
+
+		// a coin sent to an address after the last used one moves the
+		// gap, and the addresses after it are scanned again, as well as
+		// the addresses after the rescanned ones
+		extended := false
+		for _, s := range scans {
+			s.markUsed(addrUTXOs)
+			if s.next < s.end(gapLimit) {
+				extended = true
+			}
+		}
+		if !extended {
+			break
+		}
+	}
+
+	coins, err := c.knownCoins()
+	if err != nil {
+		return err
+	}
+	if err := c.syncHistory(addresses, coins); err != nil {
+		return err
+	}
+	if err := c.reconcilePending(addrUTXOs); err != nil {
+		return err
+	}
+
+	for _, addr := range addresses {
+		// addrUTXOs[addr] might
+		// 1. contain utxos, and then the entry will be updated
+		// 2. NOT exist, and then the entry will be deleted
+		err = c.store.SetUTXO(addr, addrUTXOs[addr])
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range scans {
+		if err := c.store.SetUsedIndex(s.change, s.used); err != nil {
+			return err
+		}
+		if err := c.store.SetScannedIndex(s.change, s.next); err != nil {
+			return err
+		}
+	}
+
+	height, err := c.agent.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	return c.store.SetSyncState(SyncState{Time: time.Now(), Height: height})
+}
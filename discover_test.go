@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestResumeDiscover(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	addr2, err := account.Address(2, false)
+	assert.NoError(t, err)
+	a := &fakeAgent{unspent: map[string]tx.UTXOs{addr2: {testUTXO(1, 20000, 3, "")}}, height: 2500000}
+	account.SetAgent(a)
+	progress := make([]DiscoverProgress, 0)
+	account.SetDiscoverProgress(func(p DiscoverProgress) {
+		progress = append(progress, p)
+	})
+
+	sync, err := account.LastSync()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), sync.Height)
+
+	// the gap limit after the used address on the external chain, and
+	// after the first address on the change chain
+	assert.NoError(t, account.Discover())
+	assert.Len(t, a.watched, 13)
+	assert.Len(t, progress, 13)
+	assert.Equal(t, DiscoverProgress{Index: 2, Address: addr2, Used: true}, progress[2])
+	changes := 0
+	for _, p := range progress {
+		if p.Change {
+			changes++
+		}
+	}
+	assert.Equal(t, 5, changes)
+	index, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ChainIndex{Used: 3, Scanned: 8}, index)
+	sync, err = account.LastSync()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500000), sync.Height)
+	assert.WithinDuration(t, time.Now(), sync.Time, time.Minute)
+
+	// the scanned addresses are not scanned again
+	a.watched = nil
+	a.height = 2500001
+	assert.NoError(t, account.Discover())
+	assert.Empty(t, a.watched)
+	sync, err = account.LastSync()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500001), sync.Height)
+
+	// a coin of a scanned address after the used one moves the gap
+	addr6, err := account.Address(6, false)
+	assert.NoError(t, err)
+	a.unspent[addr6] = tx.UTXOs{testUTXO(2, 30000, 1, "")}
+	assert.NoError(t, account.Discover())
+	assert.Len(t, a.watched, 4)
+	index, err = account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ChainIndex{Used: 7, Scanned: 12}, index)
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50000), balance)
+
+	// a full rescan scans all the addresses again
+	a.watched = nil
+	assert.NoError(t, account.Rescan())
+	assert.Len(t, a.watched, 17)
+	balance, err = account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50000), balance)
+}
+
+func TestGapLimit(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+
+	gap, err := account.GapLimit()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(AddressGap), gap)
+	assert.Equal(t, ErrInvalidGapLimit, account.SetGapLimit(0))
+	assert.NoError(t, account.SetGapLimit(20))
+	account.Close()
+
+	// the gap limit is kept for the account
+	account, err = w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+	gap, err = account.GapLimit()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(20), gap)
+
+	addr, err := account.Address(15, false)
+	assert.NoError(t, err)
+	a := &fakeAgent{unspent: map[string]tx.UTXOs{addr: {testUTXO(1, 20000, 3, "")}}}
+	account.SetAgent(a)
+	assert.NoError(t, account.Discover())
+	assert.Len(t, a.watched, 56)
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20000), balance)
+}
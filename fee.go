@@ -0,0 +1,50 @@
+package wallet
+
+import (
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultConfTarget is the number of blocks which the fee of a transaction
+// is estimated for when the account is not given one
+const DefaultConfTarget = 6
+
+// SatPerVByte converts a fee rate in satoshis per vbyte to the fee per kB
+func SatPerVByte(rate float64) uint64 {
+	return uint64(math.Round(rate * 1000))
+}
+
+// feeForSize returns the fee of a virtual size at the fee per kB
+func feeForSize(size int, feePerKB uint64) uint64 {
+	return uint64(size) * feePerKB / 1000
+}
+
+// SetConfTarget sets the number of blocks which the transactions of the
+// account are expected to be confirmed within when no fee is given
+func (c *CoinAccount) SetConfTarget(blocks uint32) {
+	c.confTarget = blocks
+}
+
+// EstimateFee returns the fee per kB for a transaction to be confirmed
+// within the number of blocks. It is estimated by the agent, and the static
+// fee of the coin is the last resort if the agent has no estimate.
+func (c CoinAccount) EstimateFee(confTarget uint32) uint64 {
+	if c.agent != nil {
+		fee, err := c.agent.EstimateFee(confTarget)
+		if err == nil {
+			return fee
+		}
+		log.WithError(err).WithField("confTarget", confTarget).Warn("fail to estimate fee, use the static fee")
+	}
+	return c.feePerKB
+}
+
+// feeRate returns the fee per kB of a transaction. The given fee wins, and
+// zero means to estimate it for the confirmation target of the account.
+func (c CoinAccount) feeRate(fee uint64) uint64 {
+	if fee != 0 {
+		return fee
+	}
+	return c.EstimateFee(c.confTarget)
+}
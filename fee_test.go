@@ -0,0 +1,46 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestSatPerVByte(t *testing.T) {
+	assert.Equal(t, uint64(1000), SatPerVByte(1))
+	assert.Equal(t, uint64(1500), SatPerVByte(1.5))
+	assert.Equal(t, uint64(123), SatPerVByte(0.123))
+}
+
+func TestEstimateFee(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	a.fees = map[uint32]uint64{2: 25000, DefaultConfTarget: 4000}
+	assert.Equal(t, uint64(25000), account.EstimateFee(2))
+	assert.Equal(t, uint64(4000), account.feeRate(0))
+	assert.Equal(t, uint64(7000), account.feeRate(7000))
+
+	account.SetConfTarget(2)
+	assert.Equal(t, uint64(25000), account.feeRate(0))
+
+	// the static fee is the last resort
+	account.SetConfTarget(144)
+	assert.Equal(t, CoinFee[BTC], account.feeRate(0))
+}
+
+func TestSendFeeEstimate(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+	a.fees = map[uint32]uint64{2: 12345}
+	account.SetConfTarget(2)
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	_, rawTx, err := account.Send(sends, nil, 0)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Equal(t, int64(feeForSize(txVirtualSize(sent), 12345)), txFee(sent, utxos))
+	verifyTx(t, sent, utxos)
+}
@@ -0,0 +1,410 @@
+package wallet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+)
+
+// TxDirection tells whether a transaction pays the account or spends its
+// coins
+type TxDirection uint8
+
+const (
+	TxIncoming TxDirection = iota + 1
+	TxOutgoing
+)
+
+func (d TxDirection) String() string {
+	if d == TxOutgoing {
+		return "out"
+	}
+	return "in"
+}
+
+// TxState is the confirmation state of a transaction
+type TxState uint8
+
+const (
+	TxPending TxState = iota
+	TxConfirmed
+	// TxConflicted is a transaction which conflicts with a confirmed one,
+	// for example a replaced one
+	TxConflicted
+)
+
+func (s TxState) String() string {
+	switch s {
+	case TxConfirmed:
+		return "confirmed"
+	case TxConflicted:
+		return "conflicted"
+	default:
+		return "pending"
+	}
+}
+
+// TxAmount is the amount which a transaction pays an address
+type TxAmount struct {
+	Address string
+	Amount  uint64
+}
+
+// Transaction is a record of the history of the account. The amounts of an
+// incoming transaction are the payments to the addresses of the account,
+// and those of an outgoing one are the payments to the others, so the
+// change is left out.
+type Transaction struct {
+	TxId      string
+	Direction TxDirection
+	Amounts   []TxAmount
+	// Fee is only known for the outgoing transactions
+	Fee           uint64
+	State         TxState
+	Confirmations uint64
+	Height        uint64
+	// BlockTime is zero until the transaction is confirmed
+	BlockTime time.Time
+	// Time is when the transaction is broadcast or first seen
+	Time time.Time
+}
+
+// Total returns the sum of the amounts
+func (t Transaction) Total() uint64 {
+	var total uint64
+	for _, a := range t.Amounts {
+		total += a.Amount
+	}
+	return total
+}
+
+// pays returns true if the transaction pays the address
+func (t Transaction) pays(addr string) bool {
+	for _, a := range t.Amounts {
+		if a.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// addAmount adds an amount to an address
+func (t *Transaction) addAmount(addr string, amount uint64) {
+	for i, a := range t.Amounts {
+		if a.Address == addr {
+			t.Amounts[i].Amount += amount
+			return
+		}
+	}
+	t.Amounts = append(t.Amounts, TxAmount{Address: addr, Amount: amount})
+}
+
+// HistoryFilter selects the transactions of the history. The zero value
+// selects all of them.
+type HistoryFilter struct {
+	// Direction is zero for both the directions
+	Direction TxDirection
+	// Address selects the transactions which pay the address
+	Address string
+	// Pending selects the unconfirmed transactions
+	Pending bool
+	// Offset skips the number of the newest transactions, and Limit
+	// bounds the number of the returned ones if it is not zero
+	Offset int
+	Limit  int
+}
+
+// match returns true if the filter selects the transaction
+func (f HistoryFilter) match(t *Transaction) bool {
+	if f.Direction != 0 && t.Direction != f.Direction {
+		return false
+	}
+	if f.Address != "" && !t.pays(f.Address) {
+		return false
+	}
+	if f.Pending && t.State != TxPending {
+		return false
+	}
+	return true
+}
+
+// History returns the transactions of the account selected by the filter,
+// the newest first. The history is recorded by Discover and by the
+// transactions which the account broadcasts.
+func (c CoinAccount) History(filter HistoryFilter) ([]*Transaction, error) {
+	txs, err := c.store.GetTransactions()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		if !txs[i].Time.Equal(txs[j].Time) {
+			return txs[i].Time.After(txs[j].Time)
+		}
+		return txs[i].TxId < txs[j].TxId
+	})
+
+	selected := make([]*Transaction, 0)
+	skipped := 0
+	for _, t := range txs {
+		if !filter.match(t) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		if filter.Limit > 0 && len(selected) == filter.Limit {
+			break
+		}
+		selected = append(selected, t)
+	}
+	return selected, nil
+}
+
+// GetTransaction returns a transaction of the history, or nil if it is not
+// recorded
+func (c CoinAccount) GetTransaction(txId string) (*Transaction, error) {
+	return c.store.GetTransaction(txId)
+}
+
+// ownedAddresses returns the addresses which the account has handed out,
+// including the timelock ones
+func (c CoinAccount) ownedAddresses() (map[string]bool, error) {
+	addrs := make(map[string]bool)
+	for _, change := range []bool{false, true} {
+		index, err := c.store.GetChainIndex(change)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < index.Known(); i++ {
+			addr, err := c.Address(i, change)
+			if err != nil {
+				return nil, err
+			}
+			addrs[addr] = true
+		}
+	}
+
+	timelocks, err := c.store.GetTimelockAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range timelocks {
+		addrs[a.Address] = true
+	}
+	return addrs, nil
+}
+
+// outputAddress returns the address of a vout, or an empty string if it
+// has none
+func (c CoinAccount) outputAddress(txOut *wire.TxOut) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, c.net)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
+
+// recordBroadcast records a transaction which the account broadcasts, and
+// marks the coins which it spends and creates as pending. It is outgoing if
+// it spends the coins of the account, and incoming otherwise, for example a
+// sweep of other keys.
+func (c CoinAccount) recordBroadcast(signedTx *wire.MsgTx) error {
+	owned, err := c.ownedAddresses()
+	if err != nil {
+		return err
+	}
+	coins, err := c.knownCoins()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	t := &Transaction{
+		TxId:      signedTx.TxHash().String(),
+		Direction: TxIncoming,
+		State:     TxPending,
+		Time:      time.Unix(now.Unix(), 0),
+	}
+
+	var spent uint64
+	allKnown := true
+	for _, txIn := range signedTx.TxIn {
+		coin, ok := coins[txIn.PreviousOutPoint]
+		if !ok {
+			allKnown = false
+			continue
+		}
+		t.Direction = TxOutgoing
+		spent += coin.Amount
+	}
+
+	var paid uint64
+	for _, txOut := range signedTx.TxOut {
+		paid += uint64(txOut.Value)
+		addr := c.outputAddress(txOut)
+		if addr == "" || owned[addr] != (t.Direction == TxIncoming) {
+			continue
+		}
+		t.addAmount(addr, uint64(txOut.Value))
+	}
+	if t.Direction == TxOutgoing && allKnown && spent >= paid {
+		t.Fee = spent - paid
+	}
+	if err := c.store.SetTransaction(t); err != nil {
+		return err
+	}
+	return c.addPending(signedTx, coins, owned)
+}
+
+// syncHistory records the transactions which the agent reports for the
+// addresses. The coins are the ones of the account before the sync,
+// including the pending ones, which tell the outgoing transactions that pay
+// no change back.
+func (c CoinAccount) syncHistory(addresses []string, coins map[wire.OutPoint]*TxAmount) error {
+	entries, err := c.agent.ListTransactions()
+	if err != nil {
+		return err
+	}
+	owned, err := c.ownedAddresses()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		owned[addr] = true
+	}
+
+	txIds := make([]string, 0)
+	txEntries := make(map[string][]*agent.TxEntry)
+	for _, e := range entries {
+		if _, ok := txEntries[e.TxId]; !ok {
+			txIds = append(txIds, e.TxId)
+		}
+		txEntries[e.TxId] = append(txEntries[e.TxId], e)
+	}
+
+	for _, txId := range txIds {
+		es := txEntries[txId]
+		var sent, received bool
+		for _, e := range es {
+			if e.Category == "send" {
+				sent = true
+			} else if owned[e.Address] {
+				received = true
+			}
+		}
+
+		t, err := c.store.GetTransaction(txId)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			// the agent watches the addresses of other accounts as well,
+			// so a transaction is outgoing only if it spends the coins of
+			// the account, even if it pays to the account. The ones which
+			// are found not to are not fetched again until the account
+			// has more addresses.
+			outgoing := false
+			if sent {
+				checked, foreign, err := c.store.GetForeignTransaction(txId)
+				if err != nil {
+					return err
+				}
+				if !foreign || checked != uint32(len(owned)) {
+					outgoing, err = c.spendsCoins(txId, coins, owned)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			if !outgoing && !received {
+				if sent {
+					if err := c.store.SetForeignTransaction(txId, uint32(len(owned))); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			t = &Transaction{TxId: txId, Direction: TxIncoming, Time: time.Unix(es[0].Time, 0)}
+			if outgoing {
+				t.Direction = TxOutgoing
+			}
+			for _, e := range es {
+				switch {
+				case t.Direction == TxOutgoing && e.Category == "send" && !owned[e.Address]:
+					t.addAmount(e.Address, uint64(-e.Amount))
+				case t.Direction == TxIncoming && e.Category == "receive" && owned[e.Address]:
+					t.addAmount(e.Address, uint64(e.Amount))
+				}
+			}
+		}
+
+		for _, e := range es {
+			if t.Direction == TxOutgoing && t.Fee == 0 && e.Fee > 0 {
+				t.Fee = e.Fee
+			}
+		}
+
+		// all the entries of a transaction share its confirmations
+		e := es[0]
+		switch {
+		case e.Confirmations < 0:
+			t.State = TxConflicted
+			t.Confirmations = 0
+		case e.Confirmations == 0:
+			t.State = TxPending
+			t.Confirmations = 0
+		default:
+			t.State = TxConfirmed
+			t.Confirmations = uint64(e.Confirmations)
+			t.Height = e.BlockHeight
+			t.BlockTime = time.Unix(e.BlockTime, 0)
+		}
+		if err := c.store.SetTransaction(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spendsCoins returns true if a transaction spends any of the coins, or
+// any output to the owned addresses. The previous outputs are looked up for
+// the coins which the account has not recorded, as those spent before the
+// account is synced or by another wallet of the seed.
+func (c CoinAccount) spendsCoins(txId string, coins map[wire.OutPoint]*TxAmount, owned map[string]bool) (bool, error) {
+	h, err := chainhash.NewHashFromStr(txId)
+	if err != nil {
+		return false, err
+	}
+	t, err := c.getTransaction(h[:])
+	if err != nil {
+		return false, err
+	}
+	for _, txIn := range t.TxIn {
+		if _, ok := coins[txIn.PreviousOutPoint]; ok {
+			return true, nil
+		}
+	}
+
+	for _, txIn := range t.TxIn {
+		outPoint := txIn.PreviousOutPoint
+		// the agent only knows the transactions of the watched
+		// addresses, so the others do not pay the account
+		prevTx, err := c.getTransaction(outPoint.Hash[:])
+		if err != nil {
+			log.WithField("outpoint", outPoint).WithError(err).Debug("no previous transaction")
+			continue
+		}
+		if int(outPoint.Index) < len(prevTx.TxOut) && owned[c.outputAddress(prevTx.TxOut[outPoint.Index])] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
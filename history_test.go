@@ -0,0 +1,210 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestSendHistory(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+
+	record, err := account.GetTransaction(txId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxOutgoing, record.Direction)
+	assert.Equal(t, TxPending, record.State)
+	// the change is left out of the amounts
+	assert.Equal(t, []TxAmount{{Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, record.Amounts)
+	assert.Equal(t, uint64(txFee(sent, utxos)), record.Fee)
+	assert.True(t, record.BlockTime.IsZero())
+	assert.WithinDuration(t, time.Now(), record.Time, 2*time.Second)
+}
+
+func TestDiscoverHistory(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	changeAddr, err := account.NewChangeAddr()
+	assert.NoError(t, err)
+
+	// a transaction which spends a coin of the account without a change
+	spending := wire.NewMsgTx(wire.TxVersion)
+	spending.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: utxoOutPoint(utxos[1]).Hash, Index: 1}, nil, nil))
+	spending.AddTxOut(wire.NewTxOut(29000, nil))
+	spendingId := spending.TxHash().String()
+	a.txs[spendingId] = serializeTx(t, spending)
+
+	// a transaction which spends a coin of the account with a change
+	foreign, err := tx.DefaultP2PKScript("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt")
+	assert.NoError(t, err)
+	change, err := tx.DefaultP2PKScript(changeAddr)
+	assert.NoError(t, err)
+	send := wire.NewMsgTx(wire.TxVersion)
+	send.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: utxoOutPoint(utxos[1]).Hash, Index: 1}, nil, nil))
+	send.AddTxOut(wire.NewTxOut(20000, foreign))
+	send.AddTxOut(wire.NewTxOut(9700, change))
+	sendId := send.TxHash().String()
+	a.txs[sendId] = serializeTx(t, send)
+
+	receiveId := utxoOutPoint(utxos[0]).Hash.String()
+	otherId := "f91d0a8a78462bc59398f2c5d7a84fcff491c26ba54c4833478b202796c8aafd"
+	other := wire.NewMsgTx(wire.TxVersion)
+	other.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 3}, nil, nil))
+	a.txs[otherId] = serializeTx(t, other)
+
+	a.unspent = map[string]tx.UTXOs{addr: {utxos[0]}}
+	a.history = []*agent.TxEntry{
+		{TxId: receiveId, Address: addr, Category: "receive", Amount: 100000,
+			Confirmations: 10, BlockHeight: 2500000, BlockTime: 1700000000, Time: 1699999000},
+		{TxId: sendId, Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Category: "send", Amount: -20000, Fee: 300,
+			Time: 1700000100},
+		{TxId: sendId, Address: changeAddr, Category: "receive", Amount: 9700, Time: 1700000100},
+		{TxId: spendingId, Address: "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", Category: "send", Amount: -29000, Fee: 1000,
+			Confirmations: 1, BlockHeight: 2500009, BlockTime: 1700000200, Time: 1700000200},
+		// the transactions of the other accounts of the agent
+		{TxId: otherId, Address: "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", Category: "send", Amount: -1000, Fee: 100,
+			Time: 1700000300},
+		{TxId: otherId, Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Category: "receive", Amount: 1000,
+			Time: 1700000300},
+	}
+	assert.NoError(t, account.Discover())
+
+	history, err := account.History(HistoryFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, []string{spendingId, sendId, receiveId},
+		[]string{history[0].TxId, history[1].TxId, history[2].TxId})
+
+	assert.Equal(t, &Transaction{
+		TxId:          spendingId,
+		Direction:     TxOutgoing,
+		Amounts:       []TxAmount{{Address: "mnw1RtVwS5CRzbwV4rMxTiUqGec2DuK43n", Amount: 29000}},
+		Fee:           1000,
+		State:         TxConfirmed,
+		Confirmations: 1,
+		Height:        2500009,
+		BlockTime:     time.Unix(1700000200, 0),
+		Time:          time.Unix(1700000200, 0),
+	}, history[0])
+	assert.Equal(t, TxOutgoing, history[1].Direction)
+	assert.Equal(t, TxPending, history[1].State)
+	assert.Equal(t, uint64(300), history[1].Fee)
+	assert.Equal(t, []TxAmount{{Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 20000}}, history[1].Amounts)
+	assert.Equal(t, TxIncoming, history[2].Direction)
+	assert.Equal(t, []TxAmount{{Address: addr, Amount: 100000}}, history[2].Amounts)
+
+	// the state is updated by the later sync
+	a.history[1].Confirmations = -1
+	assert.NoError(t, account.Discover())
+	record, err := account.GetTransaction(sendId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxConflicted, record.State)
+
+	history, err = account.History(HistoryFilter{Direction: TxOutgoing, Offset: 1, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, sendId, history[0].TxId)
+	history, err = account.History(HistoryFilter{Address: addr})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	history, err = account.History(HistoryFilter{Pending: true})
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestDiscoverHistoryFromOtherAccount(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+
+	// another account of the agent pays to the account, so that the agent
+	// reports the transaction as both sent and received
+	payment := wire.NewMsgTx(wire.TxVersion)
+	payment.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 3}, nil, nil))
+	paymentId := payment.TxHash().String()
+	a.txs[paymentId] = serializeTx(t, payment)
+
+	a.unspent = map[string]tx.UTXOs{addr: utxos}
+	a.history = []*agent.TxEntry{
+		{TxId: paymentId, Address: addr, Category: "send", Amount: -40000, Fee: 500, Time: 1700000000},
+		{TxId: paymentId, Address: addr, Category: "receive", Amount: 40000, Time: 1700000000},
+	}
+	assert.NoError(t, account.Discover())
+
+	record, err := account.GetTransaction(paymentId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxIncoming, record.Direction)
+	assert.Equal(t, []TxAmount{{Address: addr, Amount: 40000}}, record.Amounts)
+	assert.Equal(t, uint64(0), record.Fee)
+}
+
+func TestDiscoverHistoryOfUnrecordedCoins(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	changeAddr, err := account.Address(0, true)
+	assert.NoError(t, err)
+	changeScript, err := tx.DefaultP2PKScript(changeAddr)
+	assert.NoError(t, err)
+	foreignScript, err := tx.DefaultP2PKScript("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt")
+	assert.NoError(t, err)
+
+	// the coin is spent before the account is synced, as by another wallet
+	// of the seed, so the account never records it
+	assert.NoError(t, account.store.SetUTXO(addr, nil))
+	prevHash, err := chainhash.NewHash(utxos[0].TxHash)
+	assert.NoError(t, err)
+	send := wire.NewMsgTx(wire.TxVersion)
+	send.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, 0), nil, nil))
+	send.AddTxOut(wire.NewTxOut(60000, foreignScript))
+	send.AddTxOut(wire.NewTxOut(39500, changeScript))
+	sendId := send.TxHash().String()
+	a.txs[sendId] = serializeTx(t, send)
+
+	// a transaction of another account spends no output of the account
+	foreign := wire.NewMsgTx(wire.TxVersion)
+	foreign.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 5}, nil, nil))
+	foreign.AddTxOut(wire.NewTxOut(10000, foreignScript))
+	foreignId := foreign.TxHash().String()
+	a.txs[foreignId] = serializeTx(t, foreign)
+
+	a.unspent = map[string]tx.UTXOs{changeAddr: {testUTXO(9, 39500, 1, "")}}
+	a.history = []*agent.TxEntry{
+		{TxId: sendId, Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Category: "send", Amount: -60000, Fee: 500, Time: 1700000000},
+		{TxId: sendId, Address: changeAddr, Category: "receive", Amount: 39500, Time: 1700000000},
+		{TxId: foreignId, Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Category: "send", Amount: -10000, Time: 1700000100},
+	}
+	assert.NoError(t, account.Discover())
+
+	record, err := account.GetTransaction(sendId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxOutgoing, record.Direction)
+	assert.Equal(t, []TxAmount{{Address: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 60000}}, record.Amounts)
+	assert.Equal(t, uint64(500), record.Fee)
+
+	record, err = account.GetTransaction(foreignId)
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// the foreign transaction is not fetched again
+	delete(a.txs, foreignId)
+	assert.NoError(t, account.Discover())
+}
@@ -0,0 +1,200 @@
+package wallet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	ErrInvalidLabel = fmt.Errorf("invalid label")
+)
+
+// LabelType is the type of the reference which a label describes, as
+// defined in BIP329
+type LabelType string
+
+const (
+	LabelTx     LabelType = "tx"
+	LabelAddr   LabelType = "addr"
+	LabelPubKey LabelType = "pubkey"
+	LabelInput  LabelType = "input"
+	LabelOutput LabelType = "output"
+	LabelXpub   LabelType = "xpub"
+)
+
+// Label is a label record of BIP329. The reference is a txid for a
+// transaction, txid:vout for an input or an output, and the address, the
+// public key or the extended public key itself for the others.
+type Label struct {
+	Type   LabelType `json:"type"`
+	Ref    string    `json:"ref"`
+	Label  string    `json:"label,omitempty"`
+	Origin string    `json:"origin,omitempty"`
+	// Spendable is only set for outputs. A false one is a locked outpoint.
+	Spendable *bool `json:"spendable,omitempty"`
+}
+
+// validate checks the type and the reference of a label
+func (l Label) validate() error {
+	switch l.Type {
+	case LabelTx:
+		if len(l.Ref) != 2*chainhash.HashSize {
+			return ErrInvalidLabel
+		}
+		if _, err := chainhash.NewHashFromStr(l.Ref); err != nil {
+			return ErrInvalidLabel
+		}
+	case LabelInput, LabelOutput:
+		if _, err := ParseOutPoint(l.Ref); err != nil {
+			return ErrInvalidLabel
+		}
+	case LabelAddr, LabelPubKey, LabelXpub:
+		if l.Ref == "" {
+			return ErrInvalidLabel
+		}
+	default:
+		return ErrInvalidLabel
+	}
+	return nil
+}
+
+// SetLabel labels a reference of the type. An empty label removes it.
+func (c CoinAccount) SetLabel(t LabelType, ref, label string) error {
+	l := &Label{Type: t, Ref: ref, Label: label}
+	if err := l.validate(); err != nil {
+		return err
+	}
+	if label == "" {
+		return c.store.DeleteLabel(t, ref)
+	}
+	return c.store.SetLabel(l)
+}
+
+// Label returns the label of a reference, or an empty string if it has none
+func (c CoinAccount) Label(t LabelType, ref string) (string, error) {
+	l, err := c.store.GetLabel(t, ref)
+	if err != nil || l == nil {
+		return "", err
+	}
+	return l.Label, nil
+}
+
+// Labels returns all the labels of the account
+func (c CoinAccount) Labels() ([]*Label, error) {
+	return c.store.GetLabels()
+}
+
+// ExportLabels writes the labels of the account in the JSON lines of BIP329.
+// The locked outpoints are exported as the outputs which are not spendable.
+func (c CoinAccount) ExportLabels(w io.Writer) error {
+	labels, err := c.store.GetLabels()
+	if err != nil {
+		return err
+	}
+	locked, err := c.lockedOutPoints()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, l := range labels {
+		if l.Type == LabelOutput {
+			op, err := ParseOutPoint(l.Ref)
+			if err != nil {
+				return err
+			}
+			spendable := !locked[op]
+			l.Spendable = &spendable
+			delete(locked, op)
+		}
+		if err := encoder.Encode(l); err != nil {
+			return err
+		}
+	}
+
+	// the locked outpoints without labels
+	outpoints, err := c.store.GetLockedOutpoints()
+	if err != nil {
+		return err
+	}
+	for _, op := range outpoints {
+		if !locked[op] {
+			continue
+		}
+		spendable := false
+		l := &Label{Type: LabelOutput, Ref: op.String(), Spendable: &spendable}
+		if err := encoder.Encode(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportLabels reads labels in the JSON lines of BIP329 and returns the
+// number of the imported ones. The labels of the unknown types are skipped
+// as BIP329 suggests, and the other labels replace the existing ones. An
+// output which is not spendable is locked, and a spendable one is unlocked.
+func (c CoinAccount) ImportLabels(r io.Reader) (int, error) {
+	labels := make([]*Label, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var l Label
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			return 0, ErrInvalidLabel
+		}
+		if !knownLabelType(l.Type) {
+			continue
+		}
+		if err := l.validate(); err != nil {
+			return 0, err
+		}
+		labels = append(labels, &l)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	// the labels are imported only after all of them are valid
+	for _, l := range labels {
+		if l.Label != "" {
+			if err := c.store.SetLabel(l); err != nil {
+				return 0, err
+			}
+		}
+		if l.Type == LabelOutput && l.Spendable != nil {
+			op, err := ParseOutPoint(l.Ref)
+			if err != nil {
+				return 0, err
+			}
+			if *l.Spendable {
+				err = c.store.UnlockOutpoint(op)
+			} else {
+				err = c.store.LockOutpoint(op)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(labels), nil
+}
+
+// knownLabelType returns true if the type is defined in BIP329
+func knownLabelType(t LabelType) bool {
+	switch t {
+	case LabelTx, LabelAddr, LabelPubKey, LabelInput, LabelOutput, LabelXpub:
+		return true
+	}
+	return false
+}
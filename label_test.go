@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTxId = "f91d0a8a78462bc59398f2c5d7a84fcff491c26ba54c4833478b202796c8aafd"
+
+func TestLabel(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, account.SetLabel(LabelAddr, addr, "invoice #42"))
+	assert.NoError(t, account.SetLabel(LabelTx, testTxId, "rent"))
+	large := utxoOutPoint(utxos[0])
+	assert.NoError(t, account.SetLabel(LabelOutput, large.String(), "exchange withdrawal"))
+
+	label, err := account.Label(LabelAddr, addr)
+	assert.NoError(t, err)
+	assert.Equal(t, "invoice #42", label)
+	label, err = account.Label(LabelTx, "00"+testTxId[2:])
+	assert.NoError(t, err)
+	assert.Equal(t, "", label)
+
+	// the coins without their own labels take the label of the address
+	coins, err := account.ListUnspent()
+	assert.NoError(t, err)
+	assert.Len(t, coins, 2)
+	assert.Equal(t, large, coins[0].OutPoint)
+	assert.Equal(t, "exchange withdrawal", coins[0].Label)
+	assert.Equal(t, "invoice #42", coins[1].Label)
+
+	assert.NoError(t, account.SetLabel(LabelTx, testTxId, ""))
+	labels, err := account.Labels()
+	assert.NoError(t, err)
+	assert.Len(t, labels, 2)
+
+	assert.Equal(t, ErrInvalidLabel, account.SetLabel(LabelTx, "abc", "invalid"))
+	assert.Equal(t, ErrInvalidLabel, account.SetLabel(LabelOutput, testTxId, "invalid"))
+	assert.Equal(t, ErrInvalidLabel, account.SetLabel("wallet", "ref", "invalid"))
+}
+
+func TestExportImportLabels(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, account.SetLabel(LabelAddr, addr, "donations"))
+	assert.NoError(t, account.SetLabel(LabelOutput, utxoOutPoint(utxos[1]).String(), "tainted"))
+	assert.NoError(t, account.LockUnspent(utxoOutPoint(utxos[0]), utxoOutPoint(utxos[1])))
+
+	var buf bytes.Buffer
+	assert.NoError(t, account.ExportLabels(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`{"type":"addr","ref":"` + addr + `","label":"donations"}`,
+		`{"type":"output","ref":"` + utxoOutPoint(utxos[1]).String() + `","label":"tainted","spendable":false}`,
+		`{"type":"output","ref":"` + utxoOutPoint(utxos[0]).String() + `","spendable":false}`,
+	}, lines)
+
+	other, _, _ := newTestAccount(t)
+	defer other.Close()
+
+	records := buf.String() +
+		`{"type":"tx","ref":"` + testTxId + `","label":"rent","origin":"wpkh([d34db33f/84'/0'/0'])"}` + "\n" +
+		`{"type":"wallet","ref":"unknown","label":"skipped"}` + "\n"
+	n, err := other.ImportLabels(strings.NewReader(records))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	labels, err := other.Labels()
+	assert.NoError(t, err)
+	assert.Len(t, labels, 3)
+	locked, err := other.ListLocked()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []wire.OutPoint{utxoOutPoint(utxos[0]), utxoOutPoint(utxos[1])}, locked)
+
+	var exported bytes.Buffer
+	assert.NoError(t, other.ExportLabels(&exported))
+	assert.Contains(t, exported.String(), `"origin":"wpkh([d34db33f/84'/0'/0'])"`)
+
+	// a spendable output is unlocked
+	n, err = other.ImportLabels(strings.NewReader(`{"type":"output","ref":"` + utxoOutPoint(utxos[0]).String() + `","spendable":true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	locked, err = other.ListLocked()
+	assert.NoError(t, err)
+	assert.Equal(t, []wire.OutPoint{utxoOutPoint(utxos[1])}, locked)
+
+	_, err = other.ImportLabels(strings.NewReader(`{"type":"tx","ref":"nothex","label":"x"}`))
+	assert.Equal(t, ErrInvalidLabel, err)
+	_, err = other.ImportLabels(strings.NewReader(`not json`))
+	assert.Equal(t, ErrInvalidLabel, err)
+}
@@ -0,0 +1,318 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrUnknownAddress   = fmt.Errorf("address is not handed out by the account")
+	ErrMessageFormat    = fmt.Errorf("message format is not supported by the address type")
+	ErrMessageMultisig  = fmt.Errorf("multisig account does not sign messages")
+	ErrInvalidSignature = fmt.Errorf("invalid message signature")
+)
+
+// MessageFormat is the format of a signed message
+type MessageFormat int
+
+const (
+	// MessageLegacy is the Bitcoin Signed Message format of a compact
+	// signature, whose header tells the SegWit address types as BIP137 does
+	MessageLegacy MessageFormat = iota
+	// MessageBIP322 is the simple signature of BIP322, the witness of a
+	// virtual transaction which spends the coin of the address. It is
+	// only for the native SegWit addresses.
+	MessageBIP322
+)
+
+// messageMagic prefixes the legacy signed messages of each coin
+var messageMagic = map[CoinType]string{
+	BTC: "Bitcoin Signed Message:\n",
+	LTC: "Litecoin Signed Message:\n",
+}
+
+// the headers of the compact signatures of compressed keys for each address
+// type, to which the recovery id is added
+const (
+	headerP2PKH      = 31
+	headerP2SHP2WPKH = 35
+	headerP2WPKH     = 39
+)
+
+// legacyMessageHash returns the hash of a message which is signed in the
+// legacy format
+func legacyMessageHash(ct CoinType, message string) []byte {
+	var buf bytes.Buffer
+	wire.WriteVarString(&buf, 0, messageMagic[ct])
+	wire.WriteVarString(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// bip322ToSpend returns the virtual transaction of BIP322 which pays the
+// script of the address committing to the message
+func bip322ToSpend(script []byte, message string) (*wire.MsgTx, error) {
+	messageHash := chainhash.TaggedHash([]byte("BIP0322-signed-message"), []byte(message))
+	signatureScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(messageHash[:]).
+		Script()
+	if err != nil {
+		return nil, err
+	}
+
+	toSpend := wire.NewMsgTx(0)
+	toSpend.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  signatureScript,
+	})
+	toSpend.AddTxOut(wire.NewTxOut(0, script))
+	return toSpend, nil
+}
+
+// bip322ToSign returns the virtual transaction of BIP322 which spends the
+// coin of the message, and whose witness is the signature
+func bip322ToSign(toSpend *wire.MsgTx) *wire.MsgTx {
+	toSign := wire.NewMsgTx(0)
+	toSign.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash()}})
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return toSign
+}
+
+// SignMessage signs a message by the key of an address which the account
+// has handed out, and returns the base64 encoded signature. The legacy
+// format is for the P2PKH and the SegWit v0 addresses, and BIP322 is for
+// the native SegWit ones.
+func (c CoinAccount) SignMessage(addr, message string, format MessageFormat) (string, error) {
+	if c.IsMultisig() {
+		return "", ErrMessageMultisig
+	}
+	decoded, err := btcutil.DecodeAddress(addr, c.net)
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(decoded)
+	if err != nil {
+		return "", err
+	}
+	paths, err := c.scriptKeyPaths()
+	if err != nil {
+		return "", err
+	}
+	path, ok := paths[hex.EncodeToString(script)]
+	if !ok {
+		return "", ErrUnknownAddress
+	}
+	key, err := c.addressKey(path.index, path.change)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case MessageLegacy:
+		return c.signLegacyMessage(key.Serialize(), decoded, message)
+	case MessageBIP322:
+		return c.signBIP322Message(key, script, message)
+	default:
+		return "", ErrMessageFormat
+	}
+}
+
+func (c CoinAccount) signLegacyMessage(key []byte, decoded btcutil.Address, message string) (string, error) {
+	var header byte
+	switch decoded.(type) {
+	case *btcutil.AddressPubKeyHash:
+		header = headerP2PKH
+	case *btcutil.AddressScriptHash:
+		header = headerP2SHP2WPKH
+	case *btcutil.AddressWitnessPubKeyHash:
+		header = headerP2WPKH
+	default:
+		return "", ErrMessageFormat
+	}
+
+	privKey, _ := btcec.PrivKeyFromBytes(key)
+	sig, err := ecdsa.SignCompact(privKey, legacyMessageHash(c.CoinType, message), true)
+	if err != nil {
+		return "", err
+	}
+	sig[0] += header - headerP2PKH
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (c CoinAccount) signBIP322Message(key *address.PrivateKey, script []byte, message string) (string, error) {
+	if !txscript.IsPayToWitnessPubKeyHash(script) && !txscript.IsPayToTaproot(script) {
+		return "", ErrMessageFormat
+	}
+
+	toSpend, err := bip322ToSpend(script, message)
+	if err != nil {
+		return "", err
+	}
+	toSign := bip322ToSign(toSpend)
+	hash := toSpend.TxHash()
+	utxos := tx.UTXOs{{Key: key, TxHash: hash[:], Script: script}}
+	if err := c.signTx(utxos, toSign); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := psbt.WriteTxWitness(&buf, toSign.TxIn[0].Witness); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// VerifyMessage verifies the signature of a message by an address in the
+// legacy format or the simple one of BIP322. It returns
+// ErrInvalidSignature unless the key of the address signs the message.
+func (c CoinAccount) VerifyMessage(addr, message, signature string) error {
+	decoded, err := btcutil.DecodeAddress(addr, c.net)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if len(sig) == 65 && sig[0] >= 27 && sig[0] < headerP2WPKH+4 {
+		return c.verifyLegacyMessage(decoded, message, sig)
+	}
+	return verifyBIP322Message(decoded, message, sig)
+}
+
+func (c CoinAccount) verifyLegacyMessage(decoded btcutil.Address, message string, sig []byte) error {
+	header := sig[0]
+	// the compact signature of a compressed key which RecoverCompact takes
+	compact := append([]byte{}, sig...)
+	if header >= headerP2SHP2WPKH {
+		compact[0] = headerP2PKH + (header-headerP2SHP2WPKH)%4
+	}
+	pubKey, compressed, err := ecdsa.RecoverCompact(compact, legacyMessageHash(c.CoinType, message))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	var serialized []byte
+	if compressed {
+		serialized = pubKey.SerializeCompressed()
+	} else {
+		serialized = pubKey.SerializeUncompressed()
+	}
+	keyHash := btcutil.Hash160(serialized)
+
+	var expected btcutil.Address
+	switch {
+	case header < headerP2SHP2WPKH:
+		// the headers of P2PKH are also used for the SegWit v0
+		// addresses by the wallets before BIP137
+		switch decoded.(type) {
+		case *btcutil.AddressScriptHash:
+			if compressed {
+				expected, err = nestedWitnessAddress(keyHash, c.net)
+			}
+		case *btcutil.AddressWitnessPubKeyHash:
+			if compressed {
+				expected, err = btcutil.NewAddressWitnessPubKeyHash(keyHash, c.net)
+			}
+		default:
+			expected, err = btcutil.NewAddressPubKeyHash(keyHash, c.net)
+		}
+	case header < headerP2WPKH:
+		expected, err = nestedWitnessAddress(keyHash, c.net)
+	default:
+		expected, err = btcutil.NewAddressWitnessPubKeyHash(keyHash, c.net)
+	}
+	if err != nil {
+		return err
+	}
+	if expected == nil || expected.EncodeAddress() != decoded.EncodeAddress() {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// nestedWitnessAddress returns the P2SH address of the witness program of
+// a key hash
+func nestedWitnessAddress(keyHash []byte, net *chaincfg.Params) (btcutil.Address, error) {
+	redeemScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(keyHash).
+		Script()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewAddressScriptHash(redeemScript, net)
+}
+
+// readWitness parses a witness stack which psbt.WriteTxWitness serializes
+func readWitness(b []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(b)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(b)) {
+		return nil, ErrInvalidSignature
+	}
+	witness := make(wire.TxWitness, 0, n)
+	for i := uint64(0); i < n; i++ {
+		item, err := wire.ReadVarBytes(r, 0, uint32(len(b)), "witness item")
+		if err != nil {
+			return nil, err
+		}
+		witness = append(witness, item)
+	}
+	if r.Len() > 0 {
+		return nil, ErrInvalidSignature
+	}
+	return witness, nil
+}
+
+// verifyBIP322Message executes the script of the address against the
+// witness of the signature as BIP322 does
+func verifyBIP322Message(decoded btcutil.Address, message string, sig []byte) error {
+	script, err := txscript.PayToAddrScript(decoded)
+	if err != nil {
+		return err
+	}
+	if !txscript.IsWitnessProgram(script) {
+		return ErrMessageFormat
+	}
+	witness, err := readWitness(sig)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	toSpend, err := bip322ToSpend(script, message)
+	if err != nil {
+		return err
+	}
+	toSign := bip322ToSign(toSpend)
+	toSign.TxIn[0].Witness = witness
+
+	fetcher := txscript.NewCannedPrevOutputFetcher(script, 0)
+	engine, err := txscript.NewEngine(script, toSign, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(toSign, fetcher), 0, fetcher)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if err := engine.Execute(); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBIP322Vectors(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, false, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	// the test vectors of BIP322
+	addr := "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+	assert.NoError(t, account.VerifyMessage(addr, "",
+		"AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="))
+	assert.NoError(t, account.VerifyMessage(addr, "Hello World",
+		"AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="))
+	assert.Equal(t, ErrInvalidSignature, account.VerifyMessage(addr, "Hello World",
+		"AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="))
+
+	toSpend, err := bip322ToSpend([]byte{0x00, 0x14}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1",
+		hex.EncodeToString(toSpend.TxIn[0].SignatureScript[2:]))
+}
+
+func TestSignMessage(t *testing.T) {
+	w := newTestWallet(t)
+
+	for _, c := range []struct {
+		purpose  Purpose
+		ct       CoinType
+		format   MessageFormat
+		rejected MessageFormat
+	}{
+		{BIP44, BTC, MessageLegacy, MessageBIP322},
+		{BIP44, LTC, MessageLegacy, MessageBIP322},
+		{BIP49, BTC, MessageLegacy, MessageBIP322},
+		{BIP84, BTC, MessageLegacy, -1},
+		{BIP84, BTC, MessageBIP322, -1},
+		{BIP86, BTC, MessageBIP322, MessageLegacy},
+	} {
+		account, err := w.Account(c.purpose, c.ct, true, 0)
+		assert.NoError(t, err)
+
+		addr, err := account.NewExternalAddr()
+		assert.NoError(t, err)
+		sig, err := account.SignMessage(addr, "I control this address", c.format)
+		assert.NoError(t, err)
+		assert.NoError(t, account.VerifyMessage(addr, "I control this address", sig), addr)
+		assert.Equal(t, ErrInvalidSignature, account.VerifyMessage(addr, "I control another address", sig))
+
+		other, err := account.Address(10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, ErrInvalidSignature, account.VerifyMessage(other, "I control this address", sig))
+		_, err = account.SignMessage(other, "I control this address", c.format)
+		assert.Equal(t, ErrUnknownAddress, err)
+
+		if c.rejected >= 0 {
+			_, err = account.SignMessage(addr, "I control this address", c.rejected)
+			assert.Equal(t, ErrMessageFormat, err)
+		}
+		account.Close()
+	}
+}
+
+func TestVerifyLegacyHeader(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	addr, err := account.NewExternalAddr()
+	assert.NoError(t, err)
+	sig, err := account.SignMessage(addr, "proof", MessageLegacy)
+	assert.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	assert.NoError(t, err)
+	assert.True(t, raw[0] >= headerP2WPKH)
+
+	// the wallets before BIP137 sign for SegWit addresses with the headers
+	// of P2PKH
+	raw[0] -= headerP2WPKH - headerP2PKH
+	assert.NoError(t, account.VerifyMessage(addr, "proof", base64.StdEncoding.EncodeToString(raw)))
+
+	// but the header of another SegWit address type is rejected
+	raw[0] += headerP2SHP2WPKH - headerP2PKH
+	assert.Equal(t, ErrInvalidSignature, account.VerifyMessage(addr, "proof", base64.StdEncoding.EncodeToString(raw)))
+}
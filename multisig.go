@@ -0,0 +1,369 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrInvalidMultisig = fmt.Errorf("invalid multisig account")
+	ErrMultisigPSBT    = fmt.Errorf("multisig account spends coins through PSBTs")
+)
+
+// MultisigScriptType is the script type level of a BIP48 derivation path.
+// It decides which kind of addresses a multisig account hands out.
+type MultisigScriptType uint32
+
+const (
+	// MultisigP2SH accounts use legacy P2SH addresses. BIP48 leaves the
+	// legacy script type out, and 0' is used for it here.
+	MultisigP2SH MultisigScriptType = 0
+	// MultisigP2SHP2WSH accounts use SegWit P2WSH nested in P2SH addresses
+	MultisigP2SHP2WSH MultisigScriptType = 1
+	// MultisigP2WSH accounts use native SegWit P2WSH addresses
+	MultisigP2WSH MultisigScriptType = 2
+)
+
+// cosigner is the account key of a cosigner with its key origin
+type cosigner struct {
+	key         *address.ExtendedKey
+	fingerprint uint32
+	path        []uint32
+}
+
+// multisig describes the cosigners of a multisig account
+type multisig struct {
+	required   int
+	scriptType MultisigScriptType
+	// the account keys of all the cosigners including the account itself
+	cosigners []cosigner
+	// the virtual size of a vin signed by the required cosigners
+	inputSize int
+}
+
+// MultisigAccount returns a multisig account of the account key
+// m / 48' / coin' / account' / script_type' and the account keys of the
+// other cosigners. The coins of an address are spent by the signatures of
+// the required number of cosigners, which are collected in PSBTs.
+//
+// A cosigner key is an extended public key with its key origin, for example
+// "[d34db33f/48'/0'/0'/2']xpub...". The public keys of an address are sorted
+// as BIP67 does, so all the cosigners of the same keys, the same required
+// number and the same script type have the same addresses.
+func (w Wallet) MultisigAccount(ct CoinType, test Test, account uint32, scriptType MultisigScriptType,
+	required int, cosignerKeys []string) (*CoinAccount, error) {
+
+	coinParams := CoinParams[ct][test]
+	masterKey, err := address.NewMaster(w.seed, coinParams)
+	if err != nil {
+		return nil, err
+	}
+
+	key := masterKey
+	path := make([]uint32, 0, 4)
+	for _, i := range []uint32{uint32(BIP48), CoinMap[ct], account, uint32(scriptType)} {
+		k, err := key.Child(i + address.HardenedKeyStart)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+		path = append(path, i+address.HardenedKeyStart)
+	}
+
+	fingerprint, err := masterFingerprint(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newMultisigAccount(key, ct, test, fingerprint, path, scriptType, required, cosignerKeys, w.dataFile)
+	if err != nil {
+		return nil, err
+	}
+	c.index = account
+	return c, nil
+}
+
+// NewWatchOnlyMultisigAccount returns a multisig account which is able to
+// discover addresses and balances, and to create unsigned PSBTs, but not
+// to sign them. The account key is the one of a cosigner in the same form
+// as NewWatchOnlyAccount takes.
+func NewWatchOnlyMultisigAccount(accountKey string, ct CoinType, test Test, scriptType MultisigScriptType,
+	required int, cosignerKeys []string, dataFile string) (*CoinAccount, error) {
+
+	coinParams, ok := CoinParams[ct][test]
+	if !ok {
+		return nil, ErrInvalidAccountKey
+	}
+
+	key, fingerprint, path, err := parseAccountKey(accountKey, coinParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMultisigAccount(key, ct, test, fingerprint, path, scriptType, required, cosignerKeys, dataFile)
+}
+
+// newMultisigAccount returns a multisig account of an account key and
+// the keys of the other cosigners
+func newMultisigAccount(accountKey *address.ExtendedKey, ct CoinType, test Test, fingerprint uint32, path []uint32,
+	scriptType MultisigScriptType, required int, cosignerKeys []string, dataFile string) (*CoinAccount, error) {
+
+	if scriptType > MultisigP2WSH {
+		return nil, ErrInvalidMultisig
+	}
+
+	pubKey, err := accountKey.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	m := &multisig{
+		required:   required,
+		scriptType: scriptType,
+		cosigners:  []cosigner{{key: pubKey, fingerprint: fingerprint, path: path}},
+	}
+	for _, k := range cosignerKeys {
+		key, fp, p, err := parseAccountKey(k, CoinParams[ct][test])
+		if err != nil {
+			return nil, err
+		}
+		m.cosigners = append(m.cosigners, cosigner{key: key, fingerprint: fp, path: p})
+	}
+	if required < 1 || required > len(m.cosigners) || len(m.cosigners) > tx.MaxMultisigKeys {
+		return nil, ErrInvalidMultisig
+	}
+
+	xpubs := make([]string, 0, len(m.cosigners))
+	for _, cs := range m.cosigners {
+		xpub := cs.key.String()
+		for _, x := range xpubs {
+			if x == xpub {
+				return nil, ErrInvalidMultisig
+			}
+		}
+		xpubs = append(xpubs, xpub)
+	}
+	sort.Strings(xpubs)
+
+	redeemScript, witnessScript, err := m.scripts(0, false)
+	if err != nil {
+		return nil, err
+	}
+	txIn := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+	if err := fillMultisigPlaceholder(txIn, redeemScript, witnessScript); err != nil {
+		return nil, err
+	}
+	m.inputSize = (txIn.SerializeSize()*4 + txIn.Witness.SerializeSize() + 3) / 4
+
+	// the store belongs to the multisig as a whole, so the same key in
+	// another multisig keeps its own addresses and coins
+	id := btcutil.Hash160([]byte(fmt.Sprintf("%d/%d/%s", required, scriptType, strings.Join(xpubs, ","))))
+	c, err := openCoinAccount(accountKey, BIP48, ct, test, fingerprint, path, "multisig-"+hex.EncodeToString(id), dataFile)
+	if err != nil {
+		return nil, err
+	}
+	c.multisig = m
+	return c, nil
+}
+
+// IsMultisig returns true if the account is a multisig account
+func (c CoinAccount) IsMultisig() bool {
+	return c.multisig != nil
+}
+
+// derivations returns the public keys of the cosigners for an address with
+// their derivations in the BIP67 order
+func (m multisig) derivations(i uint32, change bool) ([]*psbt.Bip32Derivation, error) {
+	var changeBit uint32
+	if change {
+		changeBit = 1
+	}
+
+	derivations := make([]*psbt.Bip32Derivation, 0, len(m.cosigners))
+	for _, cs := range m.cosigners {
+		k, err := cs.key.Child(changeBit)
+		if err != nil {
+			return nil, err
+		}
+		k, err = k.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := k.PubKey()
+		if err != nil {
+			return nil, err
+		}
+
+		path := make([]uint32, 0, len(cs.path)+2)
+		path = append(path, cs.path...)
+		derivations = append(derivations, &psbt.Bip32Derivation{
+			PubKey:               pub.SerializeCompressed(),
+			MasterKeyFingerprint: cs.fingerprint,
+			Bip32Path:            append(path, changeBit, i),
+		})
+	}
+
+	sort.Slice(derivations, func(a, b int) bool {
+		return bytes.Compare(derivations[a].PubKey, derivations[b].PubKey) < 0
+	})
+	return derivations, nil
+}
+
+// scripts returns the redeem script and the witness script of an address.
+// The redeem script is nil for P2WSH addresses and the witness script is
+// nil for legacy P2SH addresses.
+func (m multisig) scripts(i uint32, change bool) ([]byte, []byte, error) {
+	derivations, err := m.derivations(i, change)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKeys := make([][]byte, 0, len(derivations))
+	for _, d := range derivations {
+		pubKeys = append(pubKeys, d.PubKey)
+	}
+	script, err := tx.MultisigScript(m.required, pubKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch m.scriptType {
+	case MultisigP2SH:
+		return script, nil, nil
+	case MultisigP2SHP2WSH:
+		scriptHash := sha256.Sum256(script)
+		redeemScript, err := txscript.NewScriptBuilder().
+			AddOp(txscript.OP_0).
+			AddData(scriptHash[:]).
+			Script()
+		if err != nil {
+			return nil, nil, err
+		}
+		return redeemScript, script, nil
+	default:
+		return nil, script, nil
+	}
+}
+
+// multisigAddress returns the address of the multisig scripts
+func (c CoinAccount) multisigAddress(i uint32, change bool) (string, error) {
+	redeemScript, witnessScript, err := c.multisig.scripts(i, change)
+	if err != nil {
+		return "", err
+	}
+
+	if redeemScript != nil {
+		addr, err := btcutil.NewAddressScriptHash(redeemScript, c.net)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	}
+
+	scriptHash := sha256.Sum256(witnessScript)
+	addr, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], c.net)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// isMultisigUTXO returns true if a UTXO is spent by a multisig script
+func isMultisigUTXO(u *tx.UTXO) bool {
+	return txscript.GetScriptClass(u.WitnessScript) == txscript.MultiSigTy ||
+		txscript.GetScriptClass(u.RedeemScript) == txscript.MultiSigTy
+}
+
+// fillMultisigPlaceholder fills a vin which spends a multisig script with
+// placeholders of the same sizes as the signatures of the required cosigners
+func fillMultisigPlaceholder(txIn *wire.TxIn, redeemScript, witnessScript []byte) error {
+	script := witnessScript
+	if script == nil {
+		script = redeemScript
+	}
+	_, required, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return err
+	}
+
+	// a DER signature with the sighash type takes at most 73 bytes and
+	// CHECKMULTISIG pops an extra item
+	sig := make([]byte, 73)
+
+	if witnessScript == nil {
+		builder := txscript.NewScriptBuilder().AddOp(txscript.OP_0)
+		for i := 0; i < required; i++ {
+			builder.AddData(sig)
+		}
+		signatureScript, err := builder.AddData(redeemScript).Script()
+		if err != nil {
+			return err
+		}
+		txIn.SignatureScript = signatureScript
+		return nil
+	}
+
+	witness := wire.TxWitness{nil}
+	for i := 0; i < required; i++ {
+		witness = append(witness, sig)
+	}
+	txIn.Witness = append(witness, witnessScript)
+
+	if redeemScript != nil {
+		signatureScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+		if err != nil {
+			return err
+		}
+		txIn.SignatureScript = signatureScript
+	}
+	return nil
+}
+
+// addMultisigKeyInfo adds the scripts of a multisig address and the
+// derivations of all the cosigners into a psbt input or output
+func (c CoinAccount) addMultisigKeyInfo(k keyPath, redeemScript, witnessScript *[]byte,
+	derivations *[]*psbt.Bip32Derivation) error {
+
+	redeem, witness, err := c.multisig.scripts(k.index, k.change)
+	if err != nil {
+		return err
+	}
+	*redeemScript = redeem
+	*witnessScript = witness
+
+	ds, err := c.multisig.derivations(k.index, k.change)
+	if err != nil {
+		return err
+	}
+	*derivations = mergeDerivations(*derivations, ds)
+	return nil
+}
+
+// trimMultisigSigs drops the partial signatures of a multisig input which
+// are more than the required ones, since a finalized input carries exactly
+// the required signatures. It returns false if there are not enough.
+func trimMultisigSigs(in *psbt.PInput) bool {
+	script := in.WitnessScript
+	if script == nil {
+		script = in.RedeemScript
+	}
+	if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+		return true
+	}
+	_, required, err := txscript.CalcMultiSigStats(script)
+	if err != nil || len(in.PartialSigs) < required {
+		return false
+	}
+	in.PartialSigs = in.PartialSigs[:required]
+	return true
+}
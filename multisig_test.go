@@ -0,0 +1,190 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var cosignerSeeds = []string{
+	seedHex,
+	"1ded5e8970380eef15f742348d28511111366ae6a55188402b16c69922006fe6",
+	"2ded5e8970380eef15f742348d28511111366ae6a55188402b16c69922006fe6",
+}
+
+// newMultisigTestAccounts returns the multisig accounts of all the cosigners
+func newMultisigTestAccounts(t *testing.T, scriptType MultisigScriptType, required int) []*CoinAccount {
+	keys := make([]string, 0, len(cosignerSeeds))
+	dataFiles := make([]string, 0, len(cosignerSeeds))
+	for _, s := range cosignerSeeds {
+		seed, err := hex.DecodeString(s)
+		assert.NoError(t, err)
+		dataFile := testDataFile(t)
+		dataFiles = append(dataFiles, dataFile)
+		account, err := New(seed, dataFile).MultisigAccount(BTC, true, 0, scriptType, 1, nil)
+		assert.NoError(t, err)
+		key, err := account.AccountKey()
+		assert.NoError(t, err)
+		account.Close()
+		keys = append(keys, key)
+	}
+
+	accounts := make([]*CoinAccount, 0, len(cosignerSeeds))
+	for i, s := range cosignerSeeds {
+		seed, err := hex.DecodeString(s)
+		assert.NoError(t, err)
+		others := make([]string, 0, len(keys)-1)
+		others = append(others, keys[:i]...)
+		others = append(others, keys[i+1:]...)
+		account, err := New(seed, dataFiles[i]).MultisigAccount(BTC, true, 0, scriptType, required, others)
+		assert.NoError(t, err)
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func closeMultisigTestAccounts(accounts []*CoinAccount) {
+	for _, account := range accounts {
+		account.Close()
+	}
+}
+
+func TestMultisigAddress(t *testing.T) {
+	prefixes := map[MultisigScriptType]string{
+		MultisigP2SH:      "2",
+		MultisigP2SHP2WSH: "2",
+		MultisigP2WSH:     "tb1q",
+	}
+	for scriptType, prefix := range prefixes {
+		accounts := newMultisigTestAccounts(t, scriptType, 2)
+
+		key, err := accounts[0].AccountKey()
+		assert.NoError(t, err)
+		assert.Contains(t, key, fmt.Sprintf("/48'/0'/0'/%d']tpub", scriptType))
+
+		// every cosigner has the same addresses
+		for _, change := range []bool{false, true} {
+			addr, err := accounts[0].Address(3, change)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(addr, prefix), addr)
+			for _, account := range accounts[1:] {
+				a, err := account.Address(3, change)
+				assert.NoError(t, err)
+				assert.Equal(t, addr, a)
+			}
+		}
+		closeMultisigTestAccounts(accounts)
+	}
+
+	accounts := newMultisigTestAccounts(t, MultisigP2WSH, 2)
+	defer closeMultisigTestAccounts(accounts)
+	key, err := accounts[1].AccountKey()
+	assert.NoError(t, err)
+
+	w := newTestWallet(t)
+	_, err = w.MultisigAccount(BTC, true, 0, MultisigP2WSH, 3, []string{key})
+	assert.Equal(t, ErrInvalidMultisig, err)
+	_, err = w.MultisigAccount(BTC, true, 0, MultisigP2WSH, 1, []string{key, key})
+	assert.Equal(t, ErrInvalidMultisig, err)
+	_, err = w.MultisigAccount(BTC, true, 0, MultisigP2WSH+1, 1, []string{key})
+	assert.Equal(t, ErrInvalidMultisig, err)
+}
+
+func TestMultisigPSBT(t *testing.T) {
+	for _, scriptType := range []MultisigScriptType{MultisigP2SH, MultisigP2SHP2WSH, MultisigP2WSH} {
+		accounts := newMultisigTestAccounts(t, scriptType, 2)
+
+		addr, err := accounts[0].Address(0, false)
+		assert.NoError(t, err)
+		script, err := tx.DefaultP2PKScript(addr)
+		assert.NoError(t, err)
+
+		prevTx := wire.NewMsgTx(wire.TxVersion)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+		prevTx.AddTxOut(wire.NewTxOut(100000, script))
+		var buf bytes.Buffer
+		assert.NoError(t, prevTx.Serialize(&buf))
+		prevHash := prevTx.TxHash()
+		accounts[0].SetAgent(&fakeAgent{txs: map[string]string{
+			prevHash.String(): hex.EncodeToString(buf.Bytes()),
+		}})
+		assert.NoError(t, accounts[0].store.SetUTXO(addr, tx.UTXOs{{TxHash: prevHash[:], Value: 100000}}))
+
+		sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+		_, _, err = accounts[0].Send(sends, nil, 2000)
+		assert.Equal(t, ErrMultisigPSBT, err)
+
+		p, err := accounts[0].CreatePSBT(sends, nil, 2000)
+		assert.NoError(t, err, "script type %d", scriptType)
+		b64, err := p.B64Encode()
+		assert.NoError(t, err)
+
+		// the cosigners sign their own copies of the psbt
+		signed := make([]*psbt.Packet, 0, len(accounts))
+		for _, account := range accounts {
+			s, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+			assert.NoError(t, err)
+			summary, err := account.SummarizePSBT(s)
+			assert.NoError(t, err)
+			assert.True(t, summary.Outputs[0].Change)
+
+			n, err := account.SignPSBT(s)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			signed = append(signed, s)
+		}
+
+		_, err = FinalizePSBT(signed[0])
+		assert.Equal(t, ErrPSBTIncomplete, err)
+
+		// the signatures of all the cosigners are more than required
+		combined, err := CombinePSBT(signed...)
+		assert.NoError(t, err)
+		signedTx, err := FinalizePSBT(combined)
+		assert.NoError(t, err, "script type %d", scriptType)
+
+		utxos := tx.UTXOs{{TxHash: prevHash[:], Value: 100000, Script: script}}
+		verifyTx(t, signedTx, utxos)
+
+		// the signatures are no larger than the placeholders of the fee
+		fee := uint64(txFee(signedTx, utxos))
+		assert.True(t, fee >= feeForSize(txVirtualSize(signedTx), 2000))
+
+		closeMultisigTestAccounts(accounts)
+	}
+}
+
+func TestMultisigDiscover(t *testing.T) {
+	accounts := newMultisigTestAccounts(t, MultisigP2WSH, 2)
+	defer closeMultisigTestAccounts(accounts)
+
+	addr, err := accounts[0].Address(2, false)
+	assert.NoError(t, err)
+	changeAddr, err := accounts[0].Address(1, true)
+	assert.NoError(t, err)
+	accounts[1].SetAgent(&fakeAgent{unspent: map[string]tx.UTXOs{
+		addr:       {testUTXO(1, 30000, 1, "")},
+		changeAddr: {testUTXO(2, 20000, 1, "")},
+	}})
+
+	assert.NoError(t, accounts[1].Discover())
+	balance, err := accounts[1].GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50000), balance)
+
+	utxos, err := accounts[1].spendableUTXOs()
+	assert.NoError(t, err)
+	assert.Len(t, utxos, 2)
+	for _, u := range utxos {
+		assert.Nil(t, u.Key)
+		assert.NotNil(t, u.WitnessScript)
+	}
+}
@@ -0,0 +1,242 @@
+package wallet
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// PendingExpiry is how long the coins of a broadcast transaction stay
+// pending if the agent never reports the transaction
+const PendingExpiry = 24 * time.Hour
+
+// PendingOutPoint is a coin which a transaction broadcast by the account
+// spends or creates, until the agent reports the transaction. It keeps the
+// coins from being spent twice, and the change spendable, before the next
+// Discover.
+type PendingOutPoint struct {
+	OutPoint wire.OutPoint
+	TxId     string
+	// Spent is true for a coin which the transaction spends, and false
+	// for a coin of the account which it creates, such as the change
+	Spent   bool
+	Address string
+	Value   uint64
+	Time    time.Time
+}
+
+// unspentCoins returns the UTXOs of the addresses of the account without
+// the coins which are spent by the pending transactions and with the ones
+// which they create
+func (c CoinAccount) unspentCoins() (map[string]tx.UTXOs, error) {
+	utxos, err := c.store.GetAllUTXO()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := c.store.GetPendingOutPoints()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return utxos, nil
+	}
+	spent := spentOutPoints(pending)
+
+	coins := make(map[string]tx.UTXOs)
+	known := make(map[wire.OutPoint]bool)
+	for addr, txs := range utxos {
+		for _, u := range txs {
+			op := utxoOutPoint(u)
+			known[op] = true
+			if !spent[op] {
+				coins[addr] = append(coins[addr], u)
+			}
+		}
+	}
+	for _, p := range pending {
+		if p.Spent || known[p.OutPoint] || spent[p.OutPoint] {
+			continue
+		}
+		hash := p.OutPoint.Hash
+		coins[p.Address] = append(coins[p.Address], &tx.UTXO{
+			TxHash:  hash[:],
+			TxIndex: p.OutPoint.Index,
+			Value:   p.Value,
+		})
+	}
+	return coins, nil
+}
+
+// spentOutPoints returns the coins which the pending transactions spend
+func spentOutPoints(pending []*PendingOutPoint) map[wire.OutPoint]bool {
+	spent := make(map[wire.OutPoint]bool)
+	for _, p := range pending {
+		if p.Spent {
+			spent[p.OutPoint] = true
+		}
+	}
+	return spent
+}
+
+// pendingSpent returns the coins of the account which the pending
+// transactions spend
+func (c CoinAccount) pendingSpent() (map[wire.OutPoint]bool, error) {
+	pending, err := c.store.GetPendingOutPoints()
+	if err != nil {
+		return nil, err
+	}
+	return spentOutPoints(pending), nil
+}
+
+// knownCoins returns the addresses and the values of all the coins of the
+// account which are kept in the store or pending, including the spent ones
+func (c CoinAccount) knownCoins() (map[wire.OutPoint]*TxAmount, error) {
+	utxos, err := c.store.GetAllUTXO()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := c.store.GetPendingOutPoints()
+	if err != nil {
+		return nil, err
+	}
+
+	coins := make(map[wire.OutPoint]*TxAmount)
+	for addr, txs := range utxos {
+		for _, u := range txs {
+			coins[utxoOutPoint(u)] = &TxAmount{Address: addr, Amount: u.Value}
+		}
+	}
+	for _, p := range pending {
+		if _, ok := coins[p.OutPoint]; !ok {
+			coins[p.OutPoint] = &TxAmount{Address: p.Address, Amount: p.Value}
+		}
+	}
+	return coins, nil
+}
+
+// addPending marks the coins of the account which a broadcast transaction
+// spends, and adds the coins of the account which it creates. A transaction
+// which spends the same coins as a pending one replaces it, and so the
+// coins which the replaced one creates are dropped.
+func (c CoinAccount) addPending(signedTx *wire.MsgTx, coins map[wire.OutPoint]*TxAmount, owned map[string]bool) error {
+	pending, err := c.store.GetPendingOutPoints()
+	if err != nil {
+		return err
+	}
+	spentBy := make(map[wire.OutPoint]string)
+	for _, p := range pending {
+		if p.Spent {
+			spentBy[p.OutPoint] = p.TxId
+		}
+	}
+
+	txHash := signedTx.TxHash()
+	txId := txHash.String()
+	now := time.Unix(time.Now().Unix(), 0)
+
+	replaced := make(map[string]bool)
+	for _, txIn := range signedTx.TxIn {
+		op := txIn.PreviousOutPoint
+		if by, ok := spentBy[op]; ok && by != txId {
+			replaced[by] = true
+		}
+		coin, ok := coins[op]
+		if !ok {
+			continue
+		}
+		if err := c.store.SetPendingOutPoint(&PendingOutPoint{
+			OutPoint: op,
+			TxId:     txId,
+			Spent:    true,
+			Address:  coin.Address,
+			Value:    coin.Amount,
+			Time:     now,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for i, txOut := range signedTx.TxOut {
+		addr := c.outputAddress(txOut)
+		if addr == "" || !owned[addr] {
+			continue
+		}
+		if err := c.store.SetPendingOutPoint(&PendingOutPoint{
+			OutPoint: *wire.NewOutPoint(&txHash, uint32(i)),
+			TxId:     txId,
+			Address:  addr,
+			Value:    uint64(txOut.Value),
+			Time:     now,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pending {
+		if !p.Spent && replaced[p.TxId] {
+			if err := c.store.DeletePendingOutPoint(p.OutPoint); err != nil {
+				return err
+			}
+		}
+	}
+	for id := range replaced {
+		t, err := c.store.GetTransaction(id)
+		if err != nil {
+			return err
+		}
+		if t != nil && t.State == TxPending {
+			t.State = TxConflicted
+			if err := c.store.SetTransaction(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconcilePending drops the pending coins which the unspent coins reported
+// by the agent already reflect: a spent coin which is no longer unspent and
+// a created coin which is unspent. The coins of the transactions which
+// conflict with confirmed ones, and those which the agent never reports
+// within PendingExpiry, are dropped as well.
+func (c CoinAccount) reconcilePending(reported map[string]tx.UTXOs) error {
+	pending, err := c.store.GetPendingOutPoints()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	unspent := make(map[wire.OutPoint]bool)
+	for _, txs := range reported {
+		for _, u := range txs {
+			unspent[utxoOutPoint(u)] = true
+		}
+	}
+
+	for _, p := range pending {
+		drop := p.Spent != unspent[p.OutPoint] || time.Since(p.Time) > PendingExpiry
+		if !drop {
+			t, err := c.store.GetTransaction(p.TxId)
+			if err != nil {
+				return err
+			}
+			drop = t != nil && t.State == TxConflicted
+		}
+		if drop {
+			if err := c.store.DeletePendingOutPoint(p.OutPoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PendingOutPoints returns the coins which the pending transactions of the
+// account spend or create
+func (c CoinAccount) PendingOutPoints() ([]*PendingOutPoint, error) {
+	return c.store.GetPendingOutPoints()
+}
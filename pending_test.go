@@ -0,0 +1,195 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+// pendingOf returns the pending coins which a transaction spends and the
+// ones which it creates
+func pendingOf(t *testing.T, account *CoinAccount, txId string) (spent, created []*PendingOutPoint) {
+	pending, err := account.PendingOutPoints()
+	assert.NoError(t, err)
+	for _, p := range pending {
+		if p.TxId != txId {
+			continue
+		}
+		if p.Spent {
+			spent = append(spent, p)
+		} else {
+			created = append(created, p)
+		}
+	}
+	return spent, created
+}
+
+func TestPendingSend(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Len(t, sent.TxIn, 1)
+
+	spent, created := pendingOf(t, account, txId)
+	assert.Len(t, spent, 1)
+	assert.Equal(t, utxoOutPoint(utxos[0]), spent[0].OutPoint)
+	assert.Len(t, created, 1)
+	change := created[0]
+	changeAddr, err := account.Address(0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, changeAddr, change.Address)
+	assert.Equal(t, uint64(sent.TxOut[0].Value), change.Value)
+
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, 30000+change.Value, balance)
+
+	// the next send spends the pending change instead of the spent coin
+	sends[0].Amount = 60000
+	_, rawTx, err = account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	next := deserializeTx(t, rawTx)
+	outpoints := make([]wire.OutPoint, 0)
+	for _, txIn := range next.TxIn {
+		outpoints = append(outpoints, txIn.PreviousOutPoint)
+	}
+	assert.ElementsMatch(t, []wire.OutPoint{change.OutPoint, utxoOutPoint(utxos[1])}, outpoints)
+
+	_, _, err = account.SendFrom([]wire.OutPoint{utxoOutPoint(utxos[0])}, sends, nil, 1000)
+	assert.Equal(t, ErrSpentUTXO, err)
+}
+
+func TestReconcilePending(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, _, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	_, created := pendingOf(t, account, txId)
+	assert.Len(t, created, 1)
+	change := created[0]
+
+	// the agent has not seen the transaction yet
+	a.unspent = map[string]tx.UTXOs{addr: {utxos[0], utxos[1]}}
+	assert.NoError(t, account.Discover())
+	pending, err := account.PendingOutPoints()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, 30000+change.Value, balance)
+
+	// the agent reports the change and no longer the spent coin
+	hash := change.OutPoint.Hash
+	a.unspent = map[string]tx.UTXOs{
+		addr:           {utxos[1]},
+		change.Address: {{TxHash: hash[:], TxIndex: change.OutPoint.Index, Value: change.Value}},
+	}
+	assert.NoError(t, account.Discover())
+	pending, err = account.PendingOutPoints()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+	balance, err = account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, 30000+change.Value, balance)
+}
+
+func TestExpirePending(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	_, _, err = account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+
+	// the transaction never shows up
+	pending, err := account.PendingOutPoints()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	for _, p := range pending {
+		p.Time = time.Now().Add(-PendingExpiry - time.Minute)
+		assert.NoError(t, account.store.SetPendingOutPoint(p))
+	}
+
+	a.unspent = map[string]tx.UTXOs{addr: {utxos[0]}}
+	assert.NoError(t, account.Discover())
+	pending, err = account.PendingOutPoints()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+	balance, err := account.GetBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100000), balance)
+}
+
+func TestReplacePending(t *testing.T) {
+	account, a, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	a.txs[txId] = rawTx
+	a.mempool = map[string]*agent.MempoolEntry{txId: {}}
+
+	bumpedId, rawTx, err := account.BumpFee(txId, 5000)
+	assert.NoError(t, err)
+	bumped := deserializeTx(t, rawTx)
+
+	// the change of the replaced transaction is dropped
+	spent, created := pendingOf(t, account, txId)
+	assert.Empty(t, spent)
+	assert.Empty(t, created)
+	spent, created = pendingOf(t, account, bumpedId)
+	assert.Len(t, spent, 1)
+	assert.Len(t, created, 1)
+	assert.Equal(t, uint64(bumped.TxOut[0].Value), created[0].Value)
+
+	record, err := account.GetTransaction(txId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxConflicted, record.State)
+	record, err = account.GetTransaction(bumpedId)
+	assert.NoError(t, err)
+	assert.Equal(t, TxOutgoing, record.Direction)
+	assert.Equal(t, TxPending, record.State)
+}
+
+func TestBoltAccountStorePendingOutPoints(t *testing.T) {
+	s, err := NewBoltAccountStore(testDataFile(t), "test_account")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	p := &PendingOutPoint{
+		OutPoint: wire.OutPoint{Hash: [32]byte{1, 2, 3}, Index: 300},
+		TxId:     "5b35f3d330dbad503f2b26313b6ac0dceb7907186303ba7c7d3ab845c598e0e6",
+		Spent:    true,
+		Address:  "tb1qgkrwsln6ua8pn0hl0mvzp7lq6dk3f5s8ya2gsw",
+		Value:    123456,
+		Time:     time.Unix(1700000000, 0),
+	}
+	assert.NoError(t, s.SetPendingOutPoint(p))
+	pending, err := s.GetPendingOutPoints()
+	assert.NoError(t, err)
+	assert.Equal(t, []*PendingOutPoint{p}, pending)
+
+	assert.NoError(t, s.DeletePendingOutPoint(p.OutPoint))
+	assert.NoError(t, s.DeletePendingOutPoint(p.OutPoint))
+	pending, err = s.GetPendingOutPoints()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
@@ -0,0 +1,702 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrPSBTMismatch   = fmt.Errorf("psbts are not for the same transaction")
+	ErrPSBTIncomplete = fmt.Errorf("psbt is not fully signed")
+
+	ErrPSBTNotConfirmed = fmt.Errorf("psbt does not match the confirmation")
+
+	ErrPSBTSighash     = fmt.Errorf("psbt asks for a sighash type which does not sign the whole transaction")
+	ErrPSBTPrevTx      = fmt.Errorf("psbt has no previous transaction of a non-taproot input")
+	ErrPSBTWitnessUtxo = fmt.Errorf("psbt witness utxo does not match the previous transaction")
+)
+
+// keyPath locates an address key under the account key
+type keyPath struct {
+	change bool
+	index  uint32
+}
+
+// bip32Path returns the full derivation path of a key path
+func (c CoinAccount) bip32Path(k keyPath) []uint32 {
+	var changeBit uint32
+	if k.change {
+		changeBit = 1
+	}
+	path := make([]uint32, 0, len(c.path)+2)
+	path = append(path, c.path...)
+	return append(path, changeBit, k.index)
+}
+
+// keyPathOf parses a derivation path and returns the key path if the key
+// belongs to this account
+func (c CoinAccount) keyPathOf(fingerprint uint32, path []uint32) (keyPath, bool) {
+	if fingerprint != c.fingerprint || len(path) != len(c.path)+2 {
+		return keyPath{}, false
+	}
+	for i, p := range c.path {
+		if path[i] != p {
+			return keyPath{}, false
+		}
+	}
+	if path[len(c.path)] > 1 {
+		return keyPath{}, false
+	}
+	return keyPath{change: path[len(c.path)] == 1, index: path[len(c.path)+1]}, true
+}
+
+// scriptKeyPaths returns the key paths of all the scripts the account
+// has handed out, keyed by the hex encoded pkScripts
+func (c CoinAccount) scriptKeyPaths() (map[string]keyPath, error) {
+	paths := make(map[string]keyPath)
+	for _, change := range []bool{false, true} {
+		index, err := c.store.GetChainIndex(change)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < index.Known(); i++ {
+			addr, err := c.Address(i, change)
+			if err != nil {
+				return nil, err
+			}
+			script, err := tx.DefaultP2PKScript(addr)
+			if err != nil {
+				return nil, err
+			}
+			paths[hex.EncodeToString(script)] = keyPath{change: change, index: i}
+		}
+	}
+	return paths, nil
+}
+
+// addKeyInfo adds the derivation information of an address key into
+// a psbt input or output. Multisig addresses carry the derivations of all
+// the cosigners.
+func (c CoinAccount) addKeyInfo(k keyPath, pub *address.PublicKey, redeemScript, witnessScript *[]byte,
+	derivations *[]*psbt.Bip32Derivation, taprootKey *[]byte, taprootDerivations *[]*psbt.TaprootBip32Derivation) error {
+
+	if c.multisig != nil {
+		return c.addMultisigKeyInfo(k, redeemScript, witnessScript, derivations)
+	}
+
+	if c.Purpose == BIP86 {
+		internalKey, err := btcec.ParsePubKey(pub.SerializeCompressed())
+		if err != nil {
+			return err
+		}
+		xOnlyKey := schnorr.SerializePubKey(internalKey)
+		*taprootKey = xOnlyKey
+		*taprootDerivations = append(*taprootDerivations, &psbt.TaprootBip32Derivation{
+			XOnlyPubKey:          xOnlyKey,
+			MasterKeyFingerprint: c.fingerprint,
+			Bip32Path:            c.bip32Path(k),
+		})
+		return nil
+	}
+
+	script, err := c.redeemScript(pub)
+	if err != nil {
+		return err
+	}
+	if script != nil {
+		*redeemScript = script
+	}
+	*derivations = append(*derivations, &psbt.Bip32Derivation{
+		PubKey:               pub.SerializeCompressed(),
+		MasterKeyFingerprint: c.fingerprint,
+		Bip32Path:            c.bip32Path(k),
+	})
+	return nil
+}
+
+// CreatePSBT creates an unsigned PSBT which spends the coins selected for
+// the sends. Each input and the change output carry the BIP32 derivation
+// of the key so that the PSBT can be signed by SignPSBT of this account.
+// The selected coins stay reserved for ReservationTimeout while the PSBT is
+// being signed.
+func (c CoinAccount) CreatePSBT(sends []*tx.Send, customData []byte, fee uint64) (*psbt.Packet, error) {
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	feePerKB := c.feeRate(fee)
+
+	change, err := c.newChangeAddress()
+	if err != nil {
+		return nil, err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(change, paid) }()
+
+	redeemTx, utxos, err := c.prepareSpendTx(customData, sends, change.address, feePerKB)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepareSpendTx signs the transaction for the fee estimation
+	for _, txIn := range redeemTx.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+
+	p, err := psbt.NewFromUnsignedTx(redeemTx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := c.scriptKeyPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, u := range utxos {
+		in := &p.Inputs[i]
+		if txscript.IsWitnessProgram(u.Script) || txscript.IsWitnessProgram(u.RedeemScript) {
+			in.WitnessUtxo = wire.NewTxOut(int64(u.Value), u.Script)
+		}
+		if !txscript.IsPayToTaproot(u.Script) {
+			// the amounts of non-taproot inputs are only proved by the
+			// whole previous transactions
+			prevTx, err := c.getTransaction(u.TxHash)
+			if err != nil {
+				return nil, err
+			}
+			in.NonWitnessUtxo = prevTx
+		}
+		in.SighashType = txscript.SigHashAll
+		if c.Purpose == BIP86 {
+			in.SighashType = txscript.SigHashDefault
+		}
+
+		k, ok := paths[hex.EncodeToString(u.Script)]
+		if !ok {
+			return nil, fmt.Errorf("no key path for the input %d", i)
+		}
+		pub, err := c.addressPubKey(k.index, k.change)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.addKeyInfo(k, pub, &in.RedeemScript, &in.WitnessScript, &in.Bip32Derivation,
+			&in.TaprootInternalKey, &in.TaprootBip32Derivation); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, txOut := range redeemTx.TxOut {
+		k, ok := paths[hex.EncodeToString(txOut.PkScript)]
+		if !ok {
+			continue
+		}
+		pub, err := c.addressPubKey(k.index, k.change)
+		if err != nil {
+			return nil, err
+		}
+		out := &p.Outputs[i]
+		if err := c.addKeyInfo(k, pub, &out.RedeemScript, &out.WitnessScript, &out.Bip32Derivation,
+			&out.TaprootInternalKey, &out.TaprootBip32Derivation); err != nil {
+			return nil, err
+		}
+	}
+
+	c.reservation.keep(txOutPoints(redeemTx))
+	paid = redeemTx
+	return p, nil
+}
+
+// getTransaction fetches a transaction from the agent by its hash
+func (c CoinAccount) getTransaction(txHash []byte) (*wire.MsgTx, error) {
+	h, err := chainhash.NewHash(txHash)
+	if err != nil {
+		return nil, err
+	}
+	rawTx, err := c.agent.GetRawTransaction(h.String())
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	var t wire.MsgTx
+	if err := t.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// psbtPrevOutput returns the output spent by a psbt input. The signatures
+// of legacy and segwit v0 inputs do not commit to the amounts of the other
+// inputs, so a witness utxo alone is only trusted if every input is
+// taproot, and the others are taken from their previous transactions.
+func psbtPrevOutput(p *psbt.Packet, i int) (*wire.TxOut, error) {
+	prevOut, err := psbtInputUtxo(p, i)
+	if err != nil || p.Inputs[i].NonWitnessUtxo != nil {
+		return prevOut, err
+	}
+	for j := range p.Inputs {
+		out, err := psbtInputUtxo(p, j)
+		if err != nil {
+			return nil, err
+		}
+		if !txscript.IsPayToTaproot(out.PkScript) {
+			return nil, fmt.Errorf("%w: input %d", ErrPSBTPrevTx, i)
+		}
+	}
+	return prevOut, nil
+}
+
+// psbtInputUtxo returns the output which a psbt input claims to spend,
+// after checking it against the previous transaction if there is one
+func psbtInputUtxo(p *psbt.Packet, i int) (*wire.TxOut, error) {
+	in := p.Inputs[i]
+	if in.NonWitnessUtxo == nil {
+		if in.WitnessUtxo == nil {
+			return nil, fmt.Errorf("no previous output for the input %d", i)
+		}
+		return in.WitnessUtxo, nil
+	}
+
+	outPoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+	if in.NonWitnessUtxo.TxHash() != outPoint.Hash || int(outPoint.Index) >= len(in.NonWitnessUtxo.TxOut) {
+		return nil, fmt.Errorf("invalid previous transaction of the input %d", i)
+	}
+	prevOut := in.NonWitnessUtxo.TxOut[outPoint.Index]
+	if in.WitnessUtxo != nil &&
+		(in.WitnessUtxo.Value != prevOut.Value || !bytes.Equal(in.WitnessUtxo.PkScript, prevOut.PkScript)) {
+		return nil, fmt.Errorf("%w: input %d", ErrPSBTWitnessUtxo, i)
+	}
+	return prevOut, nil
+}
+
+// psbtSigHashType returns the sighash type to sign a psbt input with. Only
+// the types which sign all the inputs and the outputs are allowed, since
+// the others let the signed inputs be spent to any outputs.
+func psbtSigHashType(p *psbt.Packet, i int, taproot bool) (txscript.SigHashType, error) {
+	switch p.Inputs[i].SighashType {
+	case txscript.SigHashDefault:
+		if taproot {
+			return txscript.SigHashDefault, nil
+		}
+		return txscript.SigHashAll, nil
+	case txscript.SigHashAll:
+		return txscript.SigHashAll, nil
+	}
+	return 0, fmt.Errorf("%w: input %d asks for %v", ErrPSBTSighash, i, p.Inputs[i].SighashType)
+}
+
+// SignPSBT adds the signatures of the inputs owned by the account and
+// returns the number of signed inputs. Inputs are recognised by the BIP32
+// derivations which match the master key fingerprint and the account path.
+// It refuses a PSBT which asks for a sighash type other than SIGHASH_ALL,
+// or SIGHASH_DEFAULT for taproot, or lacks the previous transaction of a
+// non-taproot input.
+func (c CoinAccount) SignPSBT(p *psbt.Packet) (int, error) {
+	if c.IsWatchOnly() {
+		return 0, ErrWatchOnly
+	}
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, txIn := range p.UnsignedTx.TxIn {
+		prevOut, err := psbtPrevOutput(p, i)
+		if err != nil {
+			return 0, err
+		}
+		prevOuts[txIn.PreviousOutPoint] = prevOut
+	}
+	sigHashes := txscript.NewTxSigHashes(p.UnsignedTx, txscript.NewMultiPrevOutFetcher(prevOuts))
+
+	u, err := psbt.NewUpdater(p)
+	if err != nil {
+		return 0, err
+	}
+
+	signed := 0
+	for i, txIn := range p.UnsignedTx.TxIn {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+		prevOut := prevOuts[txIn.PreviousOutPoint]
+		taproot := txscript.IsPayToTaproot(prevOut.PkScript)
+		hashType, err := psbtSigHashType(p, i, taproot)
+		if err != nil {
+			return signed, err
+		}
+
+		if taproot {
+			for _, d := range in.TaprootBip32Derivation {
+				k, ok := c.keyPathOf(d.MasterKeyFingerprint, d.Bip32Path)
+				if !ok {
+					continue
+				}
+				key, err := c.addressKey(k.index, k.change)
+				if err != nil {
+					return signed, err
+				}
+				privKey, pubKey := btcec.PrivKeyFromBytes(key.Serialize())
+				if !bytes.Equal(schnorr.SerializePubKey(pubKey), d.XOnlyPubKey) {
+					continue
+				}
+
+				sig, err := txscript.RawTxInTaprootSignature(p.UnsignedTx, sigHashes, i,
+					prevOut.Value, prevOut.PkScript, []byte{}, hashType, privKey)
+				if err != nil {
+					return signed, err
+				}
+				in.TaprootKeySpendSig = sig
+				signed++
+				break
+			}
+			continue
+		}
+
+		for _, d := range in.Bip32Derivation {
+			k, ok := c.keyPathOf(d.MasterKeyFingerprint, d.Bip32Path)
+			if !ok {
+				continue
+			}
+			key, err := c.addressKey(k.index, k.change)
+			if err != nil {
+				return signed, err
+			}
+			privKey, pubKey := btcec.PrivKeyFromBytes(key.Serialize())
+			if !bytes.Equal(pubKey.SerializeCompressed(), d.PubKey) {
+				continue
+			}
+
+			// the scripts of multisig inputs are signed instead of
+			// the pkScripts
+			var sig []byte
+			switch {
+			case in.WitnessScript != nil:
+				sig, err = txscript.RawTxInWitnessSignature(p.UnsignedTx, sigHashes, i,
+					prevOut.Value, in.WitnessScript, hashType, privKey)
+			case txscript.IsWitnessProgram(prevOut.PkScript):
+				sig, err = txscript.RawTxInWitnessSignature(p.UnsignedTx, sigHashes, i,
+					prevOut.Value, prevOut.PkScript, hashType, privKey)
+			case txscript.IsWitnessProgram(in.RedeemScript):
+				sig, err = txscript.RawTxInWitnessSignature(p.UnsignedTx, sigHashes, i,
+					prevOut.Value, in.RedeemScript, hashType, privKey)
+			case in.RedeemScript != nil:
+				sig, err = txscript.RawTxInSignature(p.UnsignedTx, i, in.RedeemScript, hashType, privKey)
+			default:
+				sig, err = txscript.RawTxInSignature(p.UnsignedTx, i, prevOut.PkScript, hashType, privKey)
+			}
+			if err != nil {
+				return signed, err
+			}
+
+			if _, err := u.Sign(i, sig, d.PubKey, in.RedeemScript, in.WitnessScript); err != nil {
+				return signed, err
+			}
+			signed++
+			break
+		}
+	}
+	return signed, nil
+}
+
+// CombinePSBT merges the signatures and the key information of PSBTs
+// which spend the same unsigned transaction.
+func CombinePSBT(packets ...*psbt.Packet) (*psbt.Packet, error) {
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("no psbt to combine")
+	}
+
+	combined := packets[0]
+	txHash := combined.UnsignedTx.TxHash()
+	for _, p := range packets[1:] {
+		if p.UnsignedTx.TxHash() != txHash ||
+			len(p.Inputs) != len(combined.Inputs) || len(p.Outputs) != len(combined.Outputs) {
+			return nil, ErrPSBTMismatch
+		}
+
+		for i := range p.Inputs {
+			dst, src := &combined.Inputs[i], &p.Inputs[i]
+			if dst.NonWitnessUtxo == nil {
+				dst.NonWitnessUtxo = src.NonWitnessUtxo
+			}
+			if dst.WitnessUtxo == nil {
+				dst.WitnessUtxo = src.WitnessUtxo
+			}
+			if dst.SighashType == 0 {
+				dst.SighashType = src.SighashType
+			}
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = src.RedeemScript
+			}
+			if dst.WitnessScript == nil {
+				dst.WitnessScript = src.WitnessScript
+			}
+			if dst.FinalScriptSig == nil {
+				dst.FinalScriptSig = src.FinalScriptSig
+			}
+			if dst.FinalScriptWitness == nil {
+				dst.FinalScriptWitness = src.FinalScriptWitness
+			}
+			if dst.TaprootKeySpendSig == nil {
+				dst.TaprootKeySpendSig = src.TaprootKeySpendSig
+			}
+			if dst.TaprootInternalKey == nil {
+				dst.TaprootInternalKey = src.TaprootInternalKey
+			}
+
+		PARTIAL_SIGS:
+			for _, s := range src.PartialSigs {
+				for _, d := range dst.PartialSigs {
+					if bytes.Equal(d.PubKey, s.PubKey) {
+						continue PARTIAL_SIGS
+					}
+				}
+				dst.PartialSigs = append(dst.PartialSigs, s)
+			}
+			dst.Bip32Derivation = mergeDerivations(dst.Bip32Derivation, src.Bip32Derivation)
+			dst.TaprootBip32Derivation = mergeTaprootDerivations(dst.TaprootBip32Derivation, src.TaprootBip32Derivation)
+		}
+
+		for i := range p.Outputs {
+			dst, src := &combined.Outputs[i], &p.Outputs[i]
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = src.RedeemScript
+			}
+			if dst.WitnessScript == nil {
+				dst.WitnessScript = src.WitnessScript
+			}
+			if dst.TaprootInternalKey == nil {
+				dst.TaprootInternalKey = src.TaprootInternalKey
+			}
+			dst.Bip32Derivation = mergeDerivations(dst.Bip32Derivation, src.Bip32Derivation)
+			dst.TaprootBip32Derivation = mergeTaprootDerivations(dst.TaprootBip32Derivation, src.TaprootBip32Derivation)
+		}
+	}
+
+	return combined, nil
+}
+
+func mergeDerivations(dst, src []*psbt.Bip32Derivation) []*psbt.Bip32Derivation {
+MERGE:
+	for _, s := range src {
+		for _, d := range dst {
+			if bytes.Equal(d.PubKey, s.PubKey) {
+				continue MERGE
+			}
+		}
+		dst = append(dst, s)
+	}
+	return dst
+}
+
+func mergeTaprootDerivations(dst, src []*psbt.TaprootBip32Derivation) []*psbt.TaprootBip32Derivation {
+MERGE:
+	for _, s := range src {
+		for _, d := range dst {
+			if bytes.Equal(d.XOnlyPubKey, s.XOnlyPubKey) {
+				continue MERGE
+			}
+		}
+		dst = append(dst, s)
+	}
+	return dst
+}
+
+// FinalizePSBT finalizes all the inputs of a PSBT and extracts the
+// signed transaction from it. A multisig input needs the signatures of
+// the required cosigners.
+func FinalizePSBT(p *psbt.Packet) (*wire.MsgTx, error) {
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+		if !trimMultisigSigs(in) {
+			return nil, ErrPSBTIncomplete
+		}
+	}
+
+	if err := psbt.MaybeFinalizeAll(p); err == psbt.ErrNotFinalizable {
+		return nil, ErrPSBTIncomplete
+	} else if err != nil {
+		return nil, err
+	}
+	if !p.IsComplete() {
+		return nil, ErrPSBTIncomplete
+	}
+	return psbt.Extract(p)
+}
+
+// PSBTInput is an input of a PSBT summary
+type PSBTInput struct {
+	TxId    string `json:"txId"`
+	Vout    uint32 `json:"vout"`
+	Address string `json:"address,omitempty"`
+	Amount  uint64 `json:"amount"`
+}
+
+// PSBTOutput is an output of a PSBT summary. Change is true only if the
+// output pays to an address of the account.
+type PSBTOutput struct {
+	Address string `json:"address,omitempty"`
+	Data    string `json:"data,omitempty"`
+	Amount  uint64 `json:"amount"`
+	Change  bool   `json:"change"`
+}
+
+// PSBTSummary describes what a PSBT spends and pays in the terms a person
+// is able to check before signing it
+type PSBTSummary struct {
+	Inputs  []PSBTInput  `json:"inputs"`
+	Outputs []PSBTOutput `json:"outputs"`
+	Fee     uint64       `json:"fee"`
+}
+
+// SummarizePSBT returns the summary of a PSBT. The values are computed from
+// the previous outputs in the PSBT and the change outputs are recognised by
+// deriving their scripts from the account, so that a summary only relies on
+// the data which the signatures commit to. A PSBT which SignPSBT refuses
+// is not summarized either.
+func (c CoinAccount) SummarizePSBT(p *psbt.Packet) (*PSBTSummary, error) {
+	s := &PSBTSummary{}
+
+	var totalIn, totalOut uint64
+	for i, txIn := range p.UnsignedTx.TxIn {
+		prevOut, err := psbtPrevOutput(p, i)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := psbtSigHashType(p, i, txscript.IsPayToTaproot(prevOut.PkScript)); err != nil {
+			return nil, err
+		}
+		totalIn += uint64(prevOut.Value)
+		s.Inputs = append(s.Inputs, PSBTInput{
+			TxId:    txIn.PreviousOutPoint.Hash.String(),
+			Vout:    txIn.PreviousOutPoint.Index,
+			Address: c.scriptAddress(prevOut.PkScript),
+			Amount:  uint64(prevOut.Value),
+		})
+	}
+
+	for i, txOut := range p.UnsignedTx.TxOut {
+		totalOut += uint64(txOut.Value)
+		out := PSBTOutput{
+			Address: c.scriptAddress(txOut.PkScript),
+			Amount:  uint64(txOut.Value),
+		}
+		if txscript.GetScriptClass(txOut.PkScript) == txscript.NullDataTy {
+			pushes, err := txscript.PushedData(txOut.PkScript)
+			if err != nil {
+				return nil, err
+			}
+			out.Data = hex.EncodeToString(bytes.Join(pushes, nil))
+		}
+		change, err := c.isChangeOutput(p.Outputs[i], txOut.PkScript)
+		if err != nil {
+			return nil, err
+		}
+		out.Change = change
+		s.Outputs = append(s.Outputs, out)
+	}
+
+	if totalOut > totalIn {
+		return nil, fmt.Errorf("outputs %d exceed inputs %d", totalOut, totalIn)
+	}
+	s.Fee = totalIn - totalOut
+	return s, nil
+}
+
+// scriptAddress returns the address of a pkScript or an empty string for
+// the scripts without an address
+func (c CoinAccount) scriptAddress(pkScript []byte) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, c.net)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
+
+// isChangeOutput returns true if the derivation of a psbt output leads to
+// its script in the account
+func (c CoinAccount) isChangeOutput(out psbt.POutput, pkScript []byte) (bool, error) {
+	paths := make([]keyPath, 0, len(out.Bip32Derivation)+len(out.TaprootBip32Derivation))
+	for _, d := range out.Bip32Derivation {
+		if k, ok := c.keyPathOf(d.MasterKeyFingerprint, d.Bip32Path); ok {
+			paths = append(paths, k)
+		}
+	}
+	for _, d := range out.TaprootBip32Derivation {
+		if k, ok := c.keyPathOf(d.MasterKeyFingerprint, d.Bip32Path); ok {
+			paths = append(paths, k)
+		}
+	}
+
+	for _, k := range paths {
+		addr, err := c.Address(k.index, k.change)
+		if err != nil {
+			return false, err
+		}
+		script, err := tx.DefaultP2PKScript(addr)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(script, pkScript) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Confirm checks the summary against the payments and the fee which
+// a person confirms. Every output except the change has to be confirmed
+// and the change is not allowed to hide a payment.
+func (s *PSBTSummary) Confirm(sends []*tx.Send, customData []byte, fee uint64) error {
+	if s.Fee != fee {
+		return fmt.Errorf("%w: fee is %d, not %d", ErrPSBTNotConfirmed, s.Fee, fee)
+	}
+
+	confirmed := make([]bool, len(sends))
+	dataConfirmed := len(customData) == 0
+OUTPUTS:
+	for _, out := range s.Outputs {
+		switch {
+		case out.Change:
+			continue OUTPUTS
+		case out.Data != "":
+			if !dataConfirmed && out.Data == hex.EncodeToString(customData) && out.Amount == 0 {
+				dataConfirmed = true
+				continue OUTPUTS
+			}
+		default:
+			for i, send := range sends {
+				if !confirmed[i] && send.Addr == out.Address && send.Amount == out.Amount {
+					confirmed[i] = true
+					continue OUTPUTS
+				}
+			}
+		}
+		return fmt.Errorf("%w: output %s %d", ErrPSBTNotConfirmed, out.Address+out.Data, out.Amount)
+	}
+
+	if !dataConfirmed {
+		return fmt.Errorf("%w: no data output", ErrPSBTNotConfirmed)
+	}
+	for i, send := range sends {
+		if !confirmed[i] {
+			return fmt.Errorf("%w: no output %s %d", ErrPSBTNotConfirmed, send.Addr, send.Amount)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestPSBTSignAndFinalize(t *testing.T) {
+	for _, purpose := range []Purpose{BIP44, BIP49, BIP84, BIP86} {
+		w := newTestWallet(t)
+		account, err := w.Account(purpose, BTC, true, 0)
+		assert.NoError(t, err)
+
+		addr, err := account.Address(0, false)
+		assert.NoError(t, err)
+		script, err := tx.DefaultP2PKScript(addr)
+		assert.NoError(t, err)
+
+		// the previous transaction is required to sign legacy inputs
+		prevTx := wire.NewMsgTx(wire.TxVersion)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+		prevTx.AddTxOut(wire.NewTxOut(100000, script))
+		var buf bytes.Buffer
+		assert.NoError(t, prevTx.Serialize(&buf))
+		prevHash := prevTx.TxHash()
+		account.SetAgent(&fakeAgent{txs: map[string]string{
+			prevHash.String(): hex.EncodeToString(buf.Bytes()),
+		}})
+
+		utxos := tx.UTXOs{{TxHash: prevHash[:], TxIndex: 0, Value: 100000}}
+		assert.NoError(t, account.store.SetUTXO(addr, utxos))
+
+		p, err := account.CreatePSBT([]*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, nil, 2000)
+		assert.NoError(t, err, "purpose %d", purpose)
+		assert.False(t, p.IsComplete())
+		assert.Len(t, p.Outputs, 2)
+
+		// a psbt is exchanged in base64
+		b64, err := p.B64Encode()
+		assert.NoError(t, err)
+		unsigned, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+		assert.NoError(t, err)
+		signing, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+		assert.NoError(t, err)
+
+		_, err = FinalizePSBT(unsigned)
+		assert.Equal(t, ErrPSBTIncomplete, err)
+
+		n, err := account.SignPSBT(signing)
+		assert.NoError(t, err, "purpose %d", purpose)
+		assert.Equal(t, 1, n)
+
+		combined, err := CombinePSBT(unsigned, signing)
+		assert.NoError(t, err)
+
+		signedTx, err := FinalizePSBT(combined)
+		assert.NoError(t, err, "purpose %d", purpose)
+		verifyTx(t, signedTx, tx.UTXOs{{Script: script, Value: 100000}})
+
+		account.Close()
+	}
+}
+
+func TestPSBTSignForeignInput(t *testing.T) {
+	w := newTestWallet(t)
+
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+	other, err := New(append([]byte{1}, w.seed[1:]...), testDataFile(t)).Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer other.Close()
+
+	addr, err := other.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(100000, script))
+	prevHash := prevTx.TxHash()
+
+	unsignedTx := wire.NewMsgTx(wire.TxVersion)
+	unsignedTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	unsignedTx.AddTxOut(wire.NewTxOut(90000, script))
+	p, err := psbt.NewFromUnsignedTx(unsignedTx)
+	assert.NoError(t, err)
+	p.Inputs[0].NonWitnessUtxo = prevTx
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, script)
+	key, err := other.addressKey(0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, other.addKeyInfo(keyPath{index: 0}, key.PublicKey, &p.Inputs[0].RedeemScript,
+		&p.Inputs[0].WitnessScript, &p.Inputs[0].Bip32Derivation, &p.Inputs[0].TaprootInternalKey, &p.Inputs[0].TaprootBip32Derivation))
+
+	n, err := account.SignPSBT(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = other.SignPSBT(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPSBTSignRefusesUnsafeInputs(t *testing.T) {
+	account, _, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	p, err := account.CreatePSBT(sends, nil, 2000)
+	assert.NoError(t, err)
+	b64, err := p.B64Encode()
+	assert.NoError(t, err)
+	decode := func() *psbt.Packet {
+		p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+		assert.NoError(t, err)
+		return p
+	}
+
+	// the signatures which leave the outputs or the other inputs open
+	for _, hashType := range []txscript.SigHashType{txscript.SigHashNone, txscript.SigHashSingle,
+		txscript.SigHashAll | txscript.SigHashAnyOneCanPay} {
+		p := decode()
+		p.Inputs[0].SighashType = hashType
+		n, err := account.SignPSBT(p)
+		assert.True(t, errors.Is(err, ErrPSBTSighash), "sighash %v", hashType)
+		assert.Equal(t, 0, n)
+		assert.Empty(t, p.Inputs[0].PartialSigs)
+	}
+
+	// the amount of a segwit v0 input is only proved by its previous
+	// transaction
+	p = decode()
+	p.Inputs[0].WitnessUtxo.Value = 1000000
+	_, err = account.SignPSBT(p)
+	assert.True(t, errors.Is(err, ErrPSBTWitnessUtxo))
+
+	p = decode()
+	p.Inputs[0].NonWitnessUtxo = nil
+	_, err = account.SignPSBT(p)
+	assert.True(t, errors.Is(err, ErrPSBTPrevTx))
+
+	n, err := account.SignPSBT(decode())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPSBTSummaryConfirm(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 1000, 100000)
+	defer account.Close()
+
+	// only the second output of the previous transaction is spent
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, account.store.SetUTXO(addr, utxos[1:]))
+
+	customData := []byte("bitmark")
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	p, err := account.CreatePSBT(sends, customData, 2000)
+	assert.NoError(t, err)
+
+	s, err := account.SummarizePSBT(p)
+	assert.NoError(t, err)
+	assert.Len(t, s.Inputs, 1)
+	assert.Equal(t, addr, s.Inputs[0].Address)
+	assert.Equal(t, uint64(1), uint64(s.Inputs[0].Vout))
+	assert.Len(t, s.Outputs, 3)
+
+	var change uint64
+	for _, out := range s.Outputs {
+		if out.Change {
+			change = out.Amount
+		}
+	}
+	assert.NotZero(t, change)
+	fee := 100000 - 50000 - change
+	assert.Equal(t, fee, s.Fee)
+
+	assert.NoError(t, s.Confirm(sends, customData, fee))
+
+	err = s.Confirm(sends, customData, fee+1)
+	assert.True(t, errors.Is(err, ErrPSBTNotConfirmed))
+	err = s.Confirm([]*tx.Send{{Addr: sends[0].Addr, Amount: 40000}}, customData, fee)
+	assert.True(t, errors.Is(err, ErrPSBTNotConfirmed))
+	err = s.Confirm(sends, nil, fee)
+	assert.True(t, errors.Is(err, ErrPSBTNotConfirmed))
+	err = s.Confirm(append(sends, &tx.Send{Addr: addr, Amount: change}), customData, fee)
+	assert.True(t, errors.Is(err, ErrPSBTNotConfirmed))
+
+	// an output to a foreign address is not the change even if it claims
+	// a derivation of the account
+	foreignScript, err := tx.DefaultP2PKScript(sends[0].Addr)
+	assert.NoError(t, err)
+	for i, out := range p.UnsignedTx.TxOut {
+		if len(p.Outputs[i].Bip32Derivation) > 0 {
+			out.PkScript = foreignScript
+		}
+	}
+	s, err = account.SummarizePSBT(p)
+	assert.NoError(t, err)
+	for _, out := range s.Outputs {
+		assert.False(t, out.Change)
+	}
+	err = s.Confirm(sends, customData, fee)
+	assert.True(t, errors.Is(err, ErrPSBTNotConfirmed))
+}
+
+func TestPSBTSummaryRefusesUnsafeInputs(t *testing.T) {
+	account, _, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	p, err := account.CreatePSBT(sends, nil, 2000)
+	assert.NoError(t, err)
+	s, err := account.SummarizePSBT(p)
+	assert.NoError(t, err)
+	b64, err := p.B64Encode()
+	assert.NoError(t, err)
+
+	// a psbt is refused before its payments and fee are confirmed if it
+	// asks for SIGHASH_NONE, or fakes the amount of its input to hide
+	// the fee
+	none, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+	assert.NoError(t, err)
+	none.Inputs[0].SighashType = txscript.SigHashNone
+	_, err = account.SummarizePSBT(none)
+	assert.True(t, errors.Is(err, ErrPSBTSighash))
+
+	faked, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+	assert.NoError(t, err)
+	faked.Inputs[0].WitnessUtxo.Value += 10000
+	_, err = account.SummarizePSBT(faked)
+	assert.True(t, errors.Is(err, ErrPSBTWitnessUtxo))
+	faked.Inputs[0].NonWitnessUtxo = nil
+	_, err = account.SummarizePSBT(faked)
+	assert.True(t, errors.Is(err, ErrPSBTPrevTx))
+
+	assert.NoError(t, s.Confirm(sends, nil, s.Fee))
+}
@@ -0,0 +1,345 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+const (
+	// RBFSequence is the largest sequence number which signals the
+	// replaceability of a transaction according to BIP125
+	RBFSequence = wire.MaxTxInSequenceNum - 2
+
+	// IncrementalRelayFee is the fee per kB which a replacement has to pay
+	// for its own size on top of the fee of the replaced transaction
+	IncrementalRelayFee = 1000
+)
+
+var (
+	ErrNotReplaceable = fmt.Errorf("transaction does not signal replaceability")
+	ErrForeignInput   = fmt.Errorf("transaction spends coins not of the account")
+	ErrNothingToSpend = fmt.Errorf("no coin is left for the outputs after the fee")
+	ErrTxConfirmed    = fmt.Errorf("transaction is already confirmed")
+	ErrTxNotInMempool = fmt.Errorf("transaction is not in the mempool")
+)
+
+// signalsRBF returns true if any vin of a transaction signals the
+// replaceability
+func signalsRBF(t *wire.MsgTx) bool {
+	for _, txIn := range t.TxIn {
+		if txIn.Sequence <= RBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceableTx fetches an unconfirmed transaction of the account which
+// signals the replaceability and returns it with the UTXOs which it spends
+func (c CoinAccount) replaceableTx(txId string) (*wire.MsgTx, tx.UTXOs, error) {
+	if c.IsWatchOnly() {
+		return nil, nil, ErrWatchOnly
+	}
+
+	// only the transactions in the mempool are replaced, the others are
+	// rejected by the node after the coins are reserved and signed
+	if record, err := c.store.GetTransaction(txId); err != nil {
+		return nil, nil, err
+	} else if record != nil && record.State == TxConfirmed {
+		return nil, nil, ErrTxConfirmed
+	}
+	if _, err := c.agent.GetMempoolEntry(txId); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrTxNotInMempool, err.Error())
+	}
+
+	h, err := chainhash.NewHashFromStr(txId)
+	if err != nil {
+		return nil, nil, err
+	}
+	original, err := c.getTransaction(h[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !signalsRBF(original) {
+		return nil, nil, ErrNotReplaceable
+	}
+
+	paths, err := c.scriptKeyPaths()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	utxos := make(tx.UTXOs, 0, len(original.TxIn))
+	for _, txIn := range original.TxIn {
+		outPoint := txIn.PreviousOutPoint
+		prevTx, err := c.getTransaction(outPoint.Hash[:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if int(outPoint.Index) >= len(prevTx.TxOut) {
+			return nil, nil, fmt.Errorf("invalid previous output %s", outPoint)
+		}
+
+		u, err := c.ownedUTXO(paths, outPoint, prevTx.TxOut[outPoint.Index])
+		if err != nil {
+			return nil, nil, err
+		}
+		if u == nil {
+			return nil, nil, ErrForeignInput
+		}
+		utxos = append(utxos, u)
+	}
+	return original, utxos, nil
+}
+
+// ownedUTXO returns the UTXO with the signing key of an output if it pays
+// to the account, or nil otherwise
+func (c CoinAccount) ownedUTXO(paths map[string]keyPath, outPoint wire.OutPoint, txOut *wire.TxOut) (*tx.UTXO, error) {
+	k, ok := paths[hex.EncodeToString(txOut.PkScript)]
+	if !ok {
+		return nil, nil
+	}
+	// the key of a multisig account does not spend the coins alone
+	var key *address.PrivateKey
+	if !c.IsMultisig() {
+		var err error
+		key, err = c.addressKey(k.index, k.change)
+		if err != nil {
+			return nil, err
+		}
+	}
+	redeemScript, witnessScript, err := c.addressScripts(k.index, k.change)
+	if err != nil {
+		return nil, err
+	}
+	return &tx.UTXO{
+		Key:           key,
+		TxHash:        outPoint.Hash.CloneBytes(),
+		TxIndex:       outPoint.Index,
+		Value:         uint64(txOut.Value),
+		Script:        txOut.PkScript,
+		RedeemScript:  redeemScript,
+		WitnessScript: witnessScript,
+	}, nil
+}
+
+// txFee returns the fee of a transaction which spends the UTXOs
+func txFee(t *wire.MsgTx, utxos tx.UTXOs) int64 {
+	fee := int64(0)
+	for _, u := range utxos {
+		fee += int64(u.Value)
+	}
+	for _, txOut := range t.TxOut {
+		fee -= txOut.Value
+	}
+	return fee
+}
+
+// BumpFee replaces a transaction of the account which is not confirmed yet
+// by one with the same vins, the same payments and a higher fee. The fee is
+// taken from the change, and more vins are added if the change is not
+// enough. The fee per kB is raised as BIP125 requires if it is not enough
+// for a replacement.
+func (c CoinAccount) BumpFee(txId string, fee uint64) (string, string, error) {
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	original, utxos, err := c.replaceableTx(txId)
+	if err != nil {
+		return "", "", err
+	}
+
+	paths, err := c.scriptKeyPaths()
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemTx := wire.NewMsgTx(original.Version)
+	redeemTx.LockTime = original.LockTime
+	var changePKScript []byte
+	for _, txOut := range original.TxOut {
+		if k, ok := paths[hex.EncodeToString(txOut.PkScript)]; ok && k.change && changePKScript == nil {
+			changePKScript = txOut.PkScript
+			continue
+		}
+		redeemTx.AddTxOut(wire.NewTxOut(txOut.Value, txOut.PkScript))
+	}
+	if changePKScript != nil {
+		return c.replaceTx(original, utxos, redeemTx, changePKScript, fee)
+	}
+	return c.replaceWithChange(original, utxos, redeemTx, fee)
+}
+
+// CancelTx replaces a transaction of the account which is not confirmed
+// yet by one which spends the same vins back to a change address
+func (c CoinAccount) CancelTx(txId string, fee uint64) (string, string, error) {
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	original, utxos, err := c.replaceableTx(txId)
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemTx := wire.NewMsgTx(original.Version)
+	redeemTx.LockTime = original.LockTime
+	return c.replaceWithChange(original, utxos, redeemTx, fee)
+}
+
+// replaceWithChange replaces a transaction as replaceTx does with a new
+// change address, which is given back unless the replacement pays to it
+func (c CoinAccount) replaceWithChange(original *wire.MsgTx, utxos tx.UTXOs, redeemTx *wire.MsgTx,
+	fee uint64) (string, string, error) {
+
+	change, err := c.newChangeAddress()
+	if err != nil {
+		return "", "", err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(change, paid) }()
+
+	txId, rawTx, err := c.replaceTx(original, utxos, redeemTx, change.script, fee)
+	if err != nil {
+		return "", "", err
+	}
+	paid = redeemTx
+	return txId, rawTx, nil
+}
+
+// replaceTx funds and signs the replacement of a transaction and
+// broadcasts it
+func (c CoinAccount) replaceTx(original *wire.MsgTx, utxos tx.UTXOs, redeemTx *wire.MsgTx,
+	changePKScript []byte, fee uint64) (string, string, error) {
+
+	// the transaction is replaced once at a time
+	if err := c.reserveReplaced(utxos); err != nil {
+		return "", "", err
+	}
+	feePerKB := c.feeRate(fee)
+
+	// BIP125 requires a higher fee rate and an absolute fee which pays
+	// for the replacement on top of the replaced fee
+	originalFee := txFee(original, utxos)
+	originalSize := txVirtualSize(original)
+	minFeePerKB := uint64(originalFee*1000/int64(originalSize)) + IncrementalRelayFee
+	if feePerKB < minFeePerKB {
+		feePerKB = minFeePerKB
+	}
+
+	// neither the spent coins nor the outputs of the replaced transaction
+	// are able to fund the replacement
+	excluded := make(map[wire.OutPoint]bool)
+	for _, txIn := range original.TxIn {
+		excluded[txIn.PreviousOutPoint] = true
+	}
+	originalHash := original.TxHash()
+	spendable, err := c.spendableUTXOs()
+	if err != nil {
+		return "", "", err
+	}
+	candidates := make(tx.UTXOs, 0, len(spendable))
+	for _, u := range spendable {
+		h, err := chainhash.NewHash(u.TxHash)
+		if err != nil {
+			return "", "", err
+		}
+		if *h == originalHash || excluded[*wire.NewOutPoint(h, u.TxIndex)] {
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+
+	utxos, err = c.fundTx(redeemTx, utxos, candidates, changePKScript, feePerKB, func(size int) uint64 {
+		fee := feeForSize(size, feePerKB)
+		if minFee := uint64(originalFee) + feeForSize(size, IncrementalRelayFee); fee < minFee {
+			return minFee
+		}
+		return fee
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := c.signTx(utxos, redeemTx); err != nil {
+		return "", "", err
+	}
+	return c.Broadcast(redeemTx)
+}
+
+// fundTx adds the vins of the UTXOs into a transaction and completes it
+// with a change vout. More vins are selected from the candidates if the
+// UTXOs are not enough for the outputs and the fee. The fee of the
+// transaction is given by the function of its virtual size, and the fee per
+// kB prices the vins and the change to add. It returns all the UTXOs the
+// transaction spends.
+func (c CoinAccount) fundTx(redeemTx *wire.MsgTx, utxos, candidates tx.UTXOs,
+	changePKScript []byte, feePerKB uint64, feeFor func(size int) uint64) (tx.UTXOs, error) {
+
+	changeSize := wire.NewTxOut(0, changePKScript).SerializeSize()
+
+	var totalOutputAmount uint64
+	for _, txOut := range redeemTx.TxOut {
+		totalOutputAmount += uint64(txOut.Value)
+	}
+
+	for {
+		var err error
+		redeemTx.TxIn, err = newTxIns(utxos)
+		if err != nil {
+			return nil, err
+		}
+		var totalInputAmount uint64
+		for _, u := range utxos {
+			totalInputAmount += u.Value
+		}
+		if err := c.signForSize(utxos, redeemTx); err != nil {
+			return nil, err
+		}
+
+		// the size with a change vout
+		fee := feeFor(txVirtualSize(redeemTx) + changeSize)
+
+		if totalInputAmount >= totalOutputAmount+fee {
+			// add the change vout as the first item only when the change
+			// is greater than the fee of the extra size which it takes
+			change := totalInputAmount - totalOutputAmount - fee
+			if change > feeForSize(changeSize, feePerKB) {
+				changeTxOut := wire.NewTxOut(int64(change), changePKScript)
+				redeemTx.TxOut = append([]*wire.TxOut{changeTxOut}, redeemTx.TxOut...)
+			}
+			if len(redeemTx.TxOut) == 0 {
+				return nil, ErrNothingToSpend
+			}
+			return utxos, nil
+		}
+
+		more, _, err := c.selectFrom(candidates, SelectionTarget{
+			Amount:     totalOutputAmount + fee - totalInputAmount,
+			InputFee:   feeForSize(c.inputVirtualSize(), feePerKB),
+			ChangeCost: feeForSize(changeSize, feePerKB),
+		})
+		if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, more...)
+
+		// the selected coins are not candidates any more
+		selected := make(map[*tx.UTXO]bool)
+		for _, u := range more {
+			selected[u] = true
+		}
+		remaining := make(tx.UTXOs, 0, len(candidates))
+		for _, u := range candidates {
+			if !selected[u] {
+				remaining = append(remaining, u)
+			}
+		}
+		candidates = remaining
+	}
+}
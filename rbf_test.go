@@ -0,0 +1,144 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/agent"
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestBumpFee(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000, 30000)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	original := deserializeTx(t, rawTx)
+	assert.True(t, signalsRBF(original))
+	assert.Len(t, original.TxIn, 1)
+	a.txs[txId] = rawTx
+	a.mempool = map[string]*agent.MempoolEntry{txId: {}}
+
+	// the store is not synced yet and still lists the spent coin
+	bumpedId, bumpedRaw, err := account.BumpFee(txId, 5000)
+	assert.NoError(t, err)
+	assert.NotEqual(t, txId, bumpedId)
+	bumped := deserializeTx(t, bumpedRaw)
+
+	assert.Len(t, bumped.TxIn, 1)
+	assert.Equal(t, original.TxIn[0].PreviousOutPoint, bumped.TxIn[0].PreviousOutPoint)
+	assert.Len(t, bumped.TxOut, 2)
+	assert.Equal(t, original.TxOut[1], bumped.TxOut[1])
+	// the change is reused and pays the higher fee
+	assert.Equal(t, original.TxOut[0].PkScript, bumped.TxOut[0].PkScript)
+	assert.True(t, original.TxOut[0].Value > bumped.TxOut[0].Value)
+
+	originalFee := txFee(original, utxos[:1])
+	bumpedFee := txFee(bumped, utxos[:1])
+	assert.True(t, bumpedFee >= int64(txVirtualSize(bumped))*5)
+	assert.True(t, bumpedFee >= originalFee+int64(txVirtualSize(bumped)))
+	verifyTx(t, bumped, utxos[:1])
+}
+
+func TestBumpFeeAddInputs(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 50150, 30000)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	original := deserializeTx(t, rawTx)
+	assert.Len(t, original.TxIn, 1)
+	assert.Len(t, original.TxOut, 1)
+	a.txs[txId] = rawTx
+	a.mempool = map[string]*agent.MempoolEntry{txId: {}}
+
+	_, bumpedRaw, err := account.BumpFee(txId, 20000)
+	assert.NoError(t, err)
+	bumped := deserializeTx(t, bumpedRaw)
+
+	assert.Len(t, bumped.TxIn, 2)
+	assert.Equal(t, original.TxIn[0].PreviousOutPoint, bumped.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, original.TxOut[0], bumped.TxOut[1])
+	assert.True(t, txFee(bumped, utxos) >= int64(txVirtualSize(bumped))*20)
+	verifyTx(t, bumped, utxos)
+}
+
+func TestCancelTx(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, []byte("bitmark"), 1000)
+	assert.NoError(t, err)
+	original := deserializeTx(t, rawTx)
+	a.txs[txId] = rawTx
+	a.mempool = map[string]*agent.MempoolEntry{txId: {}}
+
+	_, cancelRaw, err := account.CancelTx(txId, 0)
+	assert.NoError(t, err)
+	cancel := deserializeTx(t, cancelRaw)
+
+	assert.Len(t, cancel.TxIn, 1)
+	assert.Equal(t, original.TxIn[0].PreviousOutPoint, cancel.TxIn[0].PreviousOutPoint)
+	assert.Len(t, cancel.TxOut, 1)
+	// the original change address is not handed out again
+	changeAddr, err := account.Address(1, true)
+	assert.NoError(t, err)
+	changeScript, err := tx.DefaultP2PKScript(changeAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, changeScript, cancel.TxOut[0].PkScript)
+	assert.True(t, txFee(cancel, utxos) >= txFee(original, utxos)+int64(txVirtualSize(cancel)))
+	verifyTx(t, cancel, utxos)
+}
+
+func TestReplaceNonSignallingTx(t *testing.T) {
+	account, a, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	_, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	original := deserializeTx(t, rawTx)
+	for _, txIn := range original.TxIn {
+		txIn.Sequence = wire.MaxTxInSequenceNum
+	}
+	a.txs[original.TxHash().String()] = serializeTx(t, original)
+	a.mempool = map[string]*agent.MempoolEntry{original.TxHash().String(): {}}
+
+	_, _, err = account.BumpFee(original.TxHash().String(), 5000)
+	assert.Equal(t, ErrNotReplaceable, err)
+	_, _, err = account.CancelTx(original.TxHash().String(), 5000)
+	assert.Equal(t, ErrNotReplaceable, err)
+}
+
+func TestReplaceConfirmedTx(t *testing.T) {
+	account, a, _ := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	txId, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	a.txs[txId] = rawTx
+
+	// the transaction has left the mempool, and nothing is signed
+	_, _, err = account.BumpFee(txId, 5000)
+	assert.True(t, errors.Is(err, ErrTxNotInMempool))
+	_, _, err = account.CancelTx(txId, 5000)
+	assert.True(t, errors.Is(err, ErrTxNotInMempool))
+	assert.Len(t, a.sent, 1)
+
+	record, err := account.GetTransaction(txId)
+	assert.NoError(t, err)
+	record.State = TxConfirmed
+	assert.NoError(t, account.store.SetTransaction(record))
+	_, _, err = account.BumpFee(txId, 5000)
+	assert.Equal(t, ErrTxConfirmed, err)
+}
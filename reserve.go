@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrReservedUTXO = fmt.Errorf("utxo is reserved by another transaction")
+)
+
+// ReservationTimeout is how long the coins selected for a transaction stay
+// reserved. They are released as soon as the transaction fails, and once it
+// is broadcast, the pending coins keep them from being spent again.
+const ReservationTimeout = 10 * time.Minute
+
+// reservations are the coins of an account which the transactions in
+// progress have selected. They are shared by the copies of the account, so
+// that two transactions built at the same time never spend the same coin.
+type reservations struct {
+	sync.Mutex
+	coins map[wire.OutPoint]*reserved
+}
+
+type reserved struct {
+	holder *reservation
+	expiry time.Time
+}
+
+func newReservations() *reservations {
+	return &reservations{coins: make(map[wire.OutPoint]*reserved)}
+}
+
+// begin starts a reservation for a transaction
+func (r *reservations) begin() *reservation {
+	if r == nil {
+		return nil
+	}
+	return &reservation{reservations: r}
+}
+
+// reservedBy returns the reservation which holds a coin, or nil if the
+// coin is free. The expired reservations are dropped. It is called with the
+// lock held.
+func (r *reservations) reservedBy(op wire.OutPoint, now time.Time) *reservation {
+	res, ok := r.coins[op]
+	if !ok {
+		return nil
+	}
+	if now.After(res.expiry) {
+		delete(r.coins, op)
+		return nil
+	}
+	return res.holder
+}
+
+// reservation is the coins reserved for a transaction in progress. A nil
+// reservation reserves nothing.
+type reservation struct {
+	*reservations
+	// kept is true once the transaction is done with its coins
+	kept bool
+}
+
+// available returns the UTXOs which are not reserved by the other
+// transactions
+func (h *reservation) available(utxos tx.UTXOs) tx.UTXOs {
+	if h == nil {
+		return utxos
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	free := make(tx.UTXOs, 0, len(utxos))
+	for _, u := range utxos {
+		if holder := h.reservedBy(utxoOutPoint(u), now); holder == nil || holder == h {
+			free = append(free, u)
+		}
+	}
+	return free
+}
+
+// reserve reserves the UTXOs for the transaction. None of them is reserved
+// if any is held by another transaction, and those are returned. A
+// replacement takes over the coins of the broadcast transactions.
+func (h *reservation) reserve(utxos tx.UTXOs, replace bool) map[wire.OutPoint]bool {
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	conflicts := make(map[wire.OutPoint]bool)
+	for _, u := range utxos {
+		op := utxoOutPoint(u)
+		holder := h.reservedBy(op, now)
+		if holder != nil && holder != h && !(replace && holder.kept) {
+			conflicts[op] = true
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts
+	}
+
+	expiry := now.Add(ReservationTimeout)
+	for _, u := range utxos {
+		h.coins[utxoOutPoint(u)] = &reserved{holder: h, expiry: expiry}
+	}
+	return nil
+}
+
+// keep releases the reserved coins which the transaction does not spend,
+// and keeps the others until they expire. It is called when the
+// transaction is broadcast or handed out to be signed.
+func (h *reservation) keep(outpoints []wire.OutPoint) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	spent := make(map[wire.OutPoint]bool, len(outpoints))
+	for _, op := range outpoints {
+		spent[op] = true
+	}
+	for op, res := range h.coins {
+		if res.holder == h && !spent[op] {
+			delete(h.coins, op)
+		}
+	}
+	h.kept = true
+}
+
+// release releases all the reserved coins of a transaction which fails. It
+// does nothing once the coins are kept.
+func (h *reservation) release() {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	if h.kept {
+		return
+	}
+	for op, res := range h.coins {
+		if res.holder == h {
+			delete(h.coins, op)
+		}
+	}
+}
+
+// reserveAll reserves all the UTXOs for the transaction of the account
+func (c CoinAccount) reserveAll(utxos tx.UTXOs) error {
+	if conflicts := c.reservation.reserve(utxos, false); len(conflicts) > 0 {
+		return ErrReservedUTXO
+	}
+	return nil
+}
+
+// reserveReplaced reserves the UTXOs of a transaction for its replacement
+func (c CoinAccount) reserveReplaced(utxos tx.UTXOs) error {
+	if conflicts := c.reservation.reserve(utxos, true); len(conflicts) > 0 {
+		return ErrReservedUTXO
+	}
+	return nil
+}
+
+// txOutPoints returns the coins which a transaction spends
+func txOutPoints(t *wire.MsgTx) []wire.OutPoint {
+	outpoints := make([]wire.OutPoint, 0, len(t.TxIn))
+	for _, txIn := range t.TxIn {
+		outpoints = append(outpoints, txIn.PreviousOutPoint)
+	}
+	return outpoints
+}
@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestReservation(t *testing.T) {
+	r := newReservations()
+	a := r.begin()
+	b := r.begin()
+	utxos := tx.UTXOs{testUTXO(1, 1000, 1, ""), testUTXO(2, 2000, 1, ""), testUTXO(3, 3000, 1, "")}
+
+	assert.Empty(t, a.reserve(utxos[:2], false))
+	// a transaction reserves its own coins again
+	assert.Empty(t, a.reserve(utxos[1:2], false))
+	assert.Equal(t, utxos, a.available(utxos))
+	assert.Equal(t, utxos[2:], b.available(utxos))
+
+	// none is reserved if any is held by another transaction
+	assert.Equal(t, map[wire.OutPoint]bool{utxoOutPoint(utxos[1]): true}, b.reserve(utxos[1:], false))
+	assert.Equal(t, utxos[2:], r.begin().available(utxos))
+
+	// the coins of a failed transaction are released
+	a.release()
+	assert.Equal(t, utxos, b.available(utxos))
+
+	// the spent coins of a broadcast transaction are kept until they expire
+	assert.Empty(t, a.reserve(utxos[:2], false))
+	a.keep([]wire.OutPoint{utxoOutPoint(utxos[0])})
+	a.release()
+	assert.Equal(t, utxos[1:], b.available(utxos))
+	assert.NotEmpty(t, b.reserve(utxos[:1], false))
+	r.coins[utxoOutPoint(utxos[0])].expiry = time.Now().Add(-time.Second)
+	assert.Equal(t, utxos, b.available(utxos))
+
+	// a replacement takes over the coins of a broadcast transaction
+	assert.Empty(t, a.reserve(utxos[:1], false))
+	a.keep([]wire.OutPoint{utxoOutPoint(utxos[0])})
+	assert.NotEmpty(t, b.reserve(utxos[:1], false))
+	assert.Empty(t, b.reserve(utxos[:1], true))
+	assert.Equal(t, utxos[1:], a.available(utxos))
+
+	var none *reservation
+	assert.Empty(t, none.reserve(utxos, false))
+	assert.Equal(t, utxos, none.available(utxos))
+}
+
+func TestConcurrentSends(t *testing.T) {
+	values := make([]int64, 16)
+	for i := range values {
+		values[i] = 100000
+	}
+	account, a, _ := newTestAccount(t, values...)
+	defer account.Close()
+	account.SetCoinSelector(LargestFirst{})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	spent := make(map[wire.OutPoint]int)
+	addSpent := func(t *testing.T, redeemTx *wire.MsgTx) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, txIn := range redeemTx.TxIn {
+			spent[txIn.PreviousOutPoint]++
+		}
+	}
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 60000}}
+			if i%4 == 0 {
+				p, err := account.CreatePSBT(sends, nil, 1000)
+				if assert.NoError(t, err) {
+					addSpent(t, p.UnsignedTx)
+				}
+				return
+			}
+			_, rawTx, err := account.Send(sends, nil, 1000)
+			if assert.NoError(t, err) {
+				addSpent(t, deserializeTx(t, rawTx))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, a.sent, 9)
+	for op, n := range spent {
+		assert.Equal(t, 1, n, fmt.Sprintf("%s is spent %d times", op, n))
+	}
+}
+
+func TestReleaseOnFailure(t *testing.T) {
+	account, a, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+	a.sendErr = fmt.Errorf("rejected")
+	_, _, err := account.Send(sends, nil, 1000)
+	assert.Equal(t, a.sendErr, err)
+	assert.Empty(t, account.reservations.coins)
+
+	a.sendErr = nil
+	_, rawTx, err := account.Send(sends, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+	assert.Equal(t, utxoOutPoint(utxos[0]), sent.TxIn[0].PreviousOutPoint)
+
+	// the coins of a PSBT stay reserved while it is being signed
+	account, _, utxos = newTestAccount(t, 100000)
+	defer account.Close()
+	_, err = account.CreatePSBT(sends, nil, 1000)
+	assert.NoError(t, err)
+	_, _, err = account.Send(sends, nil, 1000)
+	assert.Equal(t, ErrNotEnoughCoin, err)
+	_, _, err = account.SendFrom([]wire.OutPoint{utxoOutPoint(utxos[0])}, sends, nil, 1000)
+	assert.Equal(t, ErrReservedUTXO, err)
+}
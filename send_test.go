@@ -0,0 +1,75 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestSendSubtractFee(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 60000, 40000)
+	defer account.Close()
+
+	// the whole balance is sent
+	_, rawTx, err := account.Send([]*tx.Send{
+		{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 100000, SubtractFee: true},
+	}, nil, 2000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+
+	assert.Len(t, sent.TxIn, 2)
+	assert.Len(t, sent.TxOut, 1)
+	fee := feeForSize(txVirtualSize(sent), 2000)
+	assert.Equal(t, int64(100000-fee), sent.TxOut[0].Value)
+	verifyTx(t, sent, utxos)
+}
+
+func TestSendSubtractFeeSplit(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	_, rawTx, err := account.Send([]*tx.Send{
+		{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 20000, SubtractFee: true},
+		{Addr: "mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb", Amount: 30000},
+		{Addr: "n2eMqTT929pb1RDNuqEnxdaLau1rxy3efi", Amount: 10000, SubtractFee: true},
+	}, nil, 3000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+
+	// the change is the first vout
+	assert.Len(t, sent.TxOut, 4)
+	fee := uint64(txFee(sent, utxos))
+	assert.Equal(t, feeForSize(txVirtualSize(sent), 3000), fee)
+	assert.Equal(t, int64(100000-60000), sent.TxOut[0].Value)
+	assert.Equal(t, int64(20000-fee/2-fee%2), sent.TxOut[1].Value)
+	assert.Equal(t, int64(30000), sent.TxOut[2].Value)
+	assert.Equal(t, int64(10000-fee/2), sent.TxOut[3].Value)
+	verifyTx(t, sent, utxos)
+
+	_, _, err = account.Send([]*tx.Send{
+		{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 100, SubtractFee: true},
+	}, nil, 3000)
+	assert.Equal(t, ErrAmountTooSmall, err)
+}
+
+func TestSendAll(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 60000, 40000, 300)
+	defer account.Close()
+
+	_, _, err := account.SendAll("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", nil, 1000000)
+	assert.Equal(t, ErrAmountTooSmall, err)
+
+	_, rawTx, err := account.SendAll("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", []byte("bitmark"), 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+
+	assert.Len(t, sent.TxIn, 3)
+	assert.Len(t, sent.TxOut, 2)
+	// the signatures may be a byte shorter than the ones the fee is for
+	fee := uint64(txFee(sent, utxos))
+	assert.True(t, fee >= feeForSize(txVirtualSize(sent), 1000))
+	assert.True(t, fee <= feeForSize(txVirtualSize(sent)+1, 1000))
+	verifyTx(t, sent, utxos)
+}
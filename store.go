@@ -2,10 +2,13 @@ package wallet
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bitmark-inc/bitmark-wallet/tx"
 	"github.com/boltdb/bolt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 
 	"github.com/bitmark-inc/bitmarkd/util"
 )
@@ -15,6 +18,11 @@ var (
 	ErrUTXOBucketNotExisted    = fmt.Errorf("utxo bucket is not existed")
 )
 
+// utxoFormatConfirmations marks the packed UTXOs which carry the number
+// of confirmations. The legacy format starts with the length of a tx hash
+// which is never this value.
+const utxoFormatConfirmations = 0x01
+
 func packUTXOs(utxos tx.UTXOs) []byte {
 	b := make([]byte, 0)
 	for _, utxo := range utxos {
@@ -26,38 +34,80 @@ func packUTXOs(utxos tx.UTXOs) []byte {
 		b = append(b, utxo.TxHash...)
 		b = append(b, util.ToVarint64(uint64(utxo.TxIndex))...)
 		b = append(b, util.ToVarint64(utxo.Value)...)
+		b = append(b, util.ToVarint64(utxo.Confirmations)...)
 	}
-	return b
+	if len(b) == 0 {
+		return b
+	}
+	return append([]byte{utxoFormatConfirmations}, b...)
 }
 
 func unpackUTXOs(b []byte) tx.UTXOs {
 	utxos := make([]*tx.UTXO, 0)
+	withConfirmations := len(b) > 0 && b[0] == utxoFormatConfirmations
 	offset := 0
+	if withConfirmations {
+		offset = 1
+	}
 	for offset < len(b) {
 		txLen, txStart := util.FromVarint64(b[offset:])
 		txEnd := txStart + int(txLen)
-		txHash := b[offset+txStart : offset+txEnd]
+		// copy the hash since the value is only valid in its transaction
+		txHash := append([]byte{}, b[offset+txStart:offset+txEnd]...)
 		offset += txEnd
 		txIndex, n := util.FromVarint64(b[offset:])
 		offset += n
 		val, n := util.FromVarint64(b[offset:])
+		offset += n
+		var confirmations uint64
+		if withConfirmations {
+			confirmations, n = util.FromVarint64(b[offset:])
+			offset += n
+		}
 		utxos = append(utxos, &tx.UTXO{
-			TxHash:  txHash,
-			TxIndex: uint32(txIndex),
-			Value:   val,
+			TxHash:        txHash,
+			TxIndex:       uint32(txIndex),
+			Value:         val,
+			Confirmations: confirmations,
 		})
-		offset += n
 	}
 
 	return utxos
 }
 
 type AccountStore interface {
-	GetLastIndex() (uint64, error)
-	SetLastIndex(uint64) error
+	GetChainIndex(change bool) (ChainIndex, error)
+	SetUsedIndex(change bool, used uint32) error
+	SetScannedIndex(change bool, scanned uint32) error
+	IssueIndex(change bool) (uint32, error)
+	ReleaseIndex(change bool, index uint32, address string) error
+	GetIssuedAddresses() ([]*IssuedAddress, error)
+	GetIssuedAddress(address string) (*IssuedAddress, error)
+	SetIssuedAddress(a *IssuedAddress) error
+	GetGapLimit() (uint32, error)
+	SetGapLimit(gap uint32) error
+	GetSyncState() (SyncState, error)
+	SetSyncState(s SyncState) error
 	GetAllUTXO() (map[string]tx.UTXOs, error)
 	GetUTXO(address string) (tx.UTXOs, error)
 	SetUTXO(address string, utxo tx.UTXOs) error
+	GetTimelockAddresses() ([]*TimelockAddress, error)
+	SetTimelockAddress(a *TimelockAddress) error
+	GetLockedOutpoints() ([]wire.OutPoint, error)
+	LockOutpoint(op wire.OutPoint) error
+	UnlockOutpoint(op wire.OutPoint) error
+	GetLabels() ([]*Label, error)
+	GetLabel(t LabelType, ref string) (*Label, error)
+	SetLabel(l *Label) error
+	DeleteLabel(t LabelType, ref string) error
+	GetTransactions() ([]*Transaction, error)
+	GetTransaction(txId string) (*Transaction, error)
+	SetTransaction(t *Transaction) error
+	GetForeignTransaction(txId string) (uint32, bool, error)
+	SetForeignTransaction(txId string, owned uint32) error
+	GetPendingOutPoints() ([]*PendingOutPoint, error)
+	SetPendingOutPoint(p *PendingOutPoint) error
+	DeletePendingOutPoint(op wire.OutPoint) error
 	Close()
 }
 
@@ -66,7 +116,25 @@ type AccountStore interface {
 // + bucket (pubkey of coin_account)
 //   + bucket ("utxo")
 //     - address : txs
-//   - lastIndex : varint
+//   + bucket ("timelock")
+//     - address : kind, value, index in varints
+//   + bucket ("locked")
+//     - tx hash, vout in varint : empty
+//   + bucket ("label")
+//     - type:ref : length of label in varint, label, origin
+//   + bucket ("history")
+//     - txid : transaction record, see packTransaction
+//   + bucket ("foreign")
+//     - txid : the number of the owned addresses it is checked against in varint
+//   + bucket ("pending")
+//     - tx hash, vout in varint : pending coin, see packPendingOutPoint
+//   + bucket ("issued")
+//     - address : change, index, time in varints
+//   - externalIndex : issued, used, scanned in varints
+//   - changeIndex : issued, used, scanned in varints
+//   - gapLimit : varint
+//   - syncState : time, height in varints
+//   - lastIndex : varint, the index of both chains before they are separated
 type BoltAccountStore struct {
 	account string
 	db      *bolt.DB
@@ -76,24 +144,270 @@ func (b BoltAccountStore) Close() {
 	b.db.Close()
 }
 
-func (b BoltAccountStore) GetLastIndex() (uint64, error) {
-	var buf []byte
+// chainIndexKey returns the key of the indexes of a chain
+func chainIndexKey(change bool) []byte {
+	if change {
+		return []byte("changeIndex")
+	}
+	return []byte("externalIndex")
+}
+
+// readChainIndex reads the indexes of a chain. An account which only has
+// the last index of both chains has handed out the external address after
+// it, and treats the addresses up to it as used.
+func readChainIndex(bucket *bolt.Bucket, change bool) ChainIndex {
+	if v := bucket.Get(chainIndexKey(change)); v != nil {
+		issued, n := util.FromVarint64(v)
+		used, m := util.FromVarint64(v[n:])
+		scanned, _ := util.FromVarint64(v[n+m:])
+		return ChainIndex{Issued: uint32(issued), Used: uint32(used), Scanned: uint32(scanned)}
+	}
+
+	v := bucket.Get([]byte("lastIndex"))
+	if v == nil {
+		return ChainIndex{}
+	}
+	lastIndex, _ := util.FromVarint64(v)
+	index := ChainIndex{Issued: uint32(lastIndex) + 1, Used: uint32(lastIndex) + 1}
+	if !change {
+		index.Issued += 1
+	}
+	return index
+}
+
+func writeChainIndex(bucket *bolt.Bucket, change bool, index ChainIndex) error {
+	v := util.ToVarint64(uint64(index.Issued))
+	v = append(v, util.ToVarint64(uint64(index.Used))...)
+	v = append(v, util.ToVarint64(uint64(index.Scanned))...)
+	return bucket.Put(chainIndexKey(change), v)
+}
+
+func (b BoltAccountStore) GetChainIndex(change bool) (ChainIndex, error) {
+	var index ChainIndex
 	if err := b.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(b.account))
-		buf = bucket.Get([]byte("lastIndex"))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		index = readChainIndex(bucket, change)
 		return nil
 	}); err != nil {
-		return 0, err
+		return ChainIndex{}, err
 	}
-	index, _ := util.FromVarint64(buf)
 	return index, nil
 }
 
-func (b BoltAccountStore) SetLastIndex(index uint64) error {
-	buf := util.ToVarint64(index)
+// SetUsedIndex raises the number of the used addresses of a chain. It is
+// never lowered so that an address is not handed out again.
+func (b BoltAccountStore) SetUsedIndex(change bool, used uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		index := readChainIndex(bucket, change)
+		if used > index.Used {
+			index.Used = used
+		}
+		return writeChainIndex(bucket, change, index)
+	})
+}
+
+// SetScannedIndex sets the number of the addresses of a chain which
+// Discover has scanned
+func (b BoltAccountStore) SetScannedIndex(change bool, scanned uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		index := readChainIndex(bucket, change)
+		index.Scanned = scanned
+		return writeChainIndex(bucket, change, index)
+	})
+}
+
+// IssueIndex returns the index of the next address of a chain which is
+// neither handed out nor used, and counts it as issued
+func (b BoltAccountStore) IssueIndex(change bool) (uint32, error) {
+	var next uint32
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		index := readChainIndex(bucket, change)
+		if index.Issued < index.Used {
+			next = index.Used
+		} else {
+			next = index.Issued
+		}
+		index.Issued = next + 1
+		return writeChainIndex(bucket, change, index)
+	}); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// ReleaseIndex gives back the index of the address which IssueIndex has
+// issued last and which is not used, so that it is issued again. It does
+// nothing once another address of the chain is issued after it.
+func (b BoltAccountStore) ReleaseIndex(change bool, i uint32, address string) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(b.account))
-		return bucket.Put([]byte("lastIndex"), buf)
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		index := readChainIndex(bucket, change)
+		if index.Issued != i+1 || i < index.Used {
+			return nil
+		}
+		index.Issued = i
+		if err := writeChainIndex(bucket, change, index); err != nil {
+			return err
+		}
+		if issuedBkt := bucket.Bucket([]byte("issued")); issuedBkt != nil {
+			return issuedBkt.Delete([]byte(address))
+		}
+		return nil
+	})
+}
+
+func unpackIssuedAddress(address, v []byte) *IssuedAddress {
+	change, n := util.FromVarint64(v)
+	index, m := util.FromVarint64(v[n:])
+	t, _ := util.FromVarint64(v[n+m:])
+	return &IssuedAddress{
+		Address: string(address),
+		Change:  change == 1,
+		Index:   uint32(index),
+		Time:    time.Unix(int64(t), 0),
+	}
+}
+
+func (b BoltAccountStore) GetIssuedAddresses() ([]*IssuedAddress, error) {
+	addrs := make([]*IssuedAddress, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		issuedBkt := bucket.Bucket([]byte("issued"))
+		if issuedBkt == nil {
+			return nil
+		}
+
+		return issuedBkt.ForEach(func(address, v []byte) error {
+			addrs = append(addrs, unpackIssuedAddress(address, v))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func (b BoltAccountStore) GetIssuedAddress(address string) (*IssuedAddress, error) {
+	var a *IssuedAddress
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		issuedBkt := bucket.Bucket([]byte("issued"))
+		if issuedBkt == nil {
+			return nil
+		}
+		if v := issuedBkt.Get([]byte(address)); v != nil {
+			a = unpackIssuedAddress([]byte(address), v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (b BoltAccountStore) SetIssuedAddress(a *IssuedAddress) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		issuedBkt, err := bucket.CreateBucketIfNotExists([]byte("issued"))
+		if err != nil {
+			return err
+		}
+
+		var change uint64
+		if a.Change {
+			change = 1
+		}
+		v := util.ToVarint64(change)
+		v = append(v, util.ToVarint64(uint64(a.Index))...)
+		v = append(v, util.ToVarint64(uint64(a.Time.Unix()))...)
+		return issuedBkt.Put([]byte(a.Address), v)
+	})
+}
+
+// GetGapLimit returns the gap limit of the account, or zero if it is not
+// set
+func (b BoltAccountStore) GetGapLimit() (uint32, error) {
+	var gap uint64
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		gap, _ = util.FromVarint64(bucket.Get([]byte("gapLimit")))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return uint32(gap), nil
+}
+
+func (b BoltAccountStore) SetGapLimit(gap uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		return bucket.Put([]byte("gapLimit"), util.ToVarint64(uint64(gap)))
+	})
+}
+
+func (b BoltAccountStore) GetSyncState() (SyncState, error) {
+	var s SyncState
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		v := bucket.Get([]byte("syncState"))
+		if v == nil {
+			return nil
+		}
+		t, n := util.FromVarint64(v)
+		height, _ := util.FromVarint64(v[n:])
+		s = SyncState{Time: time.Unix(int64(t), 0), Height: height}
+		return nil
+	}); err != nil {
+		return SyncState{}, err
+	}
+	return s, nil
+}
+
+func (b BoltAccountStore) SetSyncState(s SyncState) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		v := util.ToVarint64(uint64(s.Time.Unix()))
+		v = append(v, util.ToVarint64(s.Height)...)
+		return bucket.Put([]byte("syncState"), v)
 	})
 }
 
@@ -161,6 +475,478 @@ func (b BoltAccountStore) SetUTXO(address string, utxos tx.UTXOs) error {
 	return nil
 }
 
+func (b BoltAccountStore) GetTimelockAddresses() ([]*TimelockAddress, error) {
+	addrs := make([]*TimelockAddress, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		timelockBkt := bucket.Bucket([]byte("timelock"))
+		if timelockBkt == nil {
+			return nil
+		}
+
+		return timelockBkt.ForEach(func(address, v []byte) error {
+			kind, n := util.FromVarint64(v)
+			value, m := util.FromVarint64(v[n:])
+			index, _ := util.FromVarint64(v[n+m:])
+			addrs = append(addrs, &TimelockAddress{
+				Address: string(address),
+				Lock:    Timelock{Kind: TimelockKind(kind), Value: uint32(value)},
+				Index:   uint32(index),
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func (b BoltAccountStore) SetTimelockAddress(a *TimelockAddress) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		timelockBkt, err := bucket.CreateBucketIfNotExists([]byte("timelock"))
+		if err != nil {
+			return err
+		}
+
+		v := util.ToVarint64(uint64(a.Lock.Kind))
+		v = append(v, util.ToVarint64(uint64(a.Lock.Value))...)
+		v = append(v, util.ToVarint64(uint64(a.Index))...)
+		return timelockBkt.Put([]byte(a.Address), v)
+	})
+}
+
+// outpointKey returns the key of an outpoint in the locked and the pending
+// buckets
+func outpointKey(op wire.OutPoint) []byte {
+	return append(op.Hash.CloneBytes(), util.ToVarint64(uint64(op.Index))...)
+}
+
+func (b BoltAccountStore) GetLockedOutpoints() ([]wire.OutPoint, error) {
+	outpoints := make([]wire.OutPoint, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		lockedBkt := bucket.Bucket([]byte("locked"))
+		if lockedBkt == nil {
+			return nil
+		}
+
+		return lockedBkt.ForEach(func(k, _ []byte) error {
+			hash, err := chainhash.NewHash(k[:chainhash.HashSize])
+			if err != nil {
+				return err
+			}
+			index, _ := util.FromVarint64(k[chainhash.HashSize:])
+			outpoints = append(outpoints, *wire.NewOutPoint(hash, uint32(index)))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return outpoints, nil
+}
+
+func (b BoltAccountStore) LockOutpoint(op wire.OutPoint) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		lockedBkt, err := bucket.CreateBucketIfNotExists([]byte("locked"))
+		if err != nil {
+			return err
+		}
+		return lockedBkt.Put(outpointKey(op), []byte{})
+	})
+}
+
+func (b BoltAccountStore) UnlockOutpoint(op wire.OutPoint) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		lockedBkt := bucket.Bucket([]byte("locked"))
+		if lockedBkt == nil {
+			return nil
+		}
+		return lockedBkt.Delete(outpointKey(op))
+	})
+}
+
+// labelKey returns the key of a label in the label bucket
+func labelKey(t LabelType, ref string) []byte {
+	return []byte(string(t) + ":" + ref)
+}
+
+// unpackLabel returns the label of a key and a value in the label bucket
+func unpackLabel(k, v []byte) *Label {
+	parts := strings.SplitN(string(k), ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	labelLen, n := util.FromVarint64(v)
+	if n+int(labelLen) > len(v) {
+		return nil
+	}
+	return &Label{
+		Type:   LabelType(parts[0]),
+		Ref:    parts[1],
+		Label:  string(v[n : n+int(labelLen)]),
+		Origin: string(v[n+int(labelLen):]),
+	}
+}
+
+func (b BoltAccountStore) GetLabels() ([]*Label, error) {
+	labels := make([]*Label, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		labelBkt := bucket.Bucket([]byte("label"))
+		if labelBkt == nil {
+			return nil
+		}
+
+		return labelBkt.ForEach(func(k, v []byte) error {
+			if l := unpackLabel(k, v); l != nil {
+				labels = append(labels, l)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func (b BoltAccountStore) GetLabel(t LabelType, ref string) (*Label, error) {
+	var label *Label
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		labelBkt := bucket.Bucket([]byte("label"))
+		if labelBkt == nil {
+			return nil
+		}
+
+		k := labelKey(t, ref)
+		if v := labelBkt.Get(k); v != nil {
+			label = unpackLabel(k, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+func (b BoltAccountStore) SetLabel(l *Label) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		labelBkt, err := bucket.CreateBucketIfNotExists([]byte("label"))
+		if err != nil {
+			return err
+		}
+
+		v := util.ToVarint64(uint64(len(l.Label)))
+		v = append(v, l.Label...)
+		v = append(v, l.Origin...)
+		return labelBkt.Put(labelKey(l.Type, l.Ref), v)
+	})
+}
+
+func (b BoltAccountStore) DeleteLabel(t LabelType, ref string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		labelBkt := bucket.Bucket([]byte("label"))
+		if labelBkt == nil {
+			return nil
+		}
+		return labelBkt.Delete(labelKey(t, ref))
+	})
+}
+
+// packTransaction packs a transaction record into varints of the
+// direction, the state, the fee, the confirmations, the height, the block
+// time, the time and the number of the amounts, followed by the address
+// length, the address and the amount of each
+func packTransaction(t *Transaction) []byte {
+	var blockTime int64
+	if !t.BlockTime.IsZero() {
+		blockTime = t.BlockTime.Unix()
+	}
+	b := util.ToVarint64(uint64(t.Direction))
+	b = append(b, util.ToVarint64(uint64(t.State))...)
+	b = append(b, util.ToVarint64(t.Fee)...)
+	b = append(b, util.ToVarint64(t.Confirmations)...)
+	b = append(b, util.ToVarint64(t.Height)...)
+	b = append(b, util.ToVarint64(uint64(blockTime))...)
+	b = append(b, util.ToVarint64(uint64(t.Time.Unix()))...)
+	b = append(b, util.ToVarint64(uint64(len(t.Amounts)))...)
+	for _, a := range t.Amounts {
+		b = append(b, util.ToVarint64(uint64(len(a.Address)))...)
+		b = append(b, a.Address...)
+		b = append(b, util.ToVarint64(a.Amount)...)
+	}
+	return b
+}
+
+func unpackTransaction(txId string, b []byte) *Transaction {
+	values := make([]uint64, 8)
+	offset := 0
+	for i := range values {
+		v, n := util.FromVarint64(b[offset:])
+		values[i] = v
+		offset += n
+	}
+
+	t := &Transaction{
+		TxId:          txId,
+		Direction:     TxDirection(values[0]),
+		State:         TxState(values[1]),
+		Fee:           values[2],
+		Confirmations: values[3],
+		Height:        values[4],
+		Time:          time.Unix(int64(values[6]), 0),
+		Amounts:       make([]TxAmount, 0, values[7]),
+	}
+	if values[5] != 0 {
+		t.BlockTime = time.Unix(int64(values[5]), 0)
+	}
+	for i := uint64(0); i < values[7]; i++ {
+		addrLen, n := util.FromVarint64(b[offset:])
+		offset += n
+		addr := string(b[offset : offset+int(addrLen)])
+		offset += int(addrLen)
+		amount, n := util.FromVarint64(b[offset:])
+		offset += n
+		t.Amounts = append(t.Amounts, TxAmount{Address: addr, Amount: amount})
+	}
+	return t
+}
+
+func (b BoltAccountStore) GetTransactions() ([]*Transaction, error) {
+	txs := make([]*Transaction, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		historyBkt := bucket.Bucket([]byte("history"))
+		if historyBkt == nil {
+			return nil
+		}
+
+		return historyBkt.ForEach(func(k, v []byte) error {
+			txs = append(txs, unpackTransaction(string(k), v))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+func (b BoltAccountStore) GetTransaction(txId string) (*Transaction, error) {
+	var t *Transaction
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		historyBkt := bucket.Bucket([]byte("history"))
+		if historyBkt == nil {
+			return nil
+		}
+
+		if v := historyBkt.Get([]byte(txId)); v != nil {
+			t = unpackTransaction(txId, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (b BoltAccountStore) SetTransaction(t *Transaction) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		historyBkt, err := bucket.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		return historyBkt.Put([]byte(t.TxId), packTransaction(t))
+	})
+}
+
+// GetForeignTransaction returns the number of the owned addresses which
+// a transaction is found not to spend from, and false if it is not checked
+func (b BoltAccountStore) GetForeignTransaction(txId string) (uint32, bool, error) {
+	var owned uint32
+	found := false
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		foreignBkt := bucket.Bucket([]byte("foreign"))
+		if foreignBkt == nil {
+			return nil
+		}
+
+		if v := foreignBkt.Get([]byte(txId)); v != nil {
+			n, _ := util.FromVarint64(v)
+			owned = uint32(n)
+			found = true
+		}
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+	return owned, found, nil
+}
+
+func (b BoltAccountStore) SetForeignTransaction(txId string, owned uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		foreignBkt, err := bucket.CreateBucketIfNotExists([]byte("foreign"))
+		if err != nil {
+			return err
+		}
+		return foreignBkt.Put([]byte(txId), util.ToVarint64(uint64(owned)))
+	})
+}
+
+// packPendingOutPoint packs a pending coin into a varint of the spent flag,
+// the hash of its transaction, varints of the time and the value, followed
+// by the address
+func packPendingOutPoint(p *PendingOutPoint) ([]byte, error) {
+	txHash, err := chainhash.NewHashFromStr(p.TxId)
+	if err != nil {
+		return nil, err
+	}
+	var spent uint64
+	if p.Spent {
+		spent = 1
+	}
+	b := util.ToVarint64(spent)
+	b = append(b, txHash[:]...)
+	b = append(b, util.ToVarint64(uint64(p.Time.Unix()))...)
+	b = append(b, util.ToVarint64(p.Value)...)
+	return append(b, p.Address...), nil
+}
+
+func unpackPendingOutPoint(k, v []byte) (*PendingOutPoint, error) {
+	hash, err := chainhash.NewHash(k[:chainhash.HashSize])
+	if err != nil {
+		return nil, err
+	}
+	index, _ := util.FromVarint64(k[chainhash.HashSize:])
+
+	spent, offset := util.FromVarint64(v)
+	txHash, err := chainhash.NewHash(v[offset : offset+chainhash.HashSize])
+	if err != nil {
+		return nil, err
+	}
+	offset += chainhash.HashSize
+	t, n := util.FromVarint64(v[offset:])
+	offset += n
+	value, n := util.FromVarint64(v[offset:])
+	offset += n
+	return &PendingOutPoint{
+		OutPoint: *wire.NewOutPoint(hash, uint32(index)),
+		TxId:     txHash.String(),
+		Spent:    spent == 1,
+		Address:  string(v[offset:]),
+		Value:    value,
+		Time:     time.Unix(int64(t), 0),
+	}, nil
+}
+
+func (b BoltAccountStore) GetPendingOutPoints() ([]*PendingOutPoint, error) {
+	pending := make([]*PendingOutPoint, 0)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		pendingBkt := bucket.Bucket([]byte("pending"))
+		if pendingBkt == nil {
+			return nil
+		}
+
+		return pendingBkt.ForEach(func(k, v []byte) error {
+			p, err := unpackPendingOutPoint(k, v)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, p)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (b BoltAccountStore) SetPendingOutPoint(p *PendingOutPoint) error {
+	v, err := packPendingOutPoint(p)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		pendingBkt, err := bucket.CreateBucketIfNotExists([]byte("pending"))
+		if err != nil {
+			return err
+		}
+		return pendingBkt.Put(outpointKey(p.OutPoint), v)
+	})
+}
+
+func (b BoltAccountStore) DeletePendingOutPoint(op wire.OutPoint) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(b.account))
+		if bucket == nil {
+			return ErrAccountBucketNotExisted
+		}
+		pendingBkt := bucket.Bucket([]byte("pending"))
+		if pendingBkt == nil {
+			return nil
+		}
+		return pendingBkt.Delete(outpointKey(op))
+	})
+}
+
 func NewBoltAccountStore(filename, account string) (*BoltAccountStore, error) {
 	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
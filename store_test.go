@@ -76,3 +76,25 @@ func TestBoltAccountStoreGetAll(t *testing.T) {
 	assert.Equal(t, u1.Value, uint64(200000))
 	os.Remove("wallet_test2.dat")
 }
+
+func TestPackUTXOConfirmations(t *testing.T) {
+	utxos := unpackUTXOs(packUTXOs(tx.UTXOs{
+		{TxHash: []byte("fakehash"), TxIndex: 1, Value: 100000, Confirmations: 6},
+		{TxHash: []byte("fakehash1"), TxIndex: 2, Value: 200000},
+	}))
+	assert.Len(t, utxos, 2)
+	assert.Equal(t, uint64(6), utxos[0].Confirmations)
+	assert.Equal(t, uint64(200000), utxos[1].Value)
+	assert.Equal(t, uint64(0), utxos[1].Confirmations)
+}
+
+func TestUnpackLegacyUTXO(t *testing.T) {
+	// hash length, hash, index and value without confirmations
+	b := append([]byte{8}, []byte("fakehash")...)
+	b = append(b, 1, 0xa0, 0x8d, 0x06)
+	utxos := unpackUTXOs(b)
+	assert.Len(t, utxos, 1)
+	assert.Equal(t, []byte("fakehash"), utxos[0].TxHash)
+	assert.Equal(t, uint32(1), utxos[0].TxIndex)
+	assert.Equal(t, uint64(100000), utxos[0].Value)
+}
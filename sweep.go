@@ -0,0 +1,176 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bitgoin/address"
+	bgbtcec "github.com/bitgoin/address/btcec"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrInvalidWIF  = fmt.Errorf("invalid WIF private key")
+	ErrNoCoinOfKey = fmt.Errorf("no coin is found for the keys")
+)
+
+// DecodeWIF decodes a private key in the wallet import format of a coin
+// network. Both the compressed and the uncompressed keys are supported.
+func DecodeWIF(wif string, params *address.Params) (*address.PrivateKey, error) {
+	payload, version, err := base58.CheckDecode(wif)
+	if err != nil {
+		return nil, ErrInvalidWIF
+	}
+
+	validVersion := false
+	for _, h := range params.DumpedPrivateKeyHeader {
+		if version == h {
+			validVersion = true
+		}
+	}
+	if !validVersion {
+		return nil, ErrInvalidWIF
+	}
+
+	compressed := false
+	switch {
+	case len(payload) == bgbtcec.PrivKeyBytesLen:
+	case len(payload) == bgbtcec.PrivKeyBytesLen+1 && payload[bgbtcec.PrivKeyBytesLen] == 0x01:
+		compressed = true
+	default:
+		return nil, ErrInvalidWIF
+	}
+
+	priv, pub := bgbtcec.PrivKeyFromBytes(bgbtcec.S256(), payload[:bgbtcec.PrivKeyBytesLen])
+	pubBytes := pub.SerializeUncompressed()
+	if compressed {
+		pubBytes = pub.SerializeCompressed()
+	}
+	pubKey, err := address.NewPublicKey(pubBytes, params)
+	if err != nil {
+		return nil, err
+	}
+	return &address.PrivateKey{PrivateKey: priv, PublicKey: pubKey}, nil
+}
+
+// keyScript is a script which a private key is able to spend
+type keyScript struct {
+	key          *address.PrivateKey
+	redeemScript []byte
+}
+
+// wifScripts returns the addresses a private key is able to spend and
+// their scripts. Uncompressed keys only have P2PKH addresses, and
+// compressed keys also have the native and the nested SegWit ones.
+func (c CoinAccount) wifScripts(key *address.PrivateKey) (map[string]keyScript, error) {
+	pubKeyHash := key.PublicKey.AddressBytes()
+	scripts := make(map[string]keyScript)
+
+	addr, err := btcutil.NewAddressPubKeyHash(pubKeyHash, c.net)
+	if err != nil {
+		return nil, err
+	}
+	scripts[addr.EncodeAddress()] = keyScript{key: key}
+
+	if len(key.PublicKey.Serialize()) != bgbtcec.PubKeyBytesLenCompressed {
+		return scripts, nil
+	}
+
+	witnessAddr, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, c.net)
+	if err != nil {
+		return nil, err
+	}
+	scripts[witnessAddr.EncodeAddress()] = keyScript{key: key}
+
+	redeemScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(pubKeyHash).
+		Script()
+	if err != nil {
+		return nil, err
+	}
+	nestedAddr, err := btcutil.NewAddressScriptHash(redeemScript, c.net)
+	if err != nil {
+		return nil, err
+	}
+	scripts[nestedAddr.EncodeAddress()] = keyScript{key: key, redeemScript: redeemScript}
+	return scripts, nil
+}
+
+// wifUTXOs finds the UTXOs of the private keys in WIF through the agent
+func (c CoinAccount) wifUTXOs(wifs []string) (tx.UTXOs, error) {
+	addrs := make([]string, 0)
+	scripts := make(map[string]keyScript)
+	for _, wif := range wifs {
+		key, err := DecodeWIF(wif, c.params)
+		if err != nil {
+			return nil, err
+		}
+		keyScripts, err := c.wifScripts(key)
+		if err != nil {
+			return nil, err
+		}
+		for addr, s := range keyScripts {
+			pkScript, err := tx.DefaultP2PKScript(addr)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+			scripts[hex.EncodeToString(pkScript)] = s
+		}
+	}
+
+	unspent, err := c.agent.ScanUnspent(addrs)
+	if err != nil {
+		return nil, err
+	}
+	utxos := make(tx.UTXOs, 0, len(unspent))
+	for _, u := range unspent {
+		s, ok := scripts[hex.EncodeToString(u.Script)]
+		if !ok {
+			continue
+		}
+		u.Key = s.key
+		u.RedeemScript = s.redeemScript
+		utxos = append(utxos, u)
+	}
+	if len(utxos) == 0 {
+		return nil, ErrNoCoinOfKey
+	}
+	return utxos, nil
+}
+
+// SweepWIF spends all the coins of the private keys in WIF, for example
+// from a paper wallet, to a new external address of the account. The coins
+// are found by the agent and signed by the keys. The fee is per kB or
+// estimated as Send does, and it is deducted from the amount.
+func (c CoinAccount) SweepWIF(wifs []string, fee uint64) (string, string, error) {
+	utxos, err := c.wifUTXOs(wifs)
+	if err != nil {
+		return "", "", err
+	}
+
+	to, err := c.newTxAddress(false)
+	if err != nil {
+		return "", "", err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(to, paid) }()
+
+	redeemTx, err := c.prepareSweepTx(utxos, to.address, nil, c.feeRate(fee))
+	if err != nil {
+		return "", "", err
+	}
+
+	txId, rawTx, err := c.Broadcast(redeemTx)
+	if err != nil {
+		return "", "", err
+	}
+	paid = redeemTx
+	return txId, rawTx, nil
+}
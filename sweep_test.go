@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestDecodeWIF(t *testing.T) {
+	key, err := DecodeWIF("5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", BitcoinMain)
+	assert.NoError(t, err)
+	assert.Equal(t, "0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d", hex.EncodeToString(key.Serialize()))
+	assert.Equal(t, "1GAehh7TsJAHuUAeKZcXf5CnwuGuGgyX2S", key.PublicKey.Address())
+
+	key, err = DecodeWIF("KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", BitcoinMain)
+	assert.NoError(t, err)
+	assert.Equal(t, "1LoVGDgRs9hTfTNJNuXKSpywcbdvwRXpmK", key.PublicKey.Address())
+
+	// the litecoin header
+	priv, _ := hex.DecodeString("0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d")
+	key, err = DecodeWIF(base58.CheckEncode(append(priv, 0x01), 176), LitecoinMain)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('L'), key.PublicKey.Address()[0])
+
+	_, err = DecodeWIF("KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", LitecoinMain)
+	assert.Equal(t, ErrInvalidWIF, err)
+	_, err = DecodeWIF("KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98618", BitcoinMain)
+	assert.Equal(t, ErrInvalidWIF, err)
+	_, err = DecodeWIF(base58.CheckEncode(append(priv, 0x02), 128), BitcoinMain)
+	assert.Equal(t, ErrInvalidWIF, err)
+}
+
+func TestSweepWIF(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	priv, _ := hex.DecodeString("0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d")
+	compressedWIF := base58.CheckEncode(append(priv, 0x01), 239)
+	priv2, _ := hex.DecodeString("1c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d")
+	uncompressedWIF := base58.CheckEncode(priv2, 239)
+
+	compressed, err := DecodeWIF(compressedWIF, BitcoinTest)
+	assert.NoError(t, err)
+	compressedScripts, err := account.wifScripts(compressed)
+	assert.NoError(t, err)
+	assert.Len(t, compressedScripts, 3)
+	uncompressed, err := DecodeWIF(uncompressedWIF, BitcoinTest)
+	assert.NoError(t, err)
+	uncompressedScripts, err := account.wifScripts(uncompressed)
+	assert.NoError(t, err)
+	assert.Len(t, uncompressedScripts, 1)
+
+	var id byte
+	for addr := range compressedScripts {
+		id++
+		script, err := tx.DefaultP2PKScript(addr)
+		assert.NoError(t, err)
+		a.scanned = append(a.scanned, testUTXO(id, 10000, 1, string(script)))
+	}
+	for addr := range uncompressedScripts {
+		id++
+		script, err := tx.DefaultP2PKScript(addr)
+		assert.NoError(t, err)
+		a.scanned = append(a.scanned, testUTXO(id, 20000, 1, string(script)))
+	}
+
+	_, _, err = account.SweepWIF([]string{}, 1000)
+	assert.Equal(t, ErrNoCoinOfKey, err)
+
+	// a failed sweep gives back its address
+	a.sendErr = fmt.Errorf("rejected")
+	_, _, err = account.SweepWIF([]string{compressedWIF, uncompressedWIF}, 1000)
+	assert.Error(t, err)
+	index, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), index.Issued)
+	a.sendErr = nil
+
+	_, rawTx, err := account.SweepWIF([]string{compressedWIF, uncompressedWIF}, 1000)
+	assert.NoError(t, err)
+	swept := deserializeTx(t, rawTx)
+
+	assert.Len(t, swept.TxIn, 4)
+	assert.Len(t, swept.TxOut, 1)
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, script, swept.TxOut[0].PkScript)
+	// a signature may be a byte shorter than the one the fee is for
+	fee := uint64(txFee(swept, a.scanned))
+	assert.True(t, fee >= feeForSize(txVirtualSize(swept), 1000))
+	assert.True(t, fee <= feeForSize(txVirtualSize(swept)+len(swept.TxIn), 1000))
+	verifyTx(t, swept, a.scanned)
+}
@@ -0,0 +1,280 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+var (
+	ErrInvalidTimelock    = fmt.Errorf("invalid timelock")
+	ErrUnknownTimelock    = fmt.Errorf("address is not a timelock address of the account")
+	ErrNoTimelockCoin     = fmt.Errorf("no coin is locked in the timelock address")
+	ErrTimelockNotExpired = fmt.Errorf("timelock is not expired yet")
+	ErrTimelockMultisig   = fmt.Errorf("multisig account has no timelock address")
+)
+
+// TimelockKind tells how a timelock counts the time
+type TimelockKind uint32
+
+const (
+	// AbsoluteTimelock locks coins until a block height or a time as
+	// OP_CHECKLOCKTIMEVERIFY of BIP65 does
+	AbsoluteTimelock TimelockKind = iota
+	// RelativeTimelock locks coins for a number of blocks or a period
+	// after they are confirmed as OP_CHECKSEQUENCEVERIFY of BIP112 does
+	RelativeTimelock
+)
+
+// Timelock is the condition which the coins of a timelock address are
+// spent after. The value of an absolute timelock is an nLockTime and the
+// value of a relative one is a sequence of BIP68.
+type Timelock struct {
+	Kind  TimelockKind
+	Value uint32
+}
+
+// LockUntilHeight returns a timelock which expires at a block height
+func LockUntilHeight(height uint32) (Timelock, error) {
+	if height == 0 || height >= txscript.LockTimeThreshold {
+		return Timelock{}, ErrInvalidTimelock
+	}
+	return Timelock{Kind: AbsoluteTimelock, Value: height}, nil
+}
+
+// LockUntilTime returns a timelock which expires when the median time of
+// the past blocks reaches a time
+func LockUntilTime(t time.Time) (Timelock, error) {
+	if t.Unix() < txscript.LockTimeThreshold || t.Unix() > int64(^uint32(0)) {
+		return Timelock{}, ErrInvalidTimelock
+	}
+	return Timelock{Kind: AbsoluteTimelock, Value: uint32(t.Unix())}, nil
+}
+
+// LockForBlocks returns a timelock which expires the number of blocks after
+// the coins are confirmed
+func LockForBlocks(blocks uint32) (Timelock, error) {
+	if blocks == 0 || blocks > wire.SequenceLockTimeMask {
+		return Timelock{}, ErrInvalidTimelock
+	}
+	return Timelock{Kind: RelativeTimelock, Value: blocks}, nil
+}
+
+// LockForDuration returns a timelock which expires a period after the coins
+// are confirmed. BIP68 counts the period in units of 512 seconds and the
+// period is rounded up to them.
+func LockForDuration(d time.Duration) (Timelock, error) {
+	unit := time.Duration(1<<wire.SequenceLockTimeGranularity) * time.Second
+	units := (d + unit - 1) / unit
+	if units <= 0 || units > wire.SequenceLockTimeMask {
+		return Timelock{}, ErrInvalidTimelock
+	}
+	return Timelock{Kind: RelativeTimelock, Value: wire.SequenceLockTimeIsSeconds | uint32(units)}, nil
+}
+
+// String describes when a timelock expires
+func (l Timelock) String() string {
+	switch {
+	case l.Kind == AbsoluteTimelock && l.Value < txscript.LockTimeThreshold:
+		return fmt.Sprintf("until height %d", l.Value)
+	case l.Kind == AbsoluteTimelock:
+		return "until " + time.Unix(int64(l.Value), 0).UTC().Format(time.RFC3339)
+	case l.Value&wire.SequenceLockTimeIsSeconds != 0:
+		units := l.Value & wire.SequenceLockTimeMask
+		return fmt.Sprintf("for %s", time.Duration(units<<wire.SequenceLockTimeGranularity)*time.Second)
+	default:
+		return fmt.Sprintf("for %d blocks", l.Value&wire.SequenceLockTimeMask)
+	}
+}
+
+// script returns the witness script which locks coins to a public key
+// until the timelock expires
+func (l Timelock) script(pubKey []byte) ([]byte, error) {
+	op := byte(txscript.OP_CHECKLOCKTIMEVERIFY)
+	if l.Kind == RelativeTimelock {
+		op = txscript.OP_CHECKSEQUENCEVERIFY
+	}
+	return txscript.NewScriptBuilder().
+		AddInt64(int64(l.Value)).
+		AddOp(op).
+		AddOp(txscript.OP_DROP).
+		AddData(pubKey).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+}
+
+// TimelockAddress is a P2WSH address of the account whose coins are
+// locked by a timelock. They are spent by the key of the external index.
+type TimelockAddress struct {
+	Address string
+	Lock    Timelock
+	Index   uint32
+}
+
+// SetLockTime sets nLockTime of the transactions which the account creates.
+// A value below 500000000 is a block height and the others are UNIX times.
+// Zero means no lock time.
+func (c *CoinAccount) SetLockTime(lockTime uint32) {
+	c.lockTime = lockTime
+}
+
+// timelockScript returns the witness script of a timelock address
+func (c CoinAccount) timelockScript(a *TimelockAddress) ([]byte, error) {
+	pub, err := c.addressPubKey(a.Index, false)
+	if err != nil {
+		return nil, err
+	}
+	return a.Lock.script(pub.SerializeCompressed())
+}
+
+// NewTimelockAddr returns a P2WSH address which locks the coins sent to it
+// until the timelock expires. The address is kept in the store so that
+// RedeemTimelock spends its coins later.
+func (c CoinAccount) NewTimelockAddr(lock Timelock) (string, error) {
+	if c.IsMultisig() {
+		return "", ErrTimelockMultisig
+	}
+
+	i, err := c.store.IssueIndex(false)
+	if err != nil {
+		return "", err
+	}
+	a := &TimelockAddress{Lock: lock, Index: i}
+
+	script, err := c.timelockScript(a)
+	if err != nil {
+		return "", err
+	}
+	scriptHash := sha256.Sum256(script)
+	addr, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], c.net)
+	if err != nil {
+		return "", err
+	}
+	a.Address = addr.EncodeAddress()
+
+	if err := c.store.SetTimelockAddress(a); err != nil {
+		return "", err
+	}
+	return a.Address, nil
+}
+
+// TimelockAddresses returns all the timelock addresses of the account
+func (c CoinAccount) TimelockAddresses() ([]*TimelockAddress, error) {
+	return c.store.GetTimelockAddresses()
+}
+
+// timelockAddress returns the timelock address of the account
+func (c CoinAccount) timelockAddress(addr string) (*TimelockAddress, error) {
+	addrs, err := c.store.GetTimelockAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.Address == addr {
+			return a, nil
+		}
+	}
+	return nil, ErrUnknownTimelock
+}
+
+// TimelockUTXOs returns the coins of a timelock address found by the agent
+func (c CoinAccount) TimelockUTXOs(addr string) (tx.UTXOs, error) {
+	if _, err := c.timelockAddress(addr); err != nil {
+		return nil, err
+	}
+	return c.agent.ScanUnspent([]string{addr})
+}
+
+// RedeemTimelock spends all the coins of a timelock address to a new
+// external address of the account. The fee is per kB or estimated as Send
+// does, and it is deducted from the amount. The locks which are able to be
+// checked locally are checked before, and the others are left to the
+// network.
+func (c CoinAccount) RedeemTimelock(addr string, fee uint64) (string, string, error) {
+	if c.IsWatchOnly() {
+		return "", "", ErrWatchOnly
+	}
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	a, err := c.timelockAddress(addr)
+	if err != nil {
+		return "", "", err
+	}
+	utxos, err := c.agent.ScanUnspent([]string{addr})
+	if err != nil {
+		return "", "", err
+	}
+	if len(utxos) == 0 {
+		return "", "", ErrNoTimelockCoin
+	}
+	if err := c.reserveAll(utxos); err != nil {
+		return "", "", err
+	}
+
+	key, err := c.addressKey(a.Index, false)
+	if err != nil {
+		return "", "", err
+	}
+	script, err := c.timelockScript(a)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case a.Lock.Kind == AbsoluteTimelock:
+		if a.Lock.Value >= txscript.LockTimeThreshold && time.Now().Unix() < int64(a.Lock.Value) {
+			return "", "", ErrTimelockNotExpired
+		}
+		c.lockTime = a.Lock.Value
+	case a.Lock.Value&wire.SequenceLockTimeIsSeconds == 0:
+		for _, u := range utxos {
+			if u.Confirmations < uint64(a.Lock.Value) {
+				return "", "", ErrTimelockNotExpired
+			}
+		}
+	}
+	for _, u := range utxos {
+		u.Key = key
+		u.WitnessScript = script
+		if a.Lock.Kind == RelativeTimelock {
+			u.Sequence = a.Lock.Value
+		}
+	}
+
+	to, err := c.newTxAddress(false)
+	if err != nil {
+		return "", "", err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(to, paid) }()
+
+	redeemTx, err := c.prepareSweepTx(utxos, to.address, nil, c.feeRate(fee))
+	if err != nil {
+		return "", "", err
+	}
+
+	txId, rawTx, err := c.Broadcast(redeemTx)
+	if err != nil {
+		return "", "", err
+	}
+	paid = redeemTx
+	return txId, rawTx, nil
+}
+
+// txVersion returns the version of a transaction which spends the UTXOs.
+// The relative timelocks of BIP68 only apply to the version 2.
+func txVersion(utxos tx.UTXOs) int32 {
+	for _, u := range utxos {
+		if u.Sequence != 0 && u.Sequence&wire.SequenceLockTimeDisabled == 0 {
+			return 2
+		}
+	}
+	return wire.TxVersion
+}
@@ -0,0 +1,117 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestTimelock(t *testing.T) {
+	lock, err := LockUntilHeight(700000)
+	assert.NoError(t, err)
+	assert.Equal(t, "until height 700000", lock.String())
+	lock, err = LockUntilTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "until 2030-01-01T00:00:00Z", lock.String())
+	lock, err = LockForBlocks(144)
+	assert.NoError(t, err)
+	assert.Equal(t, "for 144 blocks", lock.String())
+
+	// a period is rounded up to the units of 512 seconds
+	lock, err = LockForDuration(time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, wire.SequenceLockTimeIsSeconds|uint32(8), lock.Value)
+	assert.Equal(t, "for 1h8m16s", lock.String())
+
+	_, err = LockUntilHeight(500000000)
+	assert.Equal(t, ErrInvalidTimelock, err)
+	_, err = LockUntilTime(time.Unix(1000, 0))
+	assert.Equal(t, ErrInvalidTimelock, err)
+	_, err = LockForBlocks(0x10000)
+	assert.Equal(t, ErrInvalidTimelock, err)
+	_, err = LockForDuration(0)
+	assert.Equal(t, ErrInvalidTimelock, err)
+}
+
+func TestSendLockTime(t *testing.T) {
+	account, _, utxos := newTestAccount(t, 100000)
+	defer account.Close()
+
+	account.SetLockTime(700000)
+	_, rawTx, err := account.Send([]*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, nil, 1000)
+	assert.NoError(t, err)
+	sent := deserializeTx(t, rawTx)
+
+	assert.Equal(t, uint32(700000), sent.LockTime)
+	// a final sequence would disable the lock time
+	assert.Equal(t, uint32(RBFSequence), sent.TxIn[0].Sequence)
+	verifyTx(t, sent, utxos)
+}
+
+func TestRedeemTimelock(t *testing.T) {
+	account, a, _ := newTestAccount(t)
+	defer account.Close()
+
+	absolute, err := LockUntilHeight(700000)
+	assert.NoError(t, err)
+	relative, err := LockForBlocks(144)
+	assert.NoError(t, err)
+
+	absoluteAddr, err := account.NewTimelockAddr(absolute)
+	assert.NoError(t, err)
+	relativeAddr, err := account.NewTimelockAddr(relative)
+	assert.NoError(t, err)
+	assert.NotEqual(t, absoluteAddr, relativeAddr)
+
+	addrs, err := account.TimelockAddresses()
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 2)
+
+	absoluteScript, err := tx.DefaultP2PKScript(absoluteAddr)
+	assert.NoError(t, err)
+	relativeScript, err := tx.DefaultP2PKScript(relativeAddr)
+	assert.NoError(t, err)
+	a.scanned = tx.UTXOs{testUTXO(1, 50000, 10, string(absoluteScript))}
+
+	_, rawTx, err := account.RedeemTimelock(absoluteAddr, 1000)
+	assert.NoError(t, err)
+	redeemed := deserializeTx(t, rawTx)
+	assert.Equal(t, uint32(700000), redeemed.LockTime)
+	assert.Equal(t, uint32(RBFSequence), redeemed.TxIn[0].Sequence)
+	verifyTx(t, redeemed, a.scanned)
+
+	a.scanned = tx.UTXOs{testUTXO(2, 50000, 143, string(relativeScript))}
+	_, _, err = account.RedeemTimelock(relativeAddr, 1000)
+	assert.Equal(t, ErrTimelockNotExpired, err)
+
+	a.scanned = tx.UTXOs{testUTXO(2, 50000, 144, string(relativeScript))}
+
+	// a failed redemption gives back its address
+	issued, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	a.sendErr = fmt.Errorf("rejected")
+	_, _, err = account.RedeemTimelock(relativeAddr, 1000)
+	assert.Error(t, err)
+	index, err := account.ChainIndex(false)
+	assert.NoError(t, err)
+	assert.Equal(t, issued, index)
+	a.sendErr = nil
+
+	_, rawTx, err = account.RedeemTimelock(relativeAddr, 1000)
+	assert.NoError(t, err)
+	redeemed = deserializeTx(t, rawTx)
+	assert.Equal(t, int32(2), redeemed.Version)
+	assert.Equal(t, uint32(144), redeemed.TxIn[0].Sequence)
+	verifyTx(t, redeemed, a.scanned)
+
+	_, _, err = account.RedeemTimelock("mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", 1000)
+	assert.Equal(t, ErrUnknownTimelock, err)
+	a.scanned = nil
+	_, _, err = account.RedeemTimelock(relativeAddr, 1000)
+	assert.Equal(t, ErrNoTimelockCoin, err)
+}
@@ -0,0 +1,38 @@
+package tx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// MaxMultisigKeys is the most public keys of a multisig script which
+// still fits in a P2SH redeem script.
+const MaxMultisigKeys = 15
+
+// MultisigScript returns the script of an m-of-n multisig. The public keys
+// are sorted as BIP67 requires, so that every cosigner derives the same
+// script from the same keys.
+func MultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if m < 1 || m > n || n > MaxMultisigKeys {
+		return nil, fmt.Errorf("invalid %d-of-%d multisig", m, n)
+	}
+
+	sorted := make([][]byte, n)
+	copy(sorted, pubKeys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	script := make([]byte, 0, 3+n*34)
+	script = append(script, op1+byte(m)-1)
+	for _, k := range sorted {
+		if len(k) == 0 || len(k) >= int(opPUSHDATA1) {
+			return nil, fmt.Errorf("invalid public key of multisig")
+		}
+		script = append(script, byte(len(k)))
+		script = append(script, k...)
+	}
+	return append(script, op1+byte(n)-1, opCHECKMULTISIG), nil
+}
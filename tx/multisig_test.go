@@ -0,0 +1,28 @@
+package tx
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestMultisigScript(t *testing.T) {
+	// the first test vector of BIP67
+	k1, _ := hex.DecodeString("02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8")
+	k2, _ := hex.DecodeString("02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f")
+
+	script, err := MultisigScript(2, [][]byte{k1, k2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f" +
+		"2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae"
+	if hex.EncodeToString(script) != expected {
+		t.Errorf("unexpected script: %x", script)
+	}
+
+	for _, m := range []int{0, 3} {
+		if _, err := MultisigScript(m, [][]byte{k1, k2}); err == nil {
+			t.Errorf("%d-of-2 multisig is accepted", m)
+		}
+	}
+}
@@ -44,11 +44,18 @@ import (
 
 //UTXO represents an available transaction.
 type UTXO struct {
-	Key     *bgaddress.PrivateKey
-	TxHash  []byte
-	Value   uint64
-	Script  []byte
-	TxIndex uint32
+	Key           *bgaddress.PrivateKey
+	TxHash        []byte
+	Value         uint64
+	Script        []byte
+	RedeemScript  []byte
+	WitnessScript []byte
+	TxIndex       uint32
+	//Confirmations is the number of confirmations as of the last sync.
+	Confirmations uint64
+	//Sequence is the sequence of the vin which spends it, for example a
+	//relative timelock of BIP68. Zero is for the default one.
+	Sequence uint32
 }
 
 //UTXOs is array of coins.
@@ -62,11 +69,18 @@ func (c UTXOs) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 type Send struct {
 	Addr   string
 	Amount uint64
+	//SubtractFee deducts the fee from the amount instead of adding it on
+	//top. The fee is split evenly between the sends which subtract it.
+	SubtractFee bool
 }
 
 //DefaultP2PKScript returns default p2pk script.
 func DefaultP2PKScript(btcadr string) ([]byte, error) {
 
+	if address.IsWitnessAddress(btcadr) {
+		return witnessScript(btcadr)
+	}
+
 	version, addr, err := address.ValidateAddress(btcadr)
 	if nil != err {
 		return nil, err
@@ -91,6 +105,22 @@ func DefaultP2PKScript(btcadr string) ([]byte, error) {
 	}
 }
 
+//witnessScript returns the witness program script of a bech32 address.
+func witnessScript(btcadr string) ([]byte, error) {
+	_, witnessVersion, program, err := address.ValidateWitnessAddress(btcadr)
+	if nil != err {
+		return nil, err
+	}
+
+	versionOp := op0
+	if witnessVersion != 0 {
+		versionOp = op1 + witnessVersion - 1
+	}
+	script := make([]byte, 0, len(program)+2)
+	script = append(script, versionOp, byte(len(program)))
+	return append(script, program...), nil
+}
+
 func p2pkTtxout(send *Send) (*TxOut, error) {
 	script, err := DefaultP2PKScript(send.Addr)
 	if err != nil {
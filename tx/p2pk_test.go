@@ -80,3 +80,31 @@ func TestTX(t *testing.T) {
 	}
 	log.Print(hex.EncodeToString(rawtx))
 }
+
+func TestWitnessP2PKScript(t *testing.T) {
+	script, err := DefaultP2PKScript("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(script) != "0014751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Error("invalid p2wpkh script", hex.EncodeToString(script))
+	}
+
+	script, err = DefaultP2PKScript("ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(script) != "0014751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Error("invalid p2wpkh script", hex.EncodeToString(script))
+	}
+}
+
+func TestTaprootP2PKScript(t *testing.T) {
+	script, err := DefaultP2PKScript("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(script) != "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" {
+		t.Error("invalid p2tr script", hex.EncodeToString(script))
+	}
+}
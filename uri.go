@@ -0,0 +1,162 @@
+package wallet
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidURI   = fmt.Errorf("invalid payment URI")
+	ErrURIScheme    = fmt.Errorf("payment URI scheme is not of a supported coin")
+	ErrURIAmount    = fmt.Errorf("invalid amount of payment URI")
+	ErrURIParameter = fmt.Errorf("payment URI has a required parameter which is not supported")
+)
+
+// URIScheme is the BIP21 URI scheme of each coin
+var URIScheme = map[CoinType]string{
+	BTC: "bitcoin",
+	LTC: "litecoin",
+}
+
+// the number of the decimal places of the amounts of the payment URIs
+const uriAmountDecimals = 8
+
+// PaymentURI is a payment request in the URI format of BIP21, such as
+// bitcoin:<address>?amount=0.001&label=Shop
+type PaymentURI struct {
+	CoinType CoinType
+	Address  string
+	// Amount is in satoshis, and zero if the URI has no amount
+	Amount  uint64
+	Label   string
+	Message string
+}
+
+// ParsePaymentURI parses a BIP21 URI of a coin. The parameters which it
+// does not know are ignored unless they are required by the prefix req-.
+func ParsePaymentURI(uri string) (*PaymentURI, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Opaque == "" {
+		return nil, ErrInvalidURI
+	}
+
+	p := &PaymentURI{}
+	for ct, scheme := range URIScheme {
+		if strings.EqualFold(u.Scheme, scheme) {
+			p.CoinType = ct
+		}
+	}
+	if p.CoinType == "" {
+		return nil, ErrURIScheme
+	}
+	p.Address, err = url.PathUnescape(u.Opaque)
+	if err != nil {
+		return nil, ErrInvalidURI
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, ErrInvalidURI
+	}
+	for key, values := range query {
+		switch key {
+		case "amount":
+			p.Amount, err = parseURIAmount(values[0])
+			if err != nil {
+				return nil, err
+			}
+		case "label":
+			p.Label = values[0]
+		case "message":
+			p.Message = values[0]
+		default:
+			if strings.HasPrefix(key, "req-") {
+				return nil, ErrURIParameter
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseURIAmount parses an amount in the decimal coins into satoshis
+// without the rounding of floats
+func parseURIAmount(s string) (uint64, error) {
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" && fraction == "" || len(fraction) > uriAmountDecimals {
+		return 0, ErrURIAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	digits := whole + fraction + strings.Repeat("0", uriAmountDecimals-len(fraction))
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return 0, ErrURIAmount
+		}
+	}
+	amount, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, ErrURIAmount
+	}
+	return amount, nil
+}
+
+// formatURIAmount formats an amount in satoshis in the decimal coins
+// without the trailing zeros
+func formatURIAmount(amount uint64) string {
+	s := fmt.Sprintf("%d.%08d", amount/1e8, amount%1e8)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// uriEscape escapes a parameter of a payment URI with %20 for the spaces
+// as BIP21 does
+func uriEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// String returns the BIP21 URI of the payment request
+func (p PaymentURI) String() string {
+	params := make([]string, 0, 3)
+	if p.Amount > 0 {
+		params = append(params, "amount="+formatURIAmount(p.Amount))
+	}
+	if p.Label != "" {
+		params = append(params, "label="+uriEscape(p.Label))
+	}
+	if p.Message != "" {
+		params = append(params, "message="+uriEscape(p.Message))
+	}
+
+	uri := URIScheme[p.CoinType] + ":" + p.Address
+	if len(params) > 0 {
+		uri += "?" + strings.Join(params, "&")
+	}
+	return uri
+}
+
+// ReceiveURI hands out a new external address, and returns the payment URI
+// of it for the amount in satoshis. The address is labelled by the label of
+// the URI.
+func (c CoinAccount) ReceiveURI(amount uint64, label, message string) (*PaymentURI, error) {
+	addr, err := c.NewExternalAddr()
+	if err != nil {
+		return nil, err
+	}
+	if label != "" {
+		if err := c.SetLabel(LabelAddr, addr, label); err != nil {
+			return nil, err
+		}
+	}
+	return &PaymentURI{
+		CoinType: c.CoinType,
+		Address:  addr,
+		Amount:   amount,
+		Label:    label,
+		Message:  message,
+	}, nil
+}
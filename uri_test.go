@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePaymentURI(t *testing.T) {
+	p, err := ParsePaymentURI("bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=50&label=Luke-Jr&message=Donation%20for%20project%20xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, &PaymentURI{
+		CoinType: BTC,
+		Address:  "175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W",
+		Amount:   5000000000,
+		Label:    "Luke-Jr",
+		Message:  "Donation for project xyz",
+	}, p)
+
+	p, err = ParsePaymentURI("LITECOIN:mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt?amount=.00000001&somethingyoudontunderstand=50")
+	assert.NoError(t, err)
+	assert.Equal(t, LTC, p.CoinType)
+	assert.Equal(t, uint64(1), p.Amount)
+
+	p, err = ParsePaymentURI("bitcoin:tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), p.Amount)
+
+	for uri, expected := range map[string]error{
+		"bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=0.000000001":                ErrURIAmount,
+		"bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=1e3":                        ErrURIAmount,
+		"bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=-1":                         ErrURIAmount,
+		"bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=.":                          ErrURIAmount,
+		"bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?req-somethingyoudontunderstand=50": ErrURIParameter,
+		"dogecoin:DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L":                                  ErrURIScheme,
+		"175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W":                                           ErrInvalidURI,
+		"bitcoin:":                                                                     ErrInvalidURI,
+	} {
+		_, err := ParsePaymentURI(uri)
+		assert.Equal(t, expected, err, uri)
+	}
+}
+
+func TestPaymentURIString(t *testing.T) {
+	p := PaymentURI{
+		CoinType: LTC,
+		Address:  "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt",
+		Amount:   120000,
+		Label:    "Order #12 & co",
+		Message:  "Thanks",
+	}
+	uri := p.String()
+	assert.Equal(t, "litecoin:mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt?amount=0.0012&label=Order%20%2312%20%26%20co&message=Thanks", uri)
+	parsed, err := ParsePaymentURI(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, &p, parsed)
+
+	p = PaymentURI{CoinType: BTC, Address: "175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W", Amount: 5000000000}
+	assert.Equal(t, "bitcoin:175tWpb8K1S7NmH4Zx6rewF9WQrcZv245W?amount=50", p.String())
+}
+
+func TestReceiveURI(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer account.Close()
+
+	first, err := account.ReceiveURI(20000, "checkout 1", "")
+	assert.NoError(t, err)
+	second, err := account.ReceiveURI(0, "", "")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Address, second.Address)
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "bitcoin:"+addr+"?amount=0.0002&label=checkout%201", first.String())
+	label, err := account.Label(LabelAddr, addr)
+	assert.NoError(t, err)
+	assert.Equal(t, "checkout 1", label)
+}
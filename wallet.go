@@ -2,23 +2,26 @@ package wallet
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 
 	"github.com/bitgoin/address"
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcutil"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/bitmark-inc/bitmark-wallet/agent"
 	"github.com/bitmark-inc/bitmark-wallet/tx"
 )
 
-// Follow the rule of account discovery in BIP44
+// AddressGap is the default gap limit of the accounts, following the rule
+// of account discovery in BIP44
 // https://github.com/bitcoin/bips/blob/master/bip-0044.mediawiki#account-discovery
 const (
 	AddressGap = 5
@@ -27,31 +30,129 @@ const (
 var (
 	ErrNotEnoughCoin   = fmt.Errorf("not enough of coins in the wallet")
 	ErrNilAccountStore = fmt.Errorf("no account store is set")
+	ErrWatchOnly       = fmt.Errorf("watch-only account has no private keys")
+	ErrAmountTooSmall  = fmt.Errorf("amount is too small to pay the fee")
 )
 
-// CoinAccount is the root struct for manipulate coins.
+// CoinAccount is the root struct for manipulate coins. It is safe for
+// concurrent use once it is set up by the Set methods, and the transactions
+// built at the same time never select the same coins.
 type CoinAccount struct {
-	CoinType   CoinType
-	Test       Test
-	Key        *address.ExtendedKey
-	params     *address.Params
-	agent      agent.CoinAgent
-	store      AccountStore
-	feePerKB   uint64
-	index      uint32
-	identifier string
+	CoinType CoinType
+	Test     Test
+	Purpose  Purpose
+	Key      *address.ExtendedKey
+	params   *address.Params
+	net      *chaincfg.Params
+	// fingerprint of the master key and the derivation path of the
+	// account key which are used to describe the keys in PSBTs
+	fingerprint uint32
+	path        []uint32
+	agent       agent.CoinAgent
+	store       AccountStore
+	selector    CoinSelector
+	feePerKB    uint64
+	confTarget  uint32
+	lockTime    uint32
+	index       uint32
+	identifier  string
+	// the cosigners of a multisig account, nil for a single key account
+	multisig *multisig
+	// the coins which fund a transaction instead of the selected ones
+	inputs tx.UTXOs
+	// the coins reserved by the transactions in progress, and the
+	// reservation of the transaction which is being built
+	reservations *reservations
+	reservation  *reservation
+	// progress is called by Discover for each address it scans
+	progress func(DiscoverProgress)
+	// rescanned is the number of the first addresses of each chain which
+	// are rescanned before the first Discover
+	rescanned uint32
 }
 
 func (c *CoinAccount) Close() {
 	c.store.Close()
 }
 
-// signTx adds signatureScripts for each vins
+// prevOutputFetcher returns the spent outputs of vins which are
+// committed by the witness signature hashes
+func prevOutputFetcher(utxos tx.UTXOs, redeemTx *wire.MsgTx) txscript.PrevOutputFetcher {
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, txIn := range redeemTx.TxIn {
+		prevOuts[txIn.PreviousOutPoint] = wire.NewTxOut(int64(utxos[i].Value), utxos[i].Script)
+	}
+	return txscript.NewMultiPrevOutFetcher(prevOuts)
+}
+
+// signTx adds signatureScripts or witnesses for each vins
 func (c CoinAccount) signTx(utxos tx.UTXOs, redeemTx *wire.MsgTx) error {
+	sigHashes := txscript.NewTxSigHashes(redeemTx, prevOutputFetcher(utxos, redeemTx))
 	for i := range redeemTx.TxIn {
 		utxo := utxos[i]
+		if utxo.Key == nil {
+			if isMultisigUTXO(utxo) {
+				return ErrMultisigPSBT
+			}
+			return ErrWatchOnly
+		}
+		privKey, _ := btcec.PrivKeyFromBytes(utxo.Key.Serialize())
 
-		signatureScript, err := txscript.SignatureScript(redeemTx, i, utxo.Script, txscript.SigHashAll, (*btcec.PrivateKey)(utxo.Key.PrivateKey), true)
+		// the coins of a witness script of a single key, such as a timelock
+		// address, are spent by a signature for the script
+		if utxo.WitnessScript != nil {
+			sig, err := txscript.RawTxInWitnessSignature(redeemTx, sigHashes, i, int64(utxo.Value),
+				utxo.WitnessScript, txscript.SigHashAll, privKey)
+			if err != nil {
+				return err
+			}
+
+			redeemTx.TxIn[i].Witness = wire.TxWitness{sig, utxo.WitnessScript}
+			continue
+		}
+
+		// BIP86 outputs are spent by the key path with the default sighash
+		if txscript.IsPayToTaproot(utxo.Script) {
+			witness, err := txscript.TaprootWitnessSignature(redeemTx, sigHashes, i, int64(utxo.Value), utxo.Script, txscript.SigHashDefault, privKey)
+			if err != nil {
+				return err
+			}
+
+			redeemTx.TxIn[i].Witness = witness
+			continue
+		}
+
+		if txscript.IsPayToWitnessPubKeyHash(utxo.Script) {
+			witness, err := txscript.WitnessSignature(redeemTx, sigHashes, i, int64(utxo.Value), utxo.Script, txscript.SigHashAll, privKey, true)
+			if err != nil {
+				return err
+			}
+
+			redeemTx.TxIn[i].Witness = witness
+			continue
+		}
+
+		// a nested witness program is revealed in the signatureScript
+		// and signed by the witness
+		if txscript.IsPayToWitnessPubKeyHash(utxo.RedeemScript) {
+			witness, err := txscript.WitnessSignature(redeemTx, sigHashes, i, int64(utxo.Value), utxo.RedeemScript, txscript.SigHashAll, privKey, true)
+			if err != nil {
+				return err
+			}
+
+			signatureScript, err := txscript.NewScriptBuilder().AddData(utxo.RedeemScript).Script()
+			if err != nil {
+				return err
+			}
+
+			redeemTx.TxIn[i].Witness = witness
+			redeemTx.TxIn[i].SignatureScript = signatureScript
+			continue
+		}
+
+		// keys imported from WIF may be uncompressed
+		compressed := len(utxo.Key.PublicKey.Serialize()) == btcec.PubKeyBytesLenCompressed
+		signatureScript, err := txscript.SignatureScript(redeemTx, i, utxo.Script, txscript.SigHashAll, privKey, compressed)
 		if err != nil {
 			return err
 		}
@@ -62,6 +163,62 @@ func (c CoinAccount) signTx(utxos tx.UTXOs, redeemTx *wire.MsgTx) error {
 	return nil
 }
 
+// signForSize signs vins so that the transaction has its final size for
+// the fee estimation. Watch-only and multisig accounts fill placeholders of
+// the same sizes as the signatures instead, unless the UTXOs carry their
+// own keys.
+func (c CoinAccount) signForSize(utxos tx.UTXOs, redeemTx *wire.MsgTx) error {
+	withKeys := true
+	for _, utxo := range utxos {
+		if utxo.Key == nil {
+			withKeys = false
+		}
+	}
+	if (!c.IsWatchOnly() && !c.IsMultisig()) || withKeys {
+		return c.signTx(utxos, redeemTx)
+	}
+
+	// a DER signature with the sighash type takes at most 73 bytes and a
+	// compressed public key takes 33 bytes
+	sig := make([]byte, 73)
+	pubkey := make([]byte, 33)
+	for i, utxo := range utxos {
+		txIn := redeemTx.TxIn[i]
+		switch {
+		case isMultisigUTXO(utxo):
+			if err := fillMultisigPlaceholder(txIn, utxo.RedeemScript, utxo.WitnessScript); err != nil {
+				return err
+			}
+		case txscript.IsPayToTaproot(utxo.Script):
+			txIn.Witness = wire.TxWitness{make([]byte, 64)}
+		case txscript.IsPayToWitnessPubKeyHash(utxo.Script):
+			txIn.Witness = wire.TxWitness{sig, pubkey}
+		case txscript.IsPayToWitnessPubKeyHash(utxo.RedeemScript):
+			signatureScript, err := txscript.NewScriptBuilder().AddData(utxo.RedeemScript).Script()
+			if err != nil {
+				return err
+			}
+			txIn.SignatureScript = signatureScript
+			txIn.Witness = wire.TxWitness{sig, pubkey}
+		default:
+			signatureScript, err := txscript.NewScriptBuilder().AddData(sig).AddData(pubkey).Script()
+			if err != nil {
+				return err
+			}
+			txIn.SignatureScript = signatureScript
+		}
+	}
+	return nil
+}
+
+// txVirtualSize returns the size of a transaction in virtual bytes
+// which is defined in BIP141
+func txVirtualSize(t *wire.MsgTx) int {
+	baseSize := t.SerializeSizeStripped()
+	totalSize := t.SerializeSize()
+	return (baseSize*3 + totalSize + 3) / 4
+}
+
 type UnspentFunds struct {
 	TxIn        []*wire.TxIn
 	TotalAmount uint64
@@ -70,27 +227,15 @@ type UnspentFunds struct {
 
 // prepareUnspentFunds returns the UnspentFunds which includes vins, total amounts and
 // signing information of each vins
-func (c CoinAccount) prepareUnspentFunds(amount uint64) (*UnspentFunds, error) {
-	utxos, total, err := c.collectUTXOs(amount)
+func (c CoinAccount) prepareUnspentFunds(target SelectionTarget) (*UnspentFunds, error) {
+	utxos, total, err := c.selectUTXOs(target)
 	if err != nil {
 		return nil, err
 	}
 
-	if total < amount {
-		return nil, fmt.Errorf("no enough money to spend")
-	}
-
-	txInputs := []*wire.TxIn{}
-	for _, u := range utxos {
-		utxoHash, err := chainhash.NewHash(u.TxHash)
-		if err != nil {
-			return nil, err
-		}
-
-		outPoint := wire.NewOutPoint(utxoHash, u.TxIndex)
-		txIn := wire.NewTxIn(outPoint, nil, nil)
-
-		txInputs = append(txInputs, txIn)
+	txInputs, err := newTxIns(utxos)
+	if err != nil {
+		return nil, err
 	}
 
 	return &UnspentFunds{
@@ -101,99 +246,118 @@ func (c CoinAccount) prepareUnspentFunds(amount uint64) (*UnspentFunds, error) {
 }
 
 // prepareSpendTx creates a transaction by collecting enough vins,
-// adding vouts for destination and signing the transaction
-func (c CoinAccount) prepareSpendTx(customData []byte, sends []*tx.Send, changeAddr string, feePerKB uint64) (*wire.MsgTx, error) {
+// adding vouts for destination and signing the transaction. It returns
+// the signed transaction and the UTXOs spent by its vins.
+func (c CoinAccount) prepareSpendTx(customData []byte, sends []*tx.Send, changeAddr string, feePerKB uint64) (*wire.MsgTx, tx.UTXOs, error) {
 	redeemTx := wire.NewMsgTx(wire.TxVersion)
+	redeemTx.LockTime = c.lockTime
 
 	var totalInputAmount, totalOutputAmount uint64
 
-	var initialAmount uint64
-	for _, s := range sends {
-		initialAmount += s.Amount
-	}
-
-	unspentFunds, err := c.prepareUnspentFunds(initialAmount)
-	if err != nil {
-		return nil, err
-	}
-	redeemTx.TxIn = unspentFunds.TxIn
-	totalInputAmount = unspentFunds.TotalAmount
-
 	// prepare change pkScript
-	decodedChangeAddr, err := btcutil.DecodeAddress(changeAddr, &chaincfg.TestNet3Params)
+	decodedChangeAddr, err := btcutil.DecodeAddress(changeAddr, c.net)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	changePKScript, err := txscript.PayToAddrScript(decodedChangeAddr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	// the extra size which a change vout adds to the transaction
+	changeSize := wire.NewTxOut(0, changePKScript).SerializeSize()
 
 	totalVout := len(sends)
 
+	// the vouts which pay the fee out of their amounts
+	var subtractTxOuts []*wire.TxOut
+	var subtractAmounts []uint64
+
 	for _, s := range sends {
-		decodedAddr, err := btcutil.DecodeAddress(s.Addr, &chaincfg.TestNet3Params)
+		decodedAddr, err := btcutil.DecodeAddress(s.Addr, c.net)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		destinationAddrByte, err := txscript.PayToAddrScript(decodedAddr)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		totalOutputAmount += s.Amount
 		redeemTxOut := wire.NewTxOut(int64(s.Amount), destinationAddrByte)
 		redeemTx.AddTxOut(redeemTxOut)
+		if s.SubtractFee {
+			subtractTxOuts = append(subtractTxOuts, redeemTxOut)
+			subtractAmounts = append(subtractAmounts, s.Amount)
+		}
 	}
 
 	// add custome data as the last vout
 	if customData != nil {
-		builder := txscript.NewScriptBuilder()
-		script, err := builder.AddOp(txscript.OP_RETURN).AddData(customData).Script()
+		script, err := nullDataScript(customData)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		totalVout += 1
 		redeemTx.AddTxOut(wire.NewTxOut(0, script))
 	}
 
+	// select coins for the outputs and the fee of the transaction
+	// without vins
+	target := SelectionTarget{
+		Amount:     totalOutputAmount + feeForSize(txVirtualSize(redeemTx), feePerKB),
+		InputFee:   feeForSize(c.inputVirtualSize(), feePerKB),
+		ChangeCost: feeForSize(changeSize, feePerKB),
+	}
+	if len(subtractTxOuts) > 0 {
+		// the coins only have to cover the amounts which pay the fee
+		target.Amount = totalOutputAmount
+		target.InputFee = 0
+	}
+	unspentFunds, err := c.prepareUnspentFunds(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	redeemTx.TxIn = unspentFunds.TxIn
+	totalInputAmount = unspentFunds.TotalAmount
+
 	// add scriptSig into transaction for better fee estimation
-	if err := c.signTx(unspentFunds.UTXOs, redeemTx); err != nil {
-		return nil, err
+	if err := c.signForSize(unspentFunds.UTXOs, redeemTx); err != nil {
+		return nil, nil, err
 	}
 
 	txSize := 0
 	// fee estimation loop
 	for {
 		log.WithField("txSize", txSize).Info("compare tx size")
-		if redeemTx.SerializeSize() <= txSize {
+		if txVirtualSize(redeemTx) <= txSize {
 			break
 		}
-		txSize = redeemTx.SerializeSize()
+		txSize = txVirtualSize(redeemTx)
 
-		feePerByte := int(feePerKB) / 1000
-
-		newFee := int64(txSize * feePerByte)
+		newFee := int64(feeForSize(txSize, feePerKB))
 		changeAmount := int64(totalInputAmount) - int64(totalOutputAmount) - newFee
+		if len(subtractTxOuts) > 0 {
+			changeAmount += newFee
+		}
 		log.WithField("fee", newFee).WithField("change", changeAmount).Info("estimate change value")
 		if changeAmount < 0 {
 			// changeAmount is less than zero which indicates that the fee is not enough
-			newInputAmount := totalInputAmount - uint64(changeAmount)
-			log.WithField("newInputAmount", newInputAmount).Info("not enough of transaction fee. need for input")
+			target.Amount += uint64(-changeAmount)
+			log.WithField("targetAmount", target.Amount).Info("not enough of transaction fee. need for input")
 
 			var err error
-			unspentFunds, err = c.prepareUnspentFunds(newInputAmount)
+			unspentFunds, err = c.prepareUnspentFunds(target)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			redeemTx.TxIn = unspentFunds.TxIn
 			totalInputAmount = unspentFunds.TotalAmount
 
 			// reset the evaluated txSize
 			txSize = 0
-		} else if changeAmount > 35*int64(feePerByte) {
-			// add the change vout only when the change is greater than 35 * feePerByte
-			// this value is determined by the extra transaction sizes of an addition vout
+		} else if changeAmount > int64(feeForSize(changeSize, feePerKB)) {
+			// add the change vout only when the change is greater than the fee
+			// of the extra transaction size which an addition vout takes
 
 			if len(redeemTx.TxOut) == totalVout {
 				// add change vout as the first item
@@ -202,16 +366,136 @@ func (c CoinAccount) prepareSpendTx(customData []byte, sends []*tx.Send, changeA
 				redeemTx.TxOut = append([]*wire.TxOut{redeemTxOut}, redeemTx.TxOut...)
 			}
 			redeemTx.TxOut[0].Value = changeAmount
+		} else if len(redeemTx.TxOut) > totalVout {
+			// drop the change vout which is no longer worth its size after
+			// the vins are selected again
+			redeemTx.TxOut = redeemTx.TxOut[1:]
 		}
 
-		if err := c.signTx(unspentFunds.UTXOs, redeemTx); err != nil {
+		if len(subtractTxOuts) > 0 && changeAmount >= 0 {
+			fee := newFee
+			if len(redeemTx.TxOut) == totalVout {
+				// the excess which does not make a change pays a part
+				// of the fee
+				fee -= changeAmount
+			}
+			if fee < 0 {
+				fee = 0
+			}
+			if err := subtractFee(subtractTxOuts, subtractAmounts, uint64(fee)); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if err := c.signForSize(unspentFunds.UTXOs, redeemTx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return redeemTx, unspentFunds.UTXOs, nil
+}
+
+// subtractFee deducts the fee evenly from the amounts of the vouts. The
+// first vout pays the remainder of the division.
+func subtractFee(txOuts []*wire.TxOut, amounts []uint64, fee uint64) error {
+	share := fee / uint64(len(txOuts))
+	for i, txOut := range txOuts {
+		deduction := share
+		if i == 0 {
+			deduction += fee % uint64(len(txOuts))
+		}
+		if amounts[i] <= deduction {
+			return ErrAmountTooSmall
+		}
+		txOut.Value = int64(amounts[i] - deduction)
+	}
+	return nil
+}
+
+// prepareSweepTx creates a transaction which spends all the UTXOs to an
+// address without a change. The fee is deducted from the amount. It
+// returns the signed transaction.
+func (c CoinAccount) prepareSweepTx(utxos tx.UTXOs, addr string, customData []byte, feePerKB uint64) (*wire.MsgTx, error) {
+	if len(utxos) == 0 {
+		return nil, ErrNotEnoughCoin
+	}
+
+	decodedAddr, err := btcutil.DecodeAddress(addr, c.net)
+	if err != nil {
+		return nil, err
+	}
+	destinationAddrByte, err := txscript.PayToAddrScript(decodedAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	redeemTx := wire.NewMsgTx(txVersion(utxos))
+	redeemTx.LockTime = c.lockTime
+	redeemTx.TxIn, err = newTxIns(utxos)
+	if err != nil {
+		return nil, err
+	}
+	var totalInputAmount uint64
+	for _, u := range utxos {
+		totalInputAmount += u.Value
+	}
+
+	redeemTxOut := wire.NewTxOut(0, destinationAddrByte)
+	redeemTx.AddTxOut(redeemTxOut)
+	if customData != nil {
+		script, err := nullDataScript(customData)
+		if err != nil {
 			return nil, err
 		}
+		redeemTx.AddTxOut(wire.NewTxOut(0, script))
 	}
 
+	if err := c.signForSize(utxos, redeemTx); err != nil {
+		return nil, err
+	}
+
+	// sign again until the size of the signatures stops growing
+	txSize := 0
+	for txVirtualSize(redeemTx) > txSize {
+		txSize = txVirtualSize(redeemTx)
+		fee := feeForSize(txSize, feePerKB)
+		if totalInputAmount <= fee {
+			return nil, ErrAmountTooSmall
+		}
+		redeemTxOut.Value = int64(totalInputAmount - fee)
+
+		if err := c.signForSize(utxos, redeemTx); err != nil {
+			return nil, err
+		}
+	}
 	return redeemTx, nil
 }
 
+// newTxIns returns the vins which spend the UTXOs and signal the
+// replaceability unless the UTXOs have their own sequences
+func newTxIns(utxos tx.UTXOs) ([]*wire.TxIn, error) {
+	txIns := make([]*wire.TxIn, 0, len(utxos))
+	for _, u := range utxos {
+		utxoHash, err := chainhash.NewHash(u.TxHash)
+		if err != nil {
+			return nil, err
+		}
+
+		txIn := wire.NewTxIn(wire.NewOutPoint(utxoHash, u.TxIndex), nil, nil)
+		txIn.Sequence = RBFSequence
+		if u.Sequence != 0 {
+			txIn.Sequence = u.Sequence
+		}
+		txIns = append(txIns, txIn)
+	}
+	return txIns, nil
+}
+
+// nullDataScript returns an OP_RETURN script which carries the data
+func nullDataScript(data []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(data).Script()
+}
+
 // String returns the identifier of an account.
 func (c CoinAccount) String() string {
 	return c.identifier
@@ -232,55 +516,121 @@ func New(seed []byte, dataFile string) *Wallet {
 // CoinAccount returns an extended account base on BIP44 with
 // the coin type and the account index being specified.
 func (w Wallet) CoinAccount(ct CoinType, test Test, account uint32) (*CoinAccount, error) {
+	return w.Account(BIP44, ct, test, account)
+}
+
+// Account returns an extended account base on BIP43 with the purpose,
+// the coin type and the account index being specified.
+func (w Wallet) Account(purpose Purpose, ct CoinType, test Test, account uint32) (*CoinAccount, error) {
 	coinParams := CoinParams[ct][test]
 	masterKey, err := address.NewMaster(w.seed, coinParams)
 	if err != nil {
 		return nil, err
 	}
-	// m / 44'
-	bip44Key, err := masterKey.Child(44)
+
+	// m / purpose' / coin' / account'
+	accountKey, path, err := deriveAccountKey(masterKey, purpose, CoinMap[ct], account)
 	if err != nil {
 		return nil, err
 	}
 
-	// m / 44' / ct'
-	cointKey, err := bip44Key.Child(CoinMap[ct])
+	fingerprint, err := masterFingerprint(masterKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// m / 44' / coin' / account'
-	accountKey, err := cointKey.Child(account)
+	c, err := newCoinAccount(accountKey, purpose, ct, test, fingerprint, path, w.dataFile)
 	if err != nil {
 		return nil, err
 	}
+	c.index = account
+	return c, nil
+}
+
+// masterFingerprint returns the fingerprint of a master key. BIP32 defines
+// it as the first 32 bits of the key identifier which PSBTs serialize in
+// little endian.
+func masterFingerprint(masterKey *address.ExtendedKey) (uint32, error) {
+	masterPubkey, err := masterKey.PubKey()
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(masterPubkey.AddressBytes()[:4]), nil
+}
+
+// newCoinAccount returns an account of an account key and opens its store
+// in the data file
+func newCoinAccount(accountKey *address.ExtendedKey, purpose Purpose, ct CoinType, test Test,
+	fingerprint uint32, path []uint32, dataFile string) (*CoinAccount, error) {
 
 	pubkey, err := accountKey.PubKey()
 	if err != nil {
 		return nil, err
 	}
 
-	store, err := NewBoltAccountStore(w.dataFile, pubkey.Address())
+	return openCoinAccount(accountKey, purpose, ct, test, fingerprint, path, pubkey.Address(), dataFile)
+}
+
+// openCoinAccount returns an account of an account key whose store is
+// the bucket of the identifier in the data file
+func openCoinAccount(accountKey *address.ExtendedKey, purpose Purpose, ct CoinType, test Test,
+	fingerprint uint32, path []uint32, identifier, dataFile string) (*CoinAccount, error) {
+
+	store, err := NewBoltAccountStore(dataFile, identifier)
 	if err != nil {
 		return nil, err
 	}
 
 	return &CoinAccount{
-		CoinType:   ct,
-		Test:       test,
-		Key:        accountKey,
-		store:      store,
-		params:     coinParams,
-		feePerKB:   CoinFee[ct],
-		identifier: pubkey.Address(),
+		CoinType:    ct,
+		Test:        test,
+		Purpose:     purpose,
+		Key:         accountKey,
+		store:       store,
+		params:      CoinParams[ct][test],
+		net:         ChainParams[ct][test],
+		fingerprint: fingerprint,
+		path:        path,
+		feePerKB:    CoinFee[ct],
+		confTarget:  DefaultConfTarget,
+		identifier:  identifier,
+
+		reservations: newReservations(),
 	}, nil
 }
 
+// deriveAccountKey returns the account key of m / purpose' / coin' / account'
+// and its derivation path. BIP44 accounts keep deriving unhardened children
+// as they always did, otherwise the existing wallets would lose their addresses.
+func deriveAccountKey(masterKey *address.ExtendedKey, purpose Purpose, coin, account uint32) (*address.ExtendedKey, []uint32, error) {
+	var hardened uint32
+	if purpose != BIP44 {
+		hardened = address.HardenedKeyStart
+	}
+
+	key := masterKey
+	path := make([]uint32, 0, 3)
+	for _, i := range []uint32{uint32(purpose), coin, account} {
+		k, err := key.Child(i + hardened)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = k
+		path = append(path, i+hardened)
+	}
+	return key, path, nil
+}
+
 func (c *CoinAccount) SetAgent(a agent.CoinAgent) {
 	c.agent = a
 }
 
-func (c CoinAccount) addressKey(i uint32, change bool) (*address.PrivateKey, error) {
+// IsWatchOnly returns true if the account has no private keys
+func (c CoinAccount) IsWatchOnly() bool {
+	return !c.Key.IsPrivate()
+}
+
+func (c CoinAccount) addressExtKey(i uint32, change bool) (*address.ExtendedKey, error) {
 	var changeBit uint32
 	if change {
 		changeBit = 1
@@ -290,7 +640,15 @@ func (c CoinAccount) addressKey(i uint32, change bool) (*address.PrivateKey, err
 		return nil, err
 	}
 
-	addreseAccount, err := externalKey.Child(i)
+	return externalKey.Child(i)
+}
+
+func (c CoinAccount) addressKey(i uint32, change bool) (*address.PrivateKey, error) {
+	if c.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+
+	addreseAccount, err := c.addressExtKey(i, change)
 	if err != nil {
 		return nil, err
 	}
@@ -298,101 +656,98 @@ func (c CoinAccount) addressKey(i uint32, change bool) (*address.PrivateKey, err
 	return addreseAccount.PrivKey()
 }
 
-func (c CoinAccount) NewChangeAddr() (string, error) {
-	lastIndex, err := c.store.GetLastIndex()
+func (c CoinAccount) addressPubKey(i uint32, change bool) (*address.PublicKey, error) {
+	addreseAccount, err := c.addressExtKey(i, change)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return c.Address(uint32(lastIndex), true)
-}
 
-func (c CoinAccount) NewExternalAddr() (string, error) {
-	lastIndex, err := c.store.GetLastIndex()
-	if err != nil {
-		return "", err
-	}
-	return c.Address(uint32(lastIndex)+1, false)
+	return addreseAccount.PubKey()
 }
 
 // Address returns a coin address
 func (c CoinAccount) Address(i uint32, change bool) (string, error) {
-	p, err := c.addressKey(i, change)
+	if c.multisig != nil {
+		return c.multisigAddress(i, change)
+	}
+
+	p, err := c.addressPubKey(i, change)
 	if err != nil {
 		return "", err
 	}
 
-	return p.PublicKey.Address(), nil
+	return c.encodeAddress(p)
 }
 
-func (c CoinAccount) Discover() error {
-	addresses := make([]string, 0)
-
-	// m / 44' / coin' / account' / external
-	var lastIndex uint64
-	for i := uint32(0); i < 2; i++ { // i = 0 external, i = 1 internal (change)
-		changeKey, err := c.Key.Child(i)
-		if err != nil {
-			return err
-		}
-		var gap, j uint32
-		var _lastIndex uint64
-		for gap < AddressGap {
-			k, err := changeKey.Child(j)
-			if err != nil {
-				return err
-			}
-
-			p, err := k.PubKey()
-			if err != nil {
-				return err
-			}
-
-			addr := p.Address()
-			err = c.agent.WatchAddress(addr)
-			switch err {
-			case agent.ErrNoTxForAddr:
-				gap += 1
-			case nil:
-				gap = 0
-				// Update the _lastIndex if there are transactions found
-				if i == 0 {
-					log.WithField("address", addr).WithField("index", j).Debug("discover external transactions")
-				}
-				_lastIndex = uint64(j)
-			default:
-				return err
-			}
+// redeemScript returns the script which is hashed into a P2SH address
+// of a public key. It is nil if the account does not use P2SH addresses.
+func (c CoinAccount) redeemScript(pub *address.PublicKey) ([]byte, error) {
+	switch c.Purpose {
+	case BIP49:
+		return txscript.NewScriptBuilder().
+			AddOp(txscript.OP_0).
+			AddData(pub.AddressBytes()).
+			Script()
+	default:
+		return nil, nil
+	}
+}
 
-			addresses = append(addresses, addr)
-			j += 1
-		}
-		log.WithField("external", i == 0).WithField("lastIndex", _lastIndex).Debug("discovered last index")
-		// make sure the last index is largest number between external and internal
-		if _lastIndex > lastIndex {
-			lastIndex = _lastIndex
-		}
+// addressScripts returns the redeem script and the witness script which
+// are revealed besides the signatures to spend the coins of an address
+func (c CoinAccount) addressScripts(i uint32, change bool) ([]byte, []byte, error) {
+	if c.multisig != nil {
+		return c.multisig.scripts(i, change)
 	}
 
-	addrUTXOs, err := c.agent.ListAllUnspent()
+	p, err := c.addressPubKey(i, change)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	redeemScript, err := c.redeemScript(p)
+	return redeemScript, nil, err
+}
 
-	for _, addr := range addresses {
-		// addrUTXOs[addr] might
-		// 1. contain utxos, and then the entry will be updated
-		// 2. NOT exist, and then the entry will be deleted
-		err = c.store.SetUTXO(addr, addrUTXOs[addr])
+// encodeAddress returns the address of a public key in the format
+// of the account purpose
+func (c CoinAccount) encodeAddress(pub *address.PublicKey) (string, error) {
+	switch c.Purpose {
+	case BIP49:
+		redeemScript, err := c.redeemScript(pub)
 		if err != nil {
-			return err
+			return "", err
 		}
+		addr, err := btcutil.NewAddressScriptHash(redeemScript, c.net)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	case BIP84:
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(pub.AddressBytes(), c.net)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	case BIP86:
+		internalKey, err := btcec.ParsePubKey(pub.SerializeCompressed())
+		if err != nil {
+			return "", err
+		}
+		outputKey := txscript.ComputeTaprootKeyNoScript(internalKey)
+		addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), c.net)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	default:
+		return pub.Address(), nil
 	}
-	log.WithField("lastIndex", lastIndex).Debug("set last index")
-	return c.store.SetLastIndex(lastIndex)
 }
 
+// GetBalance returns the total value of the coins of the account, which
+// reflects the pending transactions before the next Discover
 func (c CoinAccount) GetBalance() (uint64, error) {
-	utxos, err := c.store.GetAllUTXO()
+	utxos, err := c.unspentCoins()
 	if err != nil {
 		return 0, err
 	}
@@ -410,76 +765,264 @@ func (c CoinAccount) GetBalance() (uint64, error) {
 	return balance, nil
 }
 
-// collectUTXOs will collect UTXOs to fulfill a given amount
-func (c CoinAccount) collectUTXOs(amount uint64) (tx.UTXOs, uint64, error) {
+// spendableUTXOs returns all the UTXOs of the account with their keys and
+// scripts except the locked ones and the ones which pending transactions
+// spend. The UTXOs of changes, including the pending ones, come first.
+func (c CoinAccount) spendableUTXOs() (tx.UTXOs, error) {
 	coins := make([]*tx.UTXO, 0)
-	var total uint64
-	utxos, err := c.store.GetAllUTXO()
+	utxos, err := c.unspentCoins()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-
-	l, err := c.store.GetLastIndex()
+	locked, err := c.lockedOutPoints()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	// Use changes first
-COLLECT_UTXOS:
+
 	for j := 1; j >= 0; j-- {
-		for i := uint32(0); i <= uint32(l); i++ {
-			p, err := c.addressKey(i, j == 1) // 0: external, 1: internal(changes)
+		index, err := c.store.GetChainIndex(j == 1)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < index.Known(); i++ {
+			addr, err := c.Address(i, j == 1) // 0: external, 1: internal(changes)
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
-			address := p.PublicKey.Address()
-			if txs, ok := utxos[address]; ok {
-				script, err := tx.DefaultP2PKScript(address)
+			if txs, ok := utxos[addr]; ok {
+				script, err := tx.DefaultP2PKScript(addr)
+				if err != nil {
+					return nil, err
+				}
+				redeemScript, witnessScript, err := c.addressScripts(i, j == 1)
 				if err != nil {
-					return nil, 0, err
+					return nil, err
 				}
-				for i := 0; i < len(txs); i++ {
-					u := txs[i]
-					u.Key = p
+				// watch-only accounts collect UTXOs without keys, and so do
+				// multisig accounts whose keys do not spend the coins alone
+				var key *address.PrivateKey
+				if !c.IsWatchOnly() && !c.IsMultisig() {
+					key, err = c.addressKey(i, j == 1)
+					if err != nil {
+						return nil, err
+					}
+				}
+				for k := 0; k < len(txs); k++ {
+					u := txs[k]
+					if locked[utxoOutPoint(u)] {
+						continue
+					}
+					u.Key = key
 					u.Script = script
+					u.RedeemScript = redeemScript
+					u.WitnessScript = witnessScript
 					coins = append(coins, u)
-					total += u.Value
-
-					if total >= amount {
-						break COLLECT_UTXOS
-					}
 				}
 			}
 		}
 	}
-	return coins, total, nil
+	return coins, nil
 }
 
+// collectUTXOs will collect UTXOs to fulfill a given amount
+func (c CoinAccount) collectUTXOs(amount uint64) (tx.UTXOs, uint64, error) {
+	return c.selectUTXOs(SelectionTarget{Amount: amount})
+}
+
+// selectUTXOs selects UTXOs for a target by the coin selector of the account
+// and returns them with their total value. The coins given to SendFrom are
+// all spent instead.
+func (c CoinAccount) selectUTXOs(target SelectionTarget) (tx.UTXOs, uint64, error) {
+	if c.inputs != nil {
+		utxos, total, err := spendAll(c.inputs, target)
+		if err != nil {
+			return nil, total, err
+		}
+		if err := c.reserveAll(utxos); err != nil {
+			return nil, 0, err
+		}
+		return utxos, total, nil
+	}
+	utxos, err := c.spendableUTXOs()
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.selectFrom(utxos, target)
+}
+
+// selectFrom selects UTXOs among the given ones for a target by the coin
+// selector of the account and returns them with their total value. The
+// coins reserved by the other transactions are left out, and the selected
+// ones are reserved for the transaction.
+func (c CoinAccount) selectFrom(utxos tx.UTXOs, target SelectionTarget) (tx.UTXOs, uint64, error) {
+	// the coins which cost more than their values to spend are left out
+	var total uint64
+	candidates := make(tx.UTXOs, 0, len(utxos))
+	for _, u := range c.reservation.available(utxos) {
+		total += u.Value
+		if u.Value > target.InputFee {
+			candidates = append(candidates, u)
+		}
+	}
+
+	selector := c.selector
+	if selector == nil {
+		selector = DefaultCoinSelector
+	}
+	var coins tx.UTXOs
+	for {
+		var err error
+		coins, err = selector.SelectCoins(candidates, target)
+		if err == ErrNotEnoughCoin {
+			return nil, total, ErrNotEnoughCoin
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		conflicts := c.reservation.reserve(coins, false)
+		if len(conflicts) == 0 {
+			break
+		}
+		// another transaction has reserved some of the coins meanwhile
+		remaining := make(tx.UTXOs, 0, len(candidates))
+		for _, u := range candidates {
+			if conflicts[utxoOutPoint(u)] {
+				total -= u.Value
+			} else {
+				remaining = append(remaining, u)
+			}
+		}
+		candidates = remaining
+	}
+
+	var selected uint64
+	for _, u := range coins {
+		selected += u.Value
+	}
+	return coins, selected, nil
+}
+
+// SetCoinSelector sets the strategy to select the coins to spend
+func (c *CoinAccount) SetCoinSelector(s CoinSelector) {
+	c.selector = s
+}
+
+// inputVirtualSize returns the estimated virtual size of a signed input of
+// the account
+func (c CoinAccount) inputVirtualSize() int {
+	if c.multisig != nil {
+		return c.multisig.inputSize
+	}
+
+	switch c.Purpose {
+	case BIP49:
+		return 91
+	case BIP84:
+		return 68
+	case BIP86:
+		return 58
+	default:
+		return 148
+	}
+}
+
+// Send pays the sends with the fee per kB. A zero fee is estimated for the
+// confirmation target of the account, see SetConfTarget.
 func (c CoinAccount) Send(sends []*tx.Send, customData []byte, fee uint64) (string, string, error) {
-	feePerKB := c.feePerKB
-	if fee != 0 {
-		feePerKB = fee
+	if c.IsWatchOnly() {
+		return "", "", ErrWatchOnly
+	}
+	if c.IsMultisig() {
+		return "", "", ErrMultisigPSBT
+	}
+
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	feePerKB := c.feeRate(fee)
+	// Generate the change address in advance, and give it back unless the
+	// sent transaction pays a change to it.
+	change, err := c.newChangeAddress()
+	if err != nil {
+		return "", "", err
+	}
+	var paid *wire.MsgTx
+	defer func() { c.releaseAddress(change, paid) }()
+
+	redeemTx, _, err := c.prepareSpendTx(customData, sends, change.address, feePerKB)
+	if err != nil {
+		return "", "", err
 	}
-	// Generate the change address in advance.
-	changeAddr, err := c.NewChangeAddr()
+
+	txId, rawTx, err := c.Broadcast(redeemTx)
 	if err != nil {
 		return "", "", err
 	}
+	paid = redeemTx
+	return txId, rawTx, nil
+}
 
-	redeemTx, err := c.prepareSpendTx(customData, sends, changeAddr, feePerKB)
+// SendAll spends all the coins of the account to an address without a
+// change. The fee, which is per kB or estimated as Send does, is deducted
+// from the amount.
+func (c CoinAccount) SendAll(addr string, customData []byte, fee uint64) (string, string, error) {
+	if c.IsWatchOnly() {
+		return "", "", ErrWatchOnly
+	}
+	if c.IsMultisig() {
+		return "", "", ErrMultisigPSBT
+	}
+
+	c.reservation = c.reservations.begin()
+	defer c.reservation.release()
+
+	var utxos tx.UTXOs
+	if c.inputs != nil {
+		// the coins given to SendAllFrom are all spent, or none
+		utxos = c.inputs
+		if err := c.reserveAll(utxos); err != nil {
+			return "", "", err
+		}
+	} else {
+		spendable, err := c.spendableUTXOs()
+		if err != nil {
+			return "", "", err
+		}
+		// all the coins which the other transactions have not reserved
+		for {
+			utxos = c.reservation.available(spendable)
+			if err := c.reserveAll(utxos); err == nil {
+				break
+			}
+		}
+	}
+	redeemTx, err := c.prepareSweepTx(utxos, addr, customData, c.feeRate(fee))
 	if err != nil {
 		return "", "", err
 	}
 
-	var signedTx bytes.Buffer
-	if err := redeemTx.Serialize(&signedTx); err != nil {
+	return c.Broadcast(redeemTx)
+}
+
+// Broadcast sends a signed transaction to the network and returns its id
+// and the raw transaction
+func (c CoinAccount) Broadcast(signedTx *wire.MsgTx) (string, string, error) {
+	var buf bytes.Buffer
+	if err := signedTx.Serialize(&buf); err != nil {
 		return "", "", err
 	}
-	rawTx := hex.EncodeToString(signedTx.Bytes())
+	rawTx := hex.EncodeToString(buf.Bytes())
 	txId, err := c.agent.Send(rawTx)
 	if err != nil {
 		log.WithError(err).WithField("rawTx", rawTx).Error("unable to broadcast transaction")
 		return "", "", err
 	}
 
+	// the transaction is sent anyway even if it is not recorded
+	if err := c.recordBroadcast(signedTx); err != nil {
+		log.WithError(err).WithField("txId", txId).Error("unable to record transaction")
+	}
+	c.reservation.keep(txOutPoints(signedTx))
+
 	return txId, rawTx, err
 }
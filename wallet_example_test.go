@@ -3,7 +3,10 @@ package wallet_test
 import (
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 
 	"github.com/bitmark-inc/bitmark-wallet"
 )
@@ -14,7 +17,12 @@ func Example_newWallet() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	walletData := "wallet.dat"
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	walletData := filepath.Join(dir, "wallet.dat")
 	w := wallet.New(seed, walletData)
 	coinAccount, err := w.CoinAccount(wallet.BTC, wallet.Test(true), 0)
 	if err != nil {
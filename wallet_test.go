@@ -1,11 +1,18 @@
 package wallet
 
 import (
+	"bytes"
 	"encoding/hex"
-	"os"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bitmark-inc/bitmark-wallet/tx"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/bitmark-inc/bitmark-wallet/agent"
@@ -14,9 +21,7 @@ import (
 const seedHex = "fded5e8970380eef15f742348d28511111366ae6a55188402b16c69922006fe6"
 
 func TestWalletNew(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_new.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -29,13 +34,10 @@ func TestWalletNew(t *testing.T) {
 		a, err = ltcAccount.Address(i, true)
 		t.Logf("internal_%d: %s", i, a)
 	}
-	os.Remove("wallet_test_new.dat")
 }
 
 func TestWalletAgent(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_discover.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -54,14 +56,10 @@ func TestWalletAgent(t *testing.T) {
 			t.Log(hex.EncodeToString(txo.TxHash), txo.Value, txo.TxIndex)
 		}
 	}
-
-	os.Remove("wallet_test_discover.dat")
 }
 
 func TestWalletGetUTXO(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_getutxo.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -75,13 +73,10 @@ func TestWalletGetUTXO(t *testing.T) {
 	utxos, err := ltcAccount.store.GetUTXO("mvxpcRGnjRpme59CAnLHTxFjwd8ivwWbQb")
 	assert.NotNil(t, utxos)
 	assert.Len(t, utxos, 2)
-	os.Remove("wallet_test_getutxo.dat")
 }
 
 func TestWalletGetBalance(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_balance.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -95,17 +90,13 @@ func TestWalletGetBalance(t *testing.T) {
 	balance, err := ltcAccount.GetBalance()
 	assert.NoError(t, err)
 	t.Log(balance)
-	i, err := ltcAccount.store.GetLastIndex()
+	i, err := ltcAccount.ChainIndex(false)
 	assert.NoError(t, err)
 	t.Log(i)
-
-	os.Remove("wallet_test_balances.dat")
 }
 
 func TestWalletGenCoins(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_gencoins.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -116,7 +107,7 @@ func TestWalletGenCoins(t *testing.T) {
 
 	err = ltcAccount.Discover()
 	assert.NoError(t, err)
-	coins1, amount1, err := ltcAccount.GenCoins(150000000)
+	coins1, amount1, err := ltcAccount.collectUTXOs(150000000)
 	assert.NoError(t, err)
 	t.Log("generate amount:", amount1)
 	for _, txo := range coins1 {
@@ -124,18 +115,15 @@ func TestWalletGenCoins(t *testing.T) {
 	}
 	assert.True(t, amount1 > 150000000)
 
-	coins2, amount2, err := ltcAccount.GenCoins(275000000)
+	coins2, amount2, err := ltcAccount.collectUTXOs(275000000)
 	assert.EqualError(t, err, "not enough of coins in the wallet")
 	t.Log("generate amount:", amount2)
 	assert.Nil(t, coins2)
 	assert.True(t, amount2 < 275000000)
-	os.Remove("wallet_test_gencoins.dat")
 }
 
 func TestWalletSend(t *testing.T) {
-	seed, err := hex.DecodeString(seedHex)
-	assert.NoError(t, err)
-	w := New(seed, "wallet_test_send.dat")
+	w := newTestWallet(t)
 
 	ltcAccount, err := w.CoinAccount(LTC, true, 0)
 	assert.NoError(t, err)
@@ -147,7 +135,308 @@ func TestWalletSend(t *testing.T) {
 	err = ltcAccount.Discover()
 	assert.NoError(t, err)
 
-	rawTx, err := ltcAccount.Send([]*tx.Send{{"mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", 155600000}}, nil, 0)
+	txId, rawTx, err := ltcAccount.Send([]*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 155600000}}, nil, 0)
 	t.Log(err)
-	t.Log(rawTx)
+	t.Log(txId, rawTx)
+}
+
+// fakeAgent is an in-memory CoinAgent for the tests without a coin daemon
+type fakeAgent struct {
+	unspent map[string]tx.UTXOs
+	txs     map[string]string
+	mempool map[string]*agent.MempoolEntry
+	fees    map[uint32]uint64
+	scanned tx.UTXOs
+	history []*agent.TxEntry
+	height  uint64
+	// watched records the addresses which Discover scans
+	watched []string
+	// restored are the coins of the addresses used before they are
+	// watched, which are only listed after a rescan of the addresses
+	restored map[string]tx.UTXOs
+	// rescans records the addresses of each rescan
+	rescans [][]string
+	// sent records the broadcast transactions unless sendErr rejects them
+	sent    []string
+	sendErr error
+	mu      sync.Mutex
+}
+
+func (f *fakeAgent) ListAllUnspent() (map[string]tx.UTXOs, error) {
+	return f.unspent, nil
+}
+
+func (f *fakeAgent) WatchAddress(addr string) error {
+	f.mu.Lock()
+	f.watched = append(f.watched, addr)
+	f.mu.Unlock()
+	if _, ok := f.unspent[addr]; !ok {
+		return agent.ErrNoTxForAddr
+	}
+	return nil
+}
+
+func (f *fakeAgent) RescanAddresses(addrs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rescans = append(f.rescans, addrs)
+	for _, addr := range addrs {
+		utxos, ok := f.restored[addr]
+		if !ok {
+			continue
+		}
+		if f.unspent == nil {
+			f.unspent = make(map[string]tx.UTXOs)
+		}
+		f.unspent[addr] = utxos
+		delete(f.restored, addr)
+	}
+	return nil
+}
+
+func (f *fakeAgent) Send(rawTx string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		return "", f.sendErr
+	}
+	f.sent = append(f.sent, rawTx)
+	b, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return "", err
+	}
+	var t wire.MsgTx
+	if err := t.Deserialize(bytes.NewReader(b)); err != nil {
+		return "", err
+	}
+	return t.TxHash().String(), nil
+}
+
+func (f *fakeAgent) GetRawTransaction(txId string) (string, error) {
+	rawTx, ok := f.txs[txId]
+	if !ok {
+		return "", fmt.Errorf("transaction not found")
+	}
+	return rawTx, nil
+}
+
+func (f *fakeAgent) GetMempoolEntry(txId string) (*agent.MempoolEntry, error) {
+	entry, ok := f.mempool[txId]
+	if !ok {
+		return nil, fmt.Errorf("transaction not in mempool")
+	}
+	return entry, nil
+}
+
+func (f *fakeAgent) EstimateFee(confTarget uint32) (uint64, error) {
+	fee, ok := f.fees[confTarget]
+	if !ok {
+		return 0, agent.ErrNoFeeEstimate
+	}
+	return fee, nil
+}
+
+func (f *fakeAgent) ScanUnspent(addrs []string) (tx.UTXOs, error) {
+	scripts := make(map[string]bool)
+	for _, addr := range addrs {
+		script, err := tx.DefaultP2PKScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		scripts[hex.EncodeToString(script)] = true
+	}
+	utxos := tx.UTXOs{}
+	for _, u := range f.scanned {
+		if scripts[hex.EncodeToString(u.Script)] {
+			utxos = append(utxos, u)
+		}
+	}
+	return utxos, nil
+}
+
+func (f *fakeAgent) ListTransactions() ([]*agent.TxEntry, error) {
+	return f.history, nil
+}
+
+func (f *fakeAgent) GetBlockCount() (uint64, error) {
+	return f.height, nil
+}
+
+func serializeTx(t *testing.T, redeemTx *wire.MsgTx) string {
+	var buf bytes.Buffer
+	assert.NoError(t, redeemTx.Serialize(&buf))
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func deserializeTx(t *testing.T, rawTx string) *wire.MsgTx {
+	b, err := hex.DecodeString(rawTx)
+	assert.NoError(t, err)
+	var redeemTx wire.MsgTx
+	assert.NoError(t, redeemTx.Deserialize(bytes.NewReader(b)))
+	return &redeemTx
+}
+
+// testDataFile returns a data file which is removed after the test
+func testDataFile(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "wallet.dat")
+}
+
+// newTestWallet returns a wallet of the test seed whose data file is
+// removed after the test
+func newTestWallet(t *testing.T) *Wallet {
+	seed, err := hex.DecodeString(seedHex)
+	assert.NoError(t, err)
+	return New(seed, testDataFile(t))
+}
+
+// newTestAccount returns a BIP84 testnet account with coins of the values
+// at its first address, whose previous transactions are served by the fake
+// agent
+func newTestAccount(t *testing.T, values ...int64) (*CoinAccount, *fakeAgent, tx.UTXOs) {
+	account, err := newTestWallet(t).Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+
+	addr, err := account.Address(0, false)
+	assert.NoError(t, err)
+	script, err := tx.DefaultP2PKScript(addr)
+	assert.NoError(t, err)
+
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	for _, v := range values {
+		prevTx.AddTxOut(wire.NewTxOut(v, script))
+	}
+	prevHash := prevTx.TxHash()
+
+	a := &fakeAgent{txs: map[string]string{prevHash.String(): serializeTx(t, prevTx)}}
+	account.SetAgent(a)
+
+	utxos := tx.UTXOs{}
+	for i, v := range values {
+		utxos = append(utxos, &tx.UTXO{TxHash: prevHash[:], TxIndex: uint32(i), Value: uint64(v), Script: script})
+	}
+	assert.NoError(t, account.store.SetUTXO(addr, utxos))
+	return account, a, utxos
+}
+
+// verifyTx executes the scripts of all vins against their spent outputs
+func verifyTx(t *testing.T, redeemTx *wire.MsgTx, utxos tx.UTXOs) {
+	fetcher := prevOutputFetcher(utxos, redeemTx)
+	sigHashes := txscript.NewTxSigHashes(redeemTx, fetcher)
+	for i, u := range utxos {
+		vm, err := txscript.NewEngine(u.Script, redeemTx, i, txscript.StandardVerifyFlags, nil, sigHashes, int64(u.Value), fetcher)
+		if assert.NoError(t, err) {
+			assert.NoError(t, vm.Execute(), "vin %d", i)
+		}
+	}
+}
+
+func TestSegWitAccountSpend(t *testing.T) {
+	w := newTestWallet(t)
+
+	btcAccount, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	defer btcAccount.Close()
+
+	addr, err := btcAccount.Address(0, false)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(addr, "tb1q"), addr)
+	changeAddr, err := btcAccount.Address(0, true)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(changeAddr, "tb1q"), changeAddr)
+
+	err = btcAccount.store.SetUTXO(addr, tx.UTXOs{{
+		TxHash:  make([]byte, 32),
+		TxIndex: 1,
+		Value:   100000,
+	}})
+	assert.NoError(t, err)
+
+	redeemTx, utxos, err := btcAccount.prepareSpendTx(nil, []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, changeAddr, 2000)
+	assert.NoError(t, err)
+	assert.Len(t, redeemTx.TxIn, 1)
+	assert.Len(t, redeemTx.TxIn[0].SignatureScript, 0)
+	assert.Len(t, redeemTx.TxIn[0].Witness, 2)
+	assert.Len(t, redeemTx.TxOut, 2)
+
+	verifyTx(t, redeemTx, utxos)
+
+	// the fee is paid for virtual bytes instead of the full size
+	var totalOut int64
+	for _, out := range redeemTx.TxOut {
+		totalOut += out.Value
+	}
+	fee := 100000 - totalOut
+	assert.Equal(t, int64(txVirtualSize(redeemTx)*2), fee)
+	assert.True(t, fee < int64(redeemTx.SerializeSize()*2))
+}
+
+func TestNestedSegWitAccountSpend(t *testing.T) {
+	w := newTestWallet(t)
+
+	btcAccount, err := w.Account(BIP49, BTC, true, 0)
+	assert.NoError(t, err)
+	defer btcAccount.Close()
+
+	addr, err := btcAccount.Address(0, false)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(addr, "2"), addr)
+	changeAddr, err := btcAccount.Address(0, true)
+	assert.NoError(t, err)
+
+	err = btcAccount.store.SetUTXO(addr, tx.UTXOs{{
+		TxHash:  make([]byte, 32),
+		TxIndex: 0,
+		Value:   100000,
+	}})
+	assert.NoError(t, err)
+
+	redeemTx, utxos, err := btcAccount.prepareSpendTx(nil, []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, changeAddr, 2000)
+	assert.NoError(t, err)
+	assert.Len(t, redeemTx.TxIn, 1)
+	assert.Len(t, redeemTx.TxIn[0].SignatureScript, 23)
+	assert.Len(t, redeemTx.TxIn[0].Witness, 2)
+
+	verifyTx(t, redeemTx, utxos)
+}
+
+func TestTaprootAccountSpend(t *testing.T) {
+	w := newTestWallet(t)
+
+	btcAccount, err := w.Account(BIP86, BTC, true, 0)
+	assert.NoError(t, err)
+	defer btcAccount.Close()
+
+	changeAddr, err := btcAccount.Address(0, true)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(changeAddr, "tb1p"), changeAddr)
+
+	// spend two outputs so that all the spent amounts are committed
+	for i := uint32(0); i < 2; i++ {
+		addr, err := btcAccount.Address(i, false)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(addr, "tb1p"), addr)
+		err = btcAccount.store.SetUTXO(addr, tx.UTXOs{{
+			TxHash:  make([]byte, 32),
+			TxIndex: i,
+			Value:   30000,
+		}})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, btcAccount.store.SetUsedIndex(false, 2))
+
+	redeemTx, utxos, err := btcAccount.prepareSpendTx(nil, []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}, changeAddr, 2000)
+	assert.NoError(t, err)
+	assert.Len(t, redeemTx.TxIn, 2)
+	for _, txIn := range redeemTx.TxIn {
+		assert.Len(t, txIn.Witness, 1)
+		assert.Len(t, txIn.Witness[0], 64)
+	}
+
+	verifyTx(t, redeemTx, utxos)
+
+	decoded, err := btcutil.DecodeAddress(changeAddr, btcAccount.net)
+	assert.NoError(t, err)
+	_, ok := decoded.(*btcutil.AddressTaproot)
+	assert.True(t, ok)
 }
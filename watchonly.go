@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitgoin/address"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+var (
+	ErrInvalidAccountKey = fmt.Errorf("invalid extended public key")
+	ErrInvalidKeyOrigin  = fmt.Errorf("invalid key origin")
+)
+
+// NewWatchOnlyAccount returns an account which is able to discover addresses
+// and balances, and to create unsigned transactions, but not to sign them.
+//
+// The account key is an extended public key of the account level, optionally
+// prefixed with its key origin, for example "[d34db33f/84'/0'/0']xpub...".
+// Without the origin, the derivations in the PSBTs created by the account
+// are relative to the account key and SignPSBT does not recognise them.
+func NewWatchOnlyAccount(accountKey string, purpose Purpose, ct CoinType, test Test, dataFile string) (*CoinAccount, error) {
+	coinParams, ok := CoinParams[ct][test]
+	if !ok {
+		return nil, ErrInvalidAccountKey
+	}
+
+	key, fingerprint, path, err := parseAccountKey(accountKey, coinParams)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newCoinAccount(key, purpose, ct, test, fingerprint, path, dataFile)
+	if err != nil {
+		return nil, err
+	}
+	// m / purpose' / coin' / account'
+	if len(path) == 3 {
+		c.index = path[2] &^ address.HardenedKeyStart
+	}
+	return c, nil
+}
+
+// parseAccountKey parses an extended public key with its optional key
+// origin and returns the key, the fingerprint and the path of the origin
+func parseAccountKey(accountKey string, coinParams *address.Params) (*address.ExtendedKey, uint32, []uint32, error) {
+	var fingerprint uint32
+	var path []uint32
+	if strings.HasPrefix(accountKey, "[") {
+		end := strings.Index(accountKey, "]")
+		if end < 0 {
+			return nil, 0, nil, ErrInvalidKeyOrigin
+		}
+		var err error
+		fingerprint, path, err = parseKeyOrigin(accountKey[1:end])
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		accountKey = accountKey[end+1:]
+	}
+
+	// the address package checks neither the checksum nor the version
+	k, err := hdkeychain.NewKeyFromString(accountKey)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if k.IsPrivate() || !bytes.Equal(k.Version(), coinParams.HDPublicKeyID) {
+		return nil, 0, nil, ErrInvalidAccountKey
+	}
+
+	key, err := address.NewKeyFromString(accountKey, coinParams)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return key, fingerprint, path, nil
+}
+
+// AccountKey returns the extended public key of the account with
+// its key origin
+func (c CoinAccount) AccountKey() (string, error) {
+	key, err := c.Key.Neuter()
+	if err != nil {
+		return "", err
+	}
+
+	xpub := key.String()
+
+	if c.path == nil {
+		return xpub, nil
+	}
+
+	var fp [4]byte
+	binary.LittleEndian.PutUint32(fp[:], c.fingerprint)
+	origin := hex.EncodeToString(fp[:])
+	for _, i := range c.path {
+		if i >= address.HardenedKeyStart {
+			origin += fmt.Sprintf("/%d'", i-address.HardenedKeyStart)
+		} else {
+			origin += fmt.Sprintf("/%d", i)
+		}
+	}
+	return "[" + origin + "]" + xpub, nil
+}
+
+// parseKeyOrigin parses a key origin in the form of fingerprint/path
+func parseKeyOrigin(origin string) (uint32, []uint32, error) {
+	items := strings.Split(origin, "/")
+
+	fp, err := hex.DecodeString(items[0])
+	if err != nil || len(fp) != 4 {
+		return 0, nil, ErrInvalidKeyOrigin
+	}
+
+	path := make([]uint32, 0, len(items)-1)
+	for _, item := range items[1:] {
+		var hardened uint32
+		if strings.HasSuffix(item, "'") || strings.HasSuffix(item, "h") {
+			hardened = address.HardenedKeyStart
+			item = item[:len(item)-1]
+		}
+		i, err := strconv.ParseUint(item, 10, 31)
+		if err != nil {
+			return 0, nil, ErrInvalidKeyOrigin
+		}
+		path = append(path, uint32(i)+hardened)
+	}
+
+	return binary.LittleEndian.Uint32(fp), path, nil
+}
@@ -0,0 +1,157 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bitmark-inc/bitmark-wallet/tx"
+)
+
+func TestWatchOnlyAccountAddress(t *testing.T) {
+	for _, purpose := range []Purpose{BIP44, BIP49, BIP84, BIP86} {
+		w := newTestWallet(t)
+		account, err := w.Account(purpose, BTC, true, 0)
+		assert.NoError(t, err)
+		accountKey, err := account.AccountKey()
+		assert.NoError(t, err)
+		assert.False(t, account.IsWatchOnly())
+
+		watchOnly, err := NewWatchOnlyAccount(accountKey, purpose, BTC, true, testDataFile(t))
+		assert.NoError(t, err, "purpose %d", purpose)
+		assert.True(t, watchOnly.IsWatchOnly())
+		assert.Equal(t, account.identifier, watchOnly.identifier)
+
+		watchOnlyKey, err := watchOnly.AccountKey()
+		assert.NoError(t, err)
+		assert.Equal(t, accountKey, watchOnlyKey)
+
+		for _, change := range []bool{false, true} {
+			for i := uint32(0); i < 3; i++ {
+				expected, err := account.Address(i, change)
+				assert.NoError(t, err)
+				actual, err := watchOnly.Address(i, change)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, actual, "purpose %d", purpose)
+			}
+		}
+
+		account.Close()
+		watchOnly.Close()
+	}
+}
+
+func TestWatchOnlyAccountKey(t *testing.T) {
+	// BIP84 test vector of the mnemonic "abandon abandon ... about"
+	seed, err := hex.DecodeString("5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4")
+	assert.NoError(t, err)
+	w := New(seed, testDataFile(t))
+	account, err := w.Account(BIP84, BTC, false, 0)
+	assert.NoError(t, err)
+	accountKey, err := account.AccountKey()
+	assert.NoError(t, err)
+	account.Close()
+
+	assert.Equal(t, "[73c5da0a/84'/0'/0']", accountKey[:20])
+
+	xpub := accountKey[20:]
+	watchOnly, err := NewWatchOnlyAccount(xpub, BIP84, BTC, false, testDataFile(t))
+	assert.NoError(t, err)
+	defer watchOnly.Close()
+
+	addr, err := watchOnly.Address(0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", addr)
+
+	// without the origin the account key is the plain xpub
+	plain, err := watchOnly.AccountKey()
+	assert.NoError(t, err)
+	assert.Equal(t, xpub, plain)
+}
+
+func TestWatchOnlyAccountInvalidKey(t *testing.T) {
+	w := newTestWallet(t)
+	account, err := w.Account(BIP84, BTC, true, 0)
+	assert.NoError(t, err)
+	accountKey, err := account.AccountKey()
+	assert.NoError(t, err)
+	account.Close()
+
+	xpub := accountKey[strings.Index(accountKey, "]")+1:]
+
+	// a tpub is not for the main net
+	_, err = NewWatchOnlyAccount(xpub, BIP84, BTC, false, testDataFile(t))
+	assert.Equal(t, ErrInvalidAccountKey, err)
+
+	// a broken checksum
+	broken := xpub[:len(xpub)-1] + "1"
+	if broken == xpub {
+		broken = xpub[:len(xpub)-1] + "2"
+	}
+	_, err = NewWatchOnlyAccount(broken, BIP84, BTC, true, testDataFile(t))
+	assert.Error(t, err)
+
+	_, err = NewWatchOnlyAccount("[d34db33f/84'/1'"+xpub, BIP84, BTC, true, testDataFile(t))
+	assert.Equal(t, ErrInvalidKeyOrigin, err)
+	_, err = NewWatchOnlyAccount("[d34db3/84'/1'/0']"+xpub, BIP84, BTC, true, testDataFile(t))
+	assert.Equal(t, ErrInvalidKeyOrigin, err)
+}
+
+func TestWatchOnlyAccountPSBT(t *testing.T) {
+	for _, purpose := range []Purpose{BIP44, BIP49, BIP84, BIP86} {
+		w := newTestWallet(t)
+		account, err := w.Account(purpose, BTC, true, 0)
+		assert.NoError(t, err)
+		accountKey, err := account.AccountKey()
+		assert.NoError(t, err)
+
+		watchOnly, err := NewWatchOnlyAccount(accountKey, purpose, BTC, true, testDataFile(t))
+		assert.NoError(t, err)
+
+		addr, err := watchOnly.Address(0, false)
+		assert.NoError(t, err)
+		script, err := tx.DefaultP2PKScript(addr)
+		assert.NoError(t, err)
+
+		prevTx := wire.NewMsgTx(wire.TxVersion)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+		prevTx.AddTxOut(wire.NewTxOut(100000, script))
+		var buf bytes.Buffer
+		assert.NoError(t, prevTx.Serialize(&buf))
+		prevHash := prevTx.TxHash()
+		watchOnly.SetAgent(&fakeAgent{txs: map[string]string{
+			prevHash.String(): hex.EncodeToString(buf.Bytes()),
+		}})
+
+		utxos := tx.UTXOs{{TxHash: prevHash[:], TxIndex: 0, Value: 100000}}
+		assert.NoError(t, watchOnly.store.SetUTXO(addr, utxos))
+
+		sends := []*tx.Send{{Addr: "mkeFURLRyDugRRP1kwKRcNBZwkVCPPmYkt", Amount: 50000}}
+		_, _, err = watchOnly.Send(sends, nil, 2000)
+		assert.Equal(t, ErrWatchOnly, err)
+
+		p, err := watchOnly.CreatePSBT(sends, nil, 2000)
+		assert.NoError(t, err, "purpose %d", purpose)
+		_, err = watchOnly.SignPSBT(p)
+		assert.Equal(t, ErrWatchOnly, err)
+
+		n, err := account.SignPSBT(p)
+		assert.NoError(t, err, "purpose %d", purpose)
+		assert.Equal(t, 1, n)
+
+		signedTx, err := FinalizePSBT(p)
+		assert.NoError(t, err, "purpose %d", purpose)
+		verifyTx(t, signedTx, tx.UTXOs{{Script: script, Value: 100000}})
+
+		// the fee estimated without keys is enough for the signed transaction
+		fee := 100000 - signedTx.TxOut[0].Value - signedTx.TxOut[1].Value
+		assert.True(t, fee >= int64(txVirtualSize(signedTx))*2000/1000, "purpose %d", purpose)
+
+		account.Close()
+		watchOnly.Close()
+	}
+}